@@ -1,7 +1,7 @@
 import ky from 'ky';
 
 // Production'da Caddy proxy kullanıyoruz, development'ta localhost:8080
-const API_URL = import.meta.env.VITE_API_URL || (
+export const API_URL = import.meta.env.VITE_API_URL || (
   import.meta.env.MODE === 'production' ? '' : 'http://localhost:8080'
 );
 
@@ -25,6 +25,8 @@ export const authAPI = {
   login: (credentials) => api.post('auth/login', { json: credentials }).json(),
   logout: () => api.post('auth/logout').json(),
   getMe: () => api.get('auth/me').json(),
+  providers: () => api.get('auth/providers').json(),
+  ssoLoginURL: () => `${API_URL}/api/v1/auth/oidc/login`,
 };
 
 // Users API
@@ -65,7 +67,9 @@ export const adminsAPI = {
   list: () => api.get('admins').json(),
   create: (data) => api.post('admins', { json: data }).json(),
   get: (id) => api.get(`admins/${id}`).json(),
+  update: (id, data) => api.put(`admins/${id}`, { json: data }).json(),
   delete: (id) => api.delete(`admins/${id}`).json(),
+  restore: (id) => api.post(`admins/${id}/restore`).json(),
 };
 
 // Logs API
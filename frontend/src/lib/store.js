@@ -21,6 +21,11 @@ export const useAuthStore = create((set) => ({
     }
   },
   
+  loginWithToken: (token) => {
+    localStorage.setItem('token', token);
+    set({ token, isAuthenticated: true });
+  },
+
   logout: async () => {
     try {
       await authAPI.logout();
@@ -1,5 +1,6 @@
-import { useState } from 'preact/hooks';
+import { useState, useEffect } from 'preact/hooks';
 import { useAuthStore } from '../lib/store';
+import { authAPI } from '../lib/api';
 import { route } from 'preact-router';
 
 export default function Login() {
@@ -7,7 +8,23 @@ export default function Login() {
   const [password, setPassword] = useState('');
   const [error, setError] = useState('');
   const [loading, setLoading] = useState(false);
+  const [providers, setProviders] = useState({ local: true, oidc: false });
   const login = useAuthStore((state) => state.login);
+  const loginWithToken = useAuthStore((state) => state.loginWithToken);
+
+  useEffect(() => {
+    // SSO callback hands the token over in the URL fragment
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    const token = params.get('token');
+    if (token) {
+      window.history.replaceState(null, '', window.location.pathname);
+      loginWithToken(token);
+      route('/');
+      return;
+    }
+
+    authAPI.providers().then(setProviders).catch(() => {});
+  }, []);
 
   const handleSubmit = async (e) => {
     e.preventDefault();
@@ -39,6 +56,15 @@ export default function Login() {
             </div>
           )}
 
+          {providers.oidc && (
+            <a href={authAPI.ssoLoginURL()} class="btn btn-outline w-full">
+              Kurumsal hesap ile giriş yap
+            </a>
+          )}
+
+          {providers.oidc && providers.local && <div class="divider">veya</div>}
+
+          {providers.local && (
           <form onSubmit={handleSubmit}>
             <div class="form-control">
               <label class="label">
@@ -84,13 +110,18 @@ export default function Login() {
               </button>
             </div>
           </form>
+          )}
 
-          <div class="divider">veya</div>
+          {providers.local && (
+            <>
+              <div class="divider">veya</div>
 
-          <div class="text-center text-sm text-base-content/60">
-            <p>Varsayılan Kullanıcı:</p>
-            <p class="font-mono mt-1">admin / admin123</p>
-          </div>
+              <div class="text-center text-sm text-base-content/60">
+                <p>Varsayılan Kullanıcı:</p>
+                <p class="font-mono mt-1">admin / admin123</p>
+              </div>
+            </>
+          )}
         </div>
       </div>
     </div>
@@ -0,0 +1,159 @@
+package archive
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/chain"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxRowsPerFile bounds the size of one archive file; the next run continues
+	maxRowsPerFile = 50000
+	// pageSize is how many rows are loaded or deleted per query
+	pageSize = 1000
+)
+
+var unsafeName = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// Run archives every log older than its category's retention to gzip
+// compressed NDJSON files in dir and deletes it from the database. Each file
+// gets a signed prune record so the hash chain still verifies.
+func Run(db *gorm.DB, dir string, policy Policy, now time.Time) ([]models.ChainPrune, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	var prunes []models.ChainPrune
+	for _, category := range policy.Categories() {
+		cutoff := now.Add(-policy[category])
+
+		for {
+			prune, err := archiveBatch(db, dir, category, cutoff, now)
+			if err != nil {
+				return prunes, fmt.Errorf("archiving %s logs: %w", category, err)
+			}
+			if prune == nil {
+				break
+			}
+			prunes = append(prunes, *prune)
+			if prune.Rows < maxRowsPerFile {
+				break
+			}
+		}
+	}
+
+	return prunes, nil
+}
+
+// archiveBatch writes up to maxRowsPerFile sealed logs of one category to a
+// new file, then deletes them together with recording the prune. Only rows
+// already in the hash chain are archived.
+func archiveBatch(db *gorm.DB, dir, category string, cutoff, now time.Time) (*models.ChainPrune, error) {
+	file, err := os.CreateTemp(dir, "system_logs-*.partial")
+	if err != nil {
+		return nil, err
+	}
+	partial := file.Name()
+	defer os.Remove(partial)
+
+	digest := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(file, digest))
+	encoder := json.NewEncoder(gz)
+
+	prune := &models.ChainPrune{
+		Chain:    chain.Logs.Name,
+		Category: category,
+		Cutoff:   cutoff,
+	}
+	var ids []uuid.UUID
+
+	for prune.Rows < maxRowsPerFile {
+		var logs []models.SystemLog
+		err := db.Where("category = ? AND created_at < ? AND seq > ?", category, cutoff, prune.LastSeq).
+			Order("seq").
+			Limit(min(pageSize, maxRowsPerFile-int(prune.Rows))).
+			Find(&logs).Error
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		for _, l := range logs {
+			if err := encoder.Encode(l); err != nil {
+				file.Close()
+				return nil, err
+			}
+			if prune.FirstSeq == 0 {
+				prune.FirstSeq = l.Seq
+			}
+			prune.LastSeq = l.Seq
+			prune.LastHash = l.Hash
+			prune.Rows++
+			ids = append(ids, l.ID)
+		}
+
+		if len(logs) < pageSize {
+			break
+		}
+	}
+
+	if prune.Rows == 0 {
+		file.Close()
+		return nil, nil
+	}
+
+	if err := gz.Close(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	prune.ArchiveSHA256 = hex.EncodeToString(digest.Sum(nil))
+	prune.Archive = fmt.Sprintf("system_logs-%s-%s-%d.ndjson.gz",
+		unsafeName.ReplaceAllString(category, "_"), now.UTC().Format("20060102T150405Z"), prune.FirstSeq)
+
+	path := filepath.Join(dir, prune.Archive)
+	if err := os.Rename(partial, path); err != nil {
+		return nil, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var deleted int64
+		for start := 0; start < len(ids); start += pageSize {
+			result := tx.Where("id IN ?", ids[start:min(start+pageSize, len(ids))]).Delete(&models.SystemLog{})
+			if result.Error != nil {
+				return result.Error
+			}
+			deleted += result.RowsAffected
+		}
+		if deleted != prune.Rows {
+			return fmt.Errorf("deleted %d rows, archived %d", deleted, prune.Rows)
+		}
+
+		return chain.RecordPrune(tx, prune)
+	})
+	if err != nil {
+		// Nothing was deleted, so the file would only duplicate live rows
+		os.Remove(path)
+		return nil, err
+	}
+
+	return prune, nil
+}
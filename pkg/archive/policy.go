@@ -0,0 +1,61 @@
+package archive
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy maps a log category to how long its rows are kept. Categories
+// without an entry are kept forever.
+type Policy map[string]time.Duration
+
+// ParsePolicy parses "http=30d,audit=365d". Durations accept a "d" suffix
+// for days in addition to the usual Go units.
+func ParsePolicy(value string) (Policy, error) {
+	policy := Policy{}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		category, raw, ok := strings.Cut(entry, "=")
+		category = strings.TrimSpace(category)
+		if !ok || category == "" {
+			return nil, fmt.Errorf("invalid retention entry %q, expected category=duration", entry)
+		}
+
+		retention, err := parseDuration(strings.TrimSpace(raw))
+		if err != nil || retention <= 0 {
+			return nil, fmt.Errorf("invalid retention for %s: %q", category, raw)
+		}
+		policy[category] = retention
+	}
+
+	return policy, nil
+}
+
+// Categories returns the categories with a retention, sorted
+func (p Policy) Categories() []string {
+	categories := make([]string, 0, len(p))
+	for category := range p {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
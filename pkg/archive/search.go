@@ -0,0 +1,180 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Filter selects archived logs; empty fields match everything
+type Filter struct {
+	Category   string
+	Action     string
+	Resource   string
+	ResourceID string
+	AdminID    string
+	Contains   string
+	From       time.Time
+	To         time.Time
+}
+
+// Matches reports whether the log passes the filter
+func (f Filter) Matches(l models.SystemLog) bool {
+	switch {
+	case f.Category != "" && l.Category != f.Category,
+		f.Action != "" && !strings.Contains(l.Action, f.Action),
+		f.Resource != "" && l.Resource != f.Resource,
+		f.ResourceID != "" && l.ResourceID != f.ResourceID,
+		f.AdminID != "" && (l.AdminID == nil || l.AdminID.String() != f.AdminID),
+		f.Contains != "" && !strings.Contains(l.Details, f.Contains),
+		!f.From.IsZero() && l.CreatedAt.Before(f.From),
+		!f.To.IsZero() && l.CreatedAt.After(f.To):
+		return false
+	}
+	return true
+}
+
+// Files lists the archive files in dir, oldest first
+func Files(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "system_logs-*.ndjson.gz"))
+	sort.Strings(files)
+	return files, err
+}
+
+// Search calls fn for every archived log in dir that matches the filter
+func Search(dir string, filter Filter, fn func(models.SystemLog) error) error {
+	files, err := Files(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range files {
+		if err := searchFile(path, filter, fn); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+	}
+	return nil
+}
+
+func searchFile(path string, filter Filter, fn func(models.SystemLog) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var l models.SystemLog
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return err
+		}
+		if filter.Matches(l) {
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// Restore copies the matching archived logs into restored_logs, where they
+// can be browsed with /logs?archive=true. Logs restored before are skipped.
+func Restore(db *gorm.DB, dir string, filter Filter) (int, error) {
+	restored := 0
+	batch := make([]models.RestoredLog, 0, pageSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&batch)
+		restored += int(result.RowsAffected)
+		batch = batch[:0]
+		return result.Error
+	}
+
+	err := Search(dir, filter, func(l models.SystemLog) error {
+		l.Admin = nil
+		batch = append(batch, models.RestoredLog{SystemLog: l})
+		if len(batch) == pageSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return restored, err
+	}
+
+	return restored, flush()
+}
+
+// FileCheck is the result of checking one archive file against its prune record
+type FileCheck struct {
+	Archive string `json:"archive"`
+	Rows    int64  `json:"rows"`
+	OK      bool   `json:"ok"`
+	Problem string `json:"problem,omitempty"`
+}
+
+// VerifyFiles checks that every archive named by a prune record exists in dir
+// and still has the recorded SHA-256
+func VerifyFiles(db *gorm.DB, dir string) ([]FileCheck, error) {
+	var prunes []models.ChainPrune
+	if err := db.Where("archive <> ''").Order("created_at").Find(&prunes).Error; err != nil {
+		return nil, err
+	}
+
+	checks := make([]FileCheck, 0, len(prunes))
+	for _, p := range prunes {
+		check := FileCheck{Archive: p.Archive, Rows: p.Rows, OK: true}
+
+		sum, err := fileSHA256(filepath.Join(dir, p.Archive))
+		switch {
+		case err != nil:
+			check.OK = false
+			check.Problem = err.Error()
+		case sum != p.ArchiveSHA256:
+			check.OK = false
+			check.Problem = "file does not match the recorded SHA-256"
+		}
+
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(digest, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
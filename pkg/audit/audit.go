@@ -0,0 +1,249 @@
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+)
+
+// Resource types
+const (
+	ResourceAdmin       = "admin"
+	ResourceRole        = "role"
+	ResourceAPIKey      = "api_key"
+	ResourceUser        = "user"
+	ResourceUserGroup   = "user_group"
+	ResourceTransaction = "transaction"
+	ResourceRollups     = "rollups"
+	ResourceCloseout    = "closeout"
+	ResourceCashSession = "cash_session"
+	ResourceStatement   = "statement"
+	ResourceTopUp       = "topup_request"
+	ResourceDevice      = "device"
+	ResourceFraudAlert  = "fraud_alert"
+	ResourceSettlement  = "settlement"
+)
+
+// Actions
+const (
+	AdminCreate  = "admin.create"
+	AdminUpdate  = "admin.update"
+	AdminDelete  = "admin.delete"
+	AdminRestore = "admin.restore"
+
+	RoleCreate = "role.create"
+	RoleUpdate = "role.update"
+	RoleDelete = "role.delete"
+
+	APIKeyCreate = "api_key.create"
+	APIKeyRevoke = "api_key.revoke"
+
+	UserCreate    = "user.create"
+	UserUpdate    = "user.update"
+	UserDelete    = "user.delete"
+	UserSetPIN    = "user.set_pin"
+	UserResetPIN  = "user.reset_pin"
+	UserUnlockPIN = "user.unlock_pin"
+	UserClearPIN  = "user.clear_pin"
+
+	UserGroupCreate = "user_group.create"
+	UserGroupUpdate = "user_group.update"
+	UserGroupDelete = "user_group.delete"
+
+	PortalBlockCard = "portal.block_card"
+	PortalChangePIN = "portal.change_pin"
+
+	TransactionCreate  = "transaction.create"
+	TransactionRequest = "transaction.request"
+	TransactionApprove = "transaction.approve"
+	TransactionReject  = "transaction.reject"
+
+	RollupsRebuild = "rollups.rebuild"
+	CloseoutCreate = "closeout.create"
+
+	CashSessionOpen  = "cash_session.open"
+	CashSessionClose = "cash_session.close"
+
+	StatementsRun  = "statements.run"
+	StatementEmail = "statement.email"
+
+	TopUpApprove = "topup_request.approve"
+	TopUpReject  = "topup_request.reject"
+
+	DeviceCreate = "device.create"
+	DeviceUpdate = "device.update"
+	DeviceDelete = "device.delete"
+
+	FraudAlertReview = "fraud_alert.review"
+
+	SettlementCreate = "settlement.create"
+)
+
+// ignoredFields never count as a change
+var ignoredFields = map[string]bool{
+	"updated_at": true,
+}
+
+// Event describes a change made by an admin or API key
+type Event struct {
+	Action     string
+	Resource   string
+	ResourceID string
+	Before     interface{}
+	After      interface{}
+}
+
+// Change is the old and new value of a single field
+type Change struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// Details is the JSON document stored in SystemLog.Details for audit events
+type Details struct {
+	Actor   Actor                  `json:"actor"`
+	Before  map[string]interface{} `json:"before,omitempty"`
+	After   map[string]interface{} `json:"after,omitempty"`
+	Changes map[string]Change      `json:"changes,omitempty"`
+}
+
+// Actor identifies who performed the change
+type Actor struct {
+	AdminID  *uuid.UUID `json:"admin_id,omitempty"`
+	Username string     `json:"username,omitempty"`
+	APIKeyID *uuid.UUID `json:"api_key_id,omitempty"`
+	APIKey   string     `json:"api_key,omitempty"`
+	// UserID is set for changes users make in the self-service portal
+	UserID *uuid.UUID `json:"user_id,omitempty"`
+}
+
+// Record stores the event in system_logs with the actor taken from the request
+func Record(c *gin.Context, e Event) {
+	entry, err := build(c, e)
+	if err != nil {
+		log.Printf("audit: failed to encode %s %s/%s: %v", e.Action, e.Resource, e.ResourceID, err)
+		return
+	}
+
+	if err := database.DB.Create(&entry).Error; err != nil {
+		log.Printf("audit: failed to store %s %s/%s: %v", e.Action, e.Resource, e.ResourceID, err)
+	}
+}
+
+func build(c *gin.Context, e Event) (models.SystemLog, error) {
+	details := Details{Actor: actorOf(c)}
+
+	var err error
+	if details.Before, err = toMap(e.Before); err != nil {
+		return models.SystemLog{}, err
+	}
+	if details.After, err = toMap(e.After); err != nil {
+		return models.SystemLog{}, err
+	}
+	details.Changes = Diff(details.Before, details.After)
+
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return models.SystemLog{}, err
+	}
+
+	return models.SystemLog{
+		AdminID:    details.Actor.AdminID,
+		APIKeyID:   details.Actor.APIKeyID,
+		Category:   models.LogCategoryAudit,
+		RequestID:  c.GetString("request_id"),
+		Action:     e.Action,
+		Resource:   e.Resource,
+		ResourceID: e.ResourceID,
+		Details:    string(encoded),
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	}, nil
+}
+
+// Diff returns the top-level fields whose values differ between before and after
+func Diff(before, after map[string]interface{}) map[string]Change {
+	changes := map[string]Change{}
+
+	for key, old := range before {
+		if ignoredFields[key] {
+			continue
+		}
+		if updated, ok := after[key]; !ok || !reflect.DeepEqual(old, updated) {
+			changes[key] = Change{From: old, To: after[key]}
+		}
+	}
+	for key, updated := range after {
+		if ignoredFields[key] {
+			continue
+		}
+		if _, ok := before[key]; !ok {
+			changes[key] = Change{From: nil, To: updated}
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// toMap converts a model to its JSON representation, so hidden fields stay hidden
+func toMap(value interface{}) (map[string]interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var out map[string]interface{}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+
+	// Drop preloaded relations, only the record itself is audited
+	for key, v := range out {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			if key != "permissions" && key != "scopes" && key != "allowed_ips" {
+				delete(out, key)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func actorOf(c *gin.Context) Actor {
+	var a Actor
+
+	if adminID, exists := c.Get("admin_id"); exists {
+		if id, ok := adminID.(uuid.UUID); ok {
+			a.AdminID = &id
+		}
+	}
+	a.Username = c.GetString("admin_username")
+
+	if apiKeyID, exists := c.Get("api_key_id"); exists {
+		if id, ok := apiKeyID.(uuid.UUID); ok {
+			a.APIKeyID = &id
+		}
+	}
+	a.APIKey = c.GetString("api_key_name")
+
+	if userID, exists := c.Get("portal_user_id"); exists {
+		if id, ok := userID.(uuid.UUID); ok {
+			a.UserID = &id
+		}
+	}
+
+	return a
+}
@@ -0,0 +1,262 @@
+package cashdrawer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/pdf"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrSessionOpen is returned when opening a session while one is already open
+	ErrSessionOpen = errors.New("a cash session is already open")
+	// ErrSessionClosed is returned when closing a session that is not open
+	ErrSessionClosed = errors.New("cash session is already closed")
+)
+
+// Open starts a cash session for an admin with the float in the drawer.
+// An admin has at most one open session.
+func Open(db *gorm.DB, adminID uuid.UUID, openingFloat float64, note string) (*models.CashSession, error) {
+	session := &models.CashSession{
+		AdminID:      adminID,
+		Status:       models.CashSessionOpen,
+		OpeningFloat: round(openingFloat),
+		OpenNote:     note,
+		OpenedAt:     time.Now().UTC(),
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		current, err := Current(tx, adminID)
+		if err != nil {
+			return err
+		}
+		if current != nil {
+			return ErrSessionOpen
+		}
+		return tx.Create(session).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Current returns the admin's open session, or nil when there is none. The
+// row is locked where supported, so a credit recorded against the session
+// and closing it cannot interleave.
+func Current(tx *gorm.DB, adminID uuid.UUID) (*models.CashSession, error) {
+	query := tx
+	// SQLite serialises writers itself and does not understand FOR UPDATE
+	if tx.Dialector.Name() == "postgres" {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+
+	var session models.CashSession
+	err := query.Where("admin_id = ? AND status = ?", adminID, models.CashSessionOpen).
+		Order("opened_at DESC").
+		First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Close ends a session with the cash counted in the drawer and records the
+// expected cash and the variance
+func Close(db *gorm.DB, id uuid.UUID, countedCash float64, note string, closedByID *uuid.UUID) (*models.CashSession, error) {
+	var session models.CashSession
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx
+		if tx.Dialector.Name() == "postgres" {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+		if err := query.First(&session, id).Error; err != nil {
+			return err
+		}
+		if session.Status != models.CashSessionOpen {
+			return ErrSessionClosed
+		}
+
+		totals, err := SessionTotals(tx, session.ID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		counted := round(countedCash)
+		expected := totals.Expected(session.OpeningFloat)
+		variance := round(counted - expected)
+
+		session.Status = models.CashSessionClosed
+		session.CreditCount = totals.Completed.Count + totals.Pending.Count
+		session.CreditTotal = round(totals.Completed.Amount + totals.Pending.Amount)
+		session.ExpectedCash = &expected
+		session.CountedCash = &counted
+		session.Variance = &variance
+		session.CloseNote = note
+		session.ClosedByID = closedByID
+		session.ClosedAt = &now
+
+		return tx.Omit(clause.Associations).Save(&session).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Total is a count and sum of credits
+type Total struct {
+	Count  int64   `json:"count"`
+	Amount float64 `json:"amount"`
+}
+
+// Totals are the credits of a session by status
+type Totals struct {
+	Completed Total `json:"completed"`
+	Pending   Total `json:"pending"`
+	Rejected  Total `json:"rejected"`
+}
+
+// Expected is the cash that should be in the drawer: the float plus every
+// credit that was taken in cash and not rejected. Credits still waiting for
+// approval count, the cash for them has been taken.
+func (t Totals) Expected(openingFloat float64) float64 {
+	return round(openingFloat + t.Completed.Amount + t.Pending.Amount)
+}
+
+// SessionTotals sums the credits recorded against a session by status
+func SessionTotals(db *gorm.DB, sessionID uuid.UUID) (Totals, error) {
+	var rows []struct {
+		Status models.TransactionStatus
+		Count  int64
+		Amount float64
+	}
+	err := db.Model(&models.Transaction{}).
+		Select("status, COUNT(*) as count, COALESCE(SUM(amount), 0) as amount").
+		Where("cash_session_id = ? AND type = ?", sessionID, models.TypeCredit).
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return Totals{}, err
+	}
+
+	var totals Totals
+	for _, row := range rows {
+		total := Total{Count: row.Count, Amount: round(row.Amount)}
+		switch row.Status {
+		case models.StatusCompleted:
+			totals.Completed = total
+		case models.StatusPending:
+			totals.Pending = total
+		case models.StatusRejected:
+			totals.Rejected = total
+		}
+	}
+	return totals, nil
+}
+
+// Report is a session with every credit recorded against it, for matching
+// the cash handed in to the credits issued. ExpectedCash is recomputed from
+// the credits as they are now; a closed session keeps the figures it was
+// closed with.
+type Report struct {
+	Session      models.CashSession   `json:"session"`
+	Totals       Totals               `json:"totals"`
+	ExpectedCash float64              `json:"expected_cash"`
+	Credits      []models.Transaction `json:"credits"`
+}
+
+// BuildReport loads the credits of a session and totals them
+func BuildReport(db *gorm.DB, session models.CashSession) (*Report, error) {
+	report := &Report{Session: session}
+
+	err := db.Preload("User").
+		Where("cash_session_id = ? AND type = ?", session.ID, models.TypeCredit).
+		Order("created_at, id").
+		Find(&report.Credits).Error
+	if err != nil {
+		return nil, err
+	}
+
+	report.Totals, err = SessionTotals(db, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	report.ExpectedCash = report.Totals.Expected(session.OpeningFloat)
+	return report, nil
+}
+
+// ReportPDF renders the session report on an A4 page to be handed in with
+// the cash
+func ReportPDF(r *Report, loc *time.Location) []byte {
+	s := r.Session
+	doc := pdf.New("Cash session "+s.ID.String(), pdf.Options{})
+	width := doc.Columns()
+	row := func(label string, amount float64) {
+		value := fmt.Sprintf("%.2f", amount)
+		doc.Line("%-*s%s", width-len(value), label, value)
+	}
+
+	cashier := s.AdminID.String()
+	if s.Admin != nil {
+		cashier = s.Admin.Username
+	}
+
+	doc.Heading("CASH SESSION REPORT")
+	doc.Line("Session:  %s", s.ID)
+	doc.Line("Cashier:  %s", cashier)
+	doc.Line("Opened:   %s", s.OpenedAt.In(loc).Format("2006-01-02 15:04:05"))
+	if s.ClosedAt != nil {
+		closedBy := cashier
+		if s.ClosedBy != nil {
+			closedBy = s.ClosedBy.Username
+		}
+		doc.Line("Closed:   %s by %s", s.ClosedAt.In(loc).Format("2006-01-02 15:04:05"), closedBy)
+	} else {
+		doc.Line("OPEN - the session has not been closed")
+	}
+	doc.Line("Timezone: %s", loc)
+	doc.Rule()
+
+	doc.Heading("Credits (%d)", len(r.Credits))
+	for _, t := range r.Credits {
+		label := fmt.Sprintf("  %s %-20.20s %s", t.CreatedAt.In(loc).Format("15:04"), t.User.Name, t.Status)
+		row(label, t.Amount)
+	}
+	doc.Blank()
+
+	row("Opening float", s.OpeningFloat)
+	row(fmt.Sprintf("Completed credits (%d)", r.Totals.Completed.Count), r.Totals.Completed.Amount)
+	row(fmt.Sprintf("Pending credits (%d)", r.Totals.Pending.Count), r.Totals.Pending.Amount)
+	row(fmt.Sprintf("Rejected credits (%d), not expected", r.Totals.Rejected.Count), r.Totals.Rejected.Amount)
+	doc.Rule()
+	row("Expected cash", r.ExpectedCash)
+	if s.CountedCash != nil {
+		row("Counted cash", *s.CountedCash)
+		row("Variance", *s.Variance)
+		if *s.ExpectedCash != r.ExpectedCash {
+			row("Expected cash at close", *s.ExpectedCash)
+		}
+	}
+	if s.CloseNote != "" {
+		doc.Blank()
+		doc.Line("Note: %s", s.CloseNote)
+	}
+
+	return doc.Bytes()
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,287 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+)
+
+// sealBatch is how many rows are loaded at a time while sealing or verifying
+const sealBatch = 500
+
+// link is one sealed (or to be sealed) row reduced to what the chain needs
+type link struct {
+	ID       uuid.UUID
+	Seq      int64
+	PrevHash string
+	Hash     string
+	Content  string
+}
+
+// Chain is a hash chain over the rows of one table
+type Chain struct {
+	Name  string
+	model interface{}
+	links func(query *gorm.DB) ([]link, error)
+	// final limits sealing to rows that will not change any more
+	final func(query *gorm.DB) *gorm.DB
+}
+
+var (
+	// Logs chains system_logs
+	Logs = &Chain{Name: "system_logs", model: &models.SystemLog{}, links: logLinks, final: allRows}
+	// Transactions chains transactions once they are completed or rejected
+	Transactions = &Chain{Name: "transactions", model: &models.Transaction{}, links: transactionLinks, final: resolvedTransactions}
+
+	// All lists every chain
+	All = []*Chain{Logs, Transactions}
+)
+
+// sealMu serialises sealing within the process; lockChain does the same
+// across processes
+var sealMu sync.Mutex
+
+// Get returns the chain with the given name
+func Get(name string) (*Chain, bool) {
+	for _, c := range All {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Hash links content to the previous entry's hash
+func Hash(prevHash, content string) string {
+	sum := sha256.Sum256([]byte(prevHash + "\n" + content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Seal assigns seq, prev_hash and hash to all committed rows that are not yet
+// part of the chain, oldest first. Rows are sealed after commit so a rolled
+// back insert never leaves a hole in the chain, and only once final so a
+// sealed row never changes.
+func (c *Chain) Seal(db *gorm.DB) (int, error) {
+	sealMu.Lock()
+	defer sealMu.Unlock()
+
+	sealed := 0
+	for {
+		n, err := c.sealBatch(db)
+		sealed += n
+		if err != nil || n < sealBatch {
+			return sealed, err
+		}
+	}
+}
+
+func (c *Chain) sealBatch(db *gorm.DB) (int, error) {
+	n := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := c.lock(tx); err != nil {
+			return err
+		}
+
+		head, err := c.head(tx)
+		if err != nil {
+			return err
+		}
+
+		pending, err := c.links(c.final(tx.Model(c.model)).
+			Where("hash = '' OR hash IS NULL").
+			Order("created_at, id").
+			Limit(sealBatch))
+		if err != nil {
+			return err
+		}
+
+		for _, l := range pending {
+			seq := head.Seq + 1
+			hash := Hash(head.Hash, l.Content)
+
+			err := tx.Model(c.model).
+				Where("id = ?", l.ID).
+				UpdateColumns(map[string]interface{}{
+					"seq":       seq,
+					"prev_hash": head.Hash,
+					"hash":      hash,
+				}).Error
+			if err != nil {
+				return err
+			}
+
+			head = link{ID: l.ID, Seq: seq, Hash: hash}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// lock takes a transaction-scoped advisory lock on the chain, so sealers in
+// other processes wait instead of extending the chain from the same head
+func (c *Chain) lock(tx *gorm.DB) error {
+	// SQLite serialises writers itself and has no advisory locks
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	key := fnv.New64a()
+	key.Write([]byte("chain:" + c.Name))
+	return tx.Exec("SELECT pg_advisory_xact_lock(?)", int64(key.Sum64())).Error
+}
+
+// head returns the last sealed entry, or an empty link for an empty chain.
+// When the newest entries have been archived the chain continues from the
+// last archived one.
+func (c *Chain) head(tx *gorm.DB) (link, error) {
+	var head link
+
+	links, err := c.links(tx.Model(c.model).Where("seq > 0").Order("seq DESC").Limit(1))
+	if err != nil {
+		return link{}, err
+	}
+	if len(links) > 0 {
+		head = links[0]
+	}
+
+	var prune models.ChainPrune
+	err = tx.Where("chain = ? AND last_seq > ?", c.Name, head.Seq).Order("last_seq DESC").Limit(1).Find(&prune).Error
+	if err != nil {
+		return link{}, err
+	}
+	if prune.LastSeq > head.Seq {
+		head = link{Seq: prune.LastSeq, Hash: prune.LastHash}
+	}
+
+	return head, nil
+}
+
+// content builds the canonical text that is hashed for a row. Optional
+// fields are only appended when set, so columns added later do not change
+// the hashes of existing rows.
+type content struct {
+	b strings.Builder
+}
+
+func (c *content) field(name, value string) {
+	c.b.WriteString(name)
+	c.b.WriteByte('=')
+	c.b.WriteString(strconv.Quote(value))
+	c.b.WriteByte('\n')
+}
+
+func (c *content) optional(name, value string) {
+	if value != "" {
+		c.field(name, value)
+	}
+}
+
+func (c *content) id(name string, id *uuid.UUID) {
+	if id != nil {
+		c.field(name, id.String())
+	}
+}
+
+func (c *content) time(name string, t time.Time) {
+	// Databases differ in sub-second precision; milliseconds survive all of them
+	c.field(name, strconv.FormatInt(t.UTC().UnixMilli(), 10))
+}
+
+func (c *content) amount(name string, v float64) {
+	c.field(name, strconv.FormatFloat(v, 'f', 2, 64))
+}
+
+func (c *content) String() string {
+	return c.b.String()
+}
+
+// LogContent is the canonical content of a system log
+func LogContent(l models.SystemLog) string {
+	var c content
+	c.field("id", l.ID.String())
+	c.field("category", l.Category)
+	c.field("action", l.Action)
+	c.id("admin_id", l.AdminID)
+	c.id("api_key_id", l.APIKeyID)
+	c.optional("resource", l.Resource)
+	c.optional("resource_id", l.ResourceID)
+	c.optional("details", l.Details)
+	c.optional("ip_address", l.IPAddress)
+	c.optional("user_agent", l.UserAgent)
+	c.time("created_at", l.CreatedAt)
+	c.optional("request_id", l.RequestID)
+	return c.String()
+}
+
+// TransactionContent is the canonical content of a transaction. It is only
+// sealed once completed or rejected, so status, balances and resolution are
+// final and covered too.
+func TransactionContent(t models.Transaction) string {
+	var c content
+	c.field("id", t.ID.String())
+	c.field("user_id", t.UserID.String())
+	c.field("type", string(t.Type))
+	c.amount("amount", t.Amount)
+	c.field("source", string(t.Source))
+	c.id("admin_id", t.AdminID)
+	c.id("api_key_id", t.APIKeyID)
+	c.optional("description", t.Description)
+	c.time("created_at", t.CreatedAt)
+	c.optional("request_id", t.RequestID)
+	c.optional("device_id", t.DeviceID)
+	c.optional("service", t.Service)
+	c.id("cash_session_id", t.CashSessionID)
+	c.field("status", string(t.Status))
+	c.amount("balance_before", t.BalanceBefore)
+	c.amount("balance_after", t.BalanceAfter)
+	c.id("resolved_by_id", t.ResolvedByID)
+	if t.ResolvedAt != nil {
+		c.time("resolved_at", *t.ResolvedAt)
+	}
+	return c.String()
+}
+
+func allRows(query *gorm.DB) *gorm.DB {
+	return query
+}
+
+// resolvedTransactions leaves out pending transactions, whose status and
+// balances still change when they are approved or rejected
+func resolvedTransactions(query *gorm.DB) *gorm.DB {
+	return query.Where("status <> ?", models.StatusPending)
+}
+
+func logLinks(query *gorm.DB) ([]link, error) {
+	var rows []models.SystemLog
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	links := make([]link, len(rows))
+	for i, row := range rows {
+		links[i] = link{ID: row.ID, Seq: row.Seq, PrevHash: row.PrevHash, Hash: row.Hash, Content: LogContent(row)}
+	}
+	return links, nil
+}
+
+func transactionLinks(query *gorm.DB) ([]link, error) {
+	var rows []models.Transaction
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	links := make([]link, len(rows))
+	for i, row := range rows {
+		links[i] = link{ID: row.ID, Seq: row.Seq, PrevHash: row.PrevHash, Hash: row.Hash, Content: TransactionContent(row)}
+	}
+	return links, nil
+}
@@ -0,0 +1,154 @@
+package chain
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+)
+
+// signingKey signs checkpoints; set by LoadSigningKey
+var signingKey ed25519.PrivateKey
+
+// LoadSigningKey reads the base64 ed25519 seed from path, creating a new key
+// file when it does not exist yet
+func LoadSigningKey(path string) error {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return err
+		}
+		encoded := base64.StdEncoding.EncodeToString(seed)
+		if err := os.WriteFile(path, []byte(encoded+"\n"), 0600); err != nil {
+			return fmt.Errorf("writing checkpoint signing key: %w", err)
+		}
+		raw = []byte(encoded)
+	} else if err != nil {
+		return fmt.Errorf("reading checkpoint signing key: %w", err)
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return errors.New("checkpoint signing key must be a base64 encoded 32 byte seed")
+	}
+
+	signingKey = ed25519.NewKeyFromSeed(seed)
+	return nil
+}
+
+// PublicKey returns the base64 public key checkpoints are signed with, or
+// an empty string when no key is loaded
+func PublicKey() string {
+	if signingKey == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(signingKey.Public().(ed25519.PublicKey))
+}
+
+// CheckpointMessage is the exact text that is signed for a checkpoint
+func CheckpointMessage(cp models.ChainCheckpoint) []byte {
+	return []byte(fmt.Sprintf("%s\n%d\n%s\n%d", cp.Chain, cp.Seq, cp.Hash, cp.CreatedAt.UTC().UnixMilli()))
+}
+
+// VerifyCheckpoint checks the checkpoint's signature against its public key
+func VerifyCheckpoint(cp models.ChainCheckpoint) bool {
+	return verifySignature(cp.PublicKey, cp.Signature, CheckpointMessage(cp))
+}
+
+func verifySignature(publicKey, signature string, message []byte) bool {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(key), message, sig)
+}
+
+// Sign signs message with the checkpoint key, for other signed records
+// such as daily closeouts. It returns the public key and the signature.
+func Sign(message []byte) (string, string, error) {
+	if signingKey == nil {
+		return "", "", errors.New("no checkpoint signing key loaded")
+	}
+	return PublicKey(), base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, message)), nil
+}
+
+// VerifySignature checks a signature made with Sign
+func VerifySignature(publicKey, signature string, message []byte) bool {
+	return verifySignature(publicKey, signature, message)
+}
+
+// Checkpoint signs the current head of the chain. It returns nil when the
+// chain is empty or has not grown since the last checkpoint.
+func (c *Chain) Checkpoint(db *gorm.DB) (*models.ChainCheckpoint, error) {
+	if signingKey == nil {
+		return nil, errors.New("no checkpoint signing key loaded")
+	}
+
+	head, err := c.head(db)
+	if err != nil || head.Seq == 0 {
+		return nil, err
+	}
+
+	var last models.ChainCheckpoint
+	err = db.Where("chain = ?", c.Name).Order("seq DESC").Limit(1).Find(&last).Error
+	if err != nil {
+		return nil, err
+	}
+	if last.Seq == head.Seq {
+		return nil, nil
+	}
+
+	cp := models.ChainCheckpoint{
+		Chain:     c.Name,
+		Seq:       head.Seq,
+		Hash:      head.Hash,
+		PublicKey: PublicKey(),
+		// Stored precision differs per database; sign what survives a round trip
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	cp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, CheckpointMessage(cp)))
+
+	if err := db.Create(&cp).Error; err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// PruneMessage is the exact text that is signed for a prune record
+func PruneMessage(p models.ChainPrune) []byte {
+	return []byte(fmt.Sprintf("%s\n%s\n%d\n%d\n%d\n%s\n%d\n%s\n%s\n%d",
+		p.Chain, p.Category, p.Cutoff.UTC().UnixMilli(), p.FirstSeq, p.LastSeq, p.LastHash, p.Rows,
+		p.Archive, p.ArchiveSHA256, p.CreatedAt.UTC().UnixMilli()))
+}
+
+// VerifyPrune checks the prune record's signature against its public key
+func VerifyPrune(p models.ChainPrune) bool {
+	return verifySignature(p.PublicKey, p.Signature, PruneMessage(p))
+}
+
+// RecordPrune signs and stores a prune record inside tx, which should be
+// the transaction that deletes the entries
+func RecordPrune(tx *gorm.DB, p *models.ChainPrune) error {
+	if signingKey == nil {
+		return errors.New("no checkpoint signing key loaded")
+	}
+
+	p.Cutoff = p.Cutoff.UTC().Truncate(time.Millisecond)
+	p.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	p.PublicKey = PublicKey()
+	p.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, PruneMessage(*p)))
+
+	return tx.Create(p).Error
+}
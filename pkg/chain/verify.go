@@ -0,0 +1,216 @@
+package chain
+
+import (
+	"fmt"
+
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+)
+
+// maxProblems caps how many problems a report lists
+const maxProblems = 100
+
+// Problem is a single inconsistency found while verifying a chain
+type Problem struct {
+	Seq     int64  `json:"seq"`
+	ID      string `json:"id,omitempty"`
+	Problem string `json:"problem"`
+}
+
+// Report is the outcome of verifying one chain
+type Report struct {
+	Chain               string    `json:"chain"`
+	OK                  bool      `json:"ok"`
+	Verified            int64     `json:"verified"`
+	HeadSeq             int64     `json:"head_seq"`
+	HeadHash            string    `json:"head_hash"`
+	Unsealed            int64     `json:"unsealed"`
+	Archived            int64     `json:"archived"`
+	CheckpointsVerified int       `json:"checkpoints_verified"`
+	Problems            []Problem `json:"problems"`
+}
+
+func (r *Report) add(seq int64, id, format string, args ...interface{}) {
+	r.OK = false
+	if len(r.Problems) < maxProblems {
+		r.Problems = append(r.Problems, Problem{Seq: seq, ID: id, Problem: fmt.Sprintf(format, args...)})
+	}
+}
+
+// Verify recomputes every hash of the chain and checks that sequence numbers
+// are contiguous and every entry points at its predecessor. Edited rows fail
+// the hash check, deleted rows leave a gap and reordered rows break the
+// predecessor links. Signed checkpoints additionally catch a truncated chain.
+// Gaps left by retention are accepted when signed prune records cover them
+// and account for exactly the number of missing entries.
+func (c *Chain) Verify(db *gorm.DB) (Report, error) {
+	report := Report{Chain: c.Name, OK: true, Problems: []Problem{}}
+
+	prunes, err := c.prunes(db, &report)
+	if err != nil {
+		return report, err
+	}
+
+	if err := db.Model(c.model).Where("hash = '' OR hash IS NULL").Count(&report.Unsealed).Error; err != nil {
+		return report, err
+	}
+
+	var duplicates []int64
+	err = db.Model(c.model).
+		Where("seq > 0").
+		Group("seq").
+		Having("COUNT(*) > 1").
+		Pluck("seq", &duplicates).Error
+	if err != nil {
+		return report, err
+	}
+	for _, seq := range duplicates {
+		report.add(seq, "", "sequence number is used more than once")
+	}
+
+	expected := int64(1)
+	prevHash := ""
+
+	for {
+		links, err := c.links(db.Model(c.model).
+			Where("seq >= ?", expected).
+			Order("seq, id").
+			Limit(sealBatch))
+		if err != nil {
+			return report, err
+		}
+
+		for _, l := range links {
+			if l.Seq < expected {
+				// Duplicate sequence, already reported
+				continue
+			}
+			if l.Seq > expected {
+				if covered(prunes, expected, l.Seq-1) {
+					report.Archived += l.Seq - expected
+				} else if l.Seq-expected == 1 {
+					report.add(l.Seq, l.ID.String(), "entry %d is missing", expected)
+				} else {
+					report.add(l.Seq, l.ID.String(), "entries %d to %d are missing", expected, l.Seq-1)
+				}
+				prevHash = l.PrevHash
+			}
+			if l.PrevHash != prevHash {
+				if l.Seq == 1 {
+					report.add(l.Seq, l.ID.String(), "first entry has a previous hash")
+				} else {
+					report.add(l.Seq, l.ID.String(), "previous hash does not match entry %d", l.Seq-1)
+				}
+			}
+			if Hash(l.PrevHash, l.Content) != l.Hash {
+				report.add(l.Seq, l.ID.String(), "content does not match its hash")
+			}
+
+			report.Verified++
+			report.HeadSeq = l.Seq
+			report.HeadHash = l.Hash
+			expected = l.Seq + 1
+			prevHash = l.Hash
+		}
+
+		if len(links) < sealBatch {
+			break
+		}
+	}
+
+	// Entries archived after the newest remaining one
+	end := expected - 1
+	var pruned, lastPruned int64
+	for _, p := range prunes {
+		pruned += p.Rows
+		lastPruned = max(lastPruned, p.LastSeq)
+	}
+	if lastPruned > end && covered(prunes, end+1, lastPruned) {
+		report.Archived += lastPruned - end
+		end = lastPruned
+	}
+	if pruned != report.Archived {
+		report.add(0, "", "prune records account for %d archived entries, but %d are missing", pruned, report.Archived)
+	}
+
+	if err := c.verifyCheckpoints(db, &report, prunes, end); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// prunes loads the chain's prune records, reporting those with a bad signature
+func (c *Chain) prunes(db *gorm.DB, report *Report) ([]models.ChainPrune, error) {
+	var records []models.ChainPrune
+	if err := db.Where("chain = ?", c.Name).Order("first_seq").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	trusted := PublicKey()
+	valid := records[:0]
+	for _, p := range records {
+		if !VerifyPrune(p) || (trusted != "" && p.PublicKey != trusted) {
+			report.add(p.FirstSeq, p.ID.String(), "prune record signature is invalid")
+			continue
+		}
+		valid = append(valid, p)
+	}
+	return valid, nil
+}
+
+// covered reports whether every entry from first to last lies within a prune record
+func covered(prunes []models.ChainPrune, first, last int64) bool {
+	next := first
+	for _, p := range prunes {
+		if p.FirstSeq <= next && p.LastSeq >= next {
+			next = p.LastSeq + 1
+		}
+		if next > last {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Chain) verifyCheckpoints(db *gorm.DB, report *Report, prunes []models.ChainPrune, end int64) error {
+	var checkpoints []models.ChainCheckpoint
+	if err := db.Where("chain = ?", c.Name).Order("seq").Find(&checkpoints).Error; err != nil {
+		return err
+	}
+
+	trusted := PublicKey()
+
+	for _, cp := range checkpoints {
+		if !VerifyCheckpoint(cp) {
+			report.add(cp.Seq, cp.ID.String(), "checkpoint signature is invalid")
+			continue
+		}
+		if trusted != "" && cp.PublicKey != trusted {
+			report.add(cp.Seq, cp.ID.String(), "checkpoint is signed by an unknown key")
+			continue
+		}
+
+		if cp.Seq > end {
+			report.add(cp.Seq, cp.ID.String(), "chain ends at %d, before checkpointed entry %d", end, cp.Seq)
+			continue
+		}
+
+		links, err := c.links(db.Model(c.model).Where("seq = ?", cp.Seq))
+		if err != nil {
+			return err
+		}
+		if len(links) == 0 && covered(prunes, cp.Seq, cp.Seq) {
+			// The checkpointed entry has been archived since
+			continue
+		}
+		if len(links) == 0 || links[0].Hash != cp.Hash {
+			report.add(cp.Seq, cp.ID.String(), "entry does not match checkpoint")
+			continue
+		}
+
+		report.CheckpointsVerified++
+	}
+
+	return nil
+}
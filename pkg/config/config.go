@@ -3,6 +3,9 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -11,6 +14,8 @@ type Config struct {
 	// Server
 	Host string
 	Port string
+	// TrustedProxies may set the client address with X-Forwarded-For
+	TrustedProxies []string
 
 	// Database
 	DBDriver   string
@@ -28,12 +33,96 @@ type Config struct {
 	// CORS
 	CORSOrigins string
 
+	// Transactions
+	ApprovalThreshold   float64
+	CashSessionRequired bool
+
+	// Reporting
+	BusinessTimezone string
+	CloseoutEnabled  bool
+	CloseoutInterval time.Duration
+	ReceiptHeader    string
+
+	// Monthly statements
+	StatementsEnabled  bool
+	StatementsInterval time.Duration
+	StatementsEmail    bool
+
+	// Outgoing mail
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+	MailDir      string
+
+	// Self-service user portal
+	PortalEnabled         bool
+	PortalJWTSecret       string
+	PortalTokenExpiration time.Duration
+	PortalLinkURL         string
+	PortalLinkExpiration  time.Duration
+	PortalLinkLimit       int
+	PortalLinkIPLimit     int
+	PINMaxAttempts        int
+	PINLockout            time.Duration
+
+	// Scans that need the user's PIN
+	ScanPINThreshold float64
+	ScanPINServices  string
+
+	// Fraud detection on scans
+	FraudEnabled           bool
+	FraudTravelWindow      time.Duration
+	FraudTravelDistanceKm  float64
+	FraudBurstScans        int
+	FraudBurstWindow       time.Duration
+	FraudOpeningHours      string
+	FraudLargeSpendFactor  float64
+	FraudLargeSpendHistory int
+	FraudAutoBlock         string
+	FraudQueueSize         int
+
+	// System log writer
+	LogBufferSize    int
+	LogBatchSize     int
+	LogFlushInterval time.Duration
+	LogDropPolicy    string
+
+	// Log retention
+	LogRetention         string
+	LogArchiveDir        string
+	LogRetentionInterval time.Duration
+
+	// Hash chain
+	ChainSigningKeyFile     string
+	ChainSealInterval       time.Duration
+	ChainCheckpointInterval time.Duration
+
+	// Single sign-on
+	LocalLoginEnabled bool
+	OIDCEnabled       bool
+	OIDCIssuerURL     string
+	OIDCClientID      string
+	OIDCClientSecret  string
+	OIDCRedirectURL   string
+	OIDCScopes        string
+	OIDCGroupsClaim   string
+	OIDCRoleMapping   string
+	OIDCDefaultRole   string
+	OIDCLinkByEmail   bool
+	OIDCFrontendURL   string
+
 	// Environment
 	Environment string
 }
 
 var AppConfig *Config
 
+// defaultPortalJWTSecret is the placeholder portal secret; the portal stays
+// off until it is replaced
+const defaultPortalJWTSecret = "your-portal-secret-change-in-production"
+
 // Load reads configuration from environment variables
 func Load() *Config {
 	// Load .env file if exists
@@ -42,19 +131,84 @@ func Load() *Config {
 	}
 
 	AppConfig = &Config{
-		Host:          getEnv("HOST", "0.0.0.0"),
-		Port:          getEnv("PORT", "8080"),
-		DBDriver:      getEnv("DB_DRIVER", "sqlite"),
-		DBHost:        getEnv("DB_HOST", "localhost"),
-		DBPort:        getEnv("DB_PORT", "5432"),
-		DBUser:        getEnv("DB_USER", "huduser"),
-		DBPassword:    getEnv("DB_PASSWORD", ""),
-		DBName:        getEnv("DB_NAME", "hudautomata"),
-		DBPath:        getEnv("DB_PATH", "./hudautomata.db"),
-		JWTSecret:     getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
-		JWTExpiration: getEnv("JWT_EXPIRATION", "24h"),
-		CORSOrigins:   getEnv("CORS_ORIGINS", "*"),
-		Environment:   getEnv("GIN_MODE", "debug"),
+		Host:                    getEnv("HOST", "0.0.0.0"),
+		Port:                    getEnv("PORT", "8080"),
+		TrustedProxies:          getEnvList("TRUSTED_PROXIES"),
+		DBDriver:                getEnv("DB_DRIVER", "sqlite"),
+		DBHost:                  getEnv("DB_HOST", "localhost"),
+		DBPort:                  getEnv("DB_PORT", "5432"),
+		DBUser:                  getEnv("DB_USER", "huduser"),
+		DBPassword:              getEnv("DB_PASSWORD", ""),
+		DBName:                  getEnv("DB_NAME", "hudautomata"),
+		DBPath:                  getEnv("DB_PATH", "./hudautomata.db"),
+		JWTSecret:               getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
+		JWTExpiration:           getEnv("JWT_EXPIRATION", "24h"),
+		CORSOrigins:             getEnv("CORS_ORIGINS", "*"),
+		ApprovalThreshold:       getEnvFloat("APPROVAL_THRESHOLD", 0),
+		CashSessionRequired:     getEnvBool("CASH_SESSION_REQUIRED", false),
+		BusinessTimezone:        getEnv("BUSINESS_TIMEZONE", "UTC"),
+		CloseoutEnabled:         getEnvBool("CLOSEOUT_ENABLED", true),
+		CloseoutInterval:        getEnvDuration("CLOSEOUT_INTERVAL", time.Hour),
+		ReceiptHeader:           getEnv("RECEIPT_HEADER", "HUD Automata"),
+		StatementsEnabled:       getEnvBool("STATEMENTS_ENABLED", false),
+		StatementsInterval:      getEnvDuration("STATEMENTS_INTERVAL", 6*time.Hour),
+		StatementsEmail:         getEnvBool("STATEMENTS_EMAIL", false),
+		SMTPHost:                getEnv("SMTP_HOST", ""),
+		SMTPPort:                getEnv("SMTP_PORT", "587"),
+		SMTPUsername:            getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:            getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:                getEnv("SMTP_FROM", "hudautomata@localhost"),
+		MailDir:                 getEnv("MAIL_DIR", ""),
+		PortalEnabled:           getEnvBool("PORTAL_ENABLED", true),
+		PortalJWTSecret:         getEnv("PORTAL_JWT_SECRET", defaultPortalJWTSecret),
+		PortalTokenExpiration:   getEnvDuration("PORTAL_TOKEN_EXPIRATION", time.Hour),
+		PortalLinkURL:           getEnv("PORTAL_LINK_URL", "http://localhost:5173/portal/login"),
+		PortalLinkExpiration:    getEnvDuration("PORTAL_LINK_EXPIRATION", 15*time.Minute),
+		PortalLinkLimit:         getEnvInt("PORTAL_LINK_LIMIT", 3),
+		PortalLinkIPLimit:       getEnvInt("PORTAL_LINK_IP_LIMIT", 10),
+		PINMaxAttempts:          getEnvInt("PIN_MAX_ATTEMPTS", 5),
+		PINLockout:              getEnvDuration("PIN_LOCKOUT", 15*time.Minute),
+		ScanPINThreshold:        getEnvFloat("SCAN_PIN_THRESHOLD", 0),
+		ScanPINServices:         getEnv("SCAN_PIN_SERVICES", ""),
+		FraudEnabled:            getEnvBool("FRAUD_ENABLED", true),
+		FraudTravelWindow:       getEnvDuration("FRAUD_TRAVEL_WINDOW", 10*time.Minute),
+		FraudTravelDistanceKm:   getEnvFloat("FRAUD_TRAVEL_DISTANCE_KM", 5),
+		FraudBurstScans:         getEnvInt("FRAUD_BURST_SCANS", 5),
+		FraudBurstWindow:        getEnvDuration("FRAUD_BURST_WINDOW", time.Minute),
+		FraudOpeningHours:       getEnv("FRAUD_OPENING_HOURS", ""),
+		FraudLargeSpendFactor:   getEnvFloat("FRAUD_LARGE_SPEND_FACTOR", 5),
+		FraudLargeSpendHistory:  getEnvInt("FRAUD_LARGE_SPEND_MIN_HISTORY", 5),
+		FraudAutoBlock:          getEnv("FRAUD_AUTO_BLOCK", ""),
+		FraudQueueSize:          getEnvInt("FRAUD_QUEUE_SIZE", 1000),
+		LogBufferSize:           getEnvInt("LOG_BUFFER_SIZE", 10000),
+		LogBatchSize:            getEnvInt("LOG_BATCH_SIZE", 100),
+		LogFlushInterval:        getEnvDuration("LOG_FLUSH_INTERVAL", time.Second),
+		LogDropPolicy:           getEnv("LOG_DROP_POLICY", "drop"),
+		LogRetention:            getEnv("LOG_RETENTION", "http=90d"),
+		LogArchiveDir:           getEnv("LOG_ARCHIVE_DIR", "./archive"),
+		LogRetentionInterval:    getEnvDuration("LOG_RETENTION_INTERVAL", 24*time.Hour),
+		ChainSigningKeyFile:     getEnv("CHAIN_SIGNING_KEY_FILE", "./chain-signing.key"),
+		ChainSealInterval:       getEnvDuration("CHAIN_SEAL_INTERVAL", 2*time.Second),
+		ChainCheckpointInterval: getEnvDuration("CHAIN_CHECKPOINT_INTERVAL", time.Hour),
+		LocalLoginEnabled:       getEnvBool("LOCAL_LOGIN_ENABLED", true),
+		OIDCEnabled:             getEnvBool("OIDC_ENABLED", false),
+		OIDCIssuerURL:           getEnv("OIDC_ISSUER_URL", ""),
+		OIDCClientID:            getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:        getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:         getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/api/v1/auth/oidc/callback"),
+		OIDCScopes:              getEnv("OIDC_SCOPES", "openid profile email"),
+		OIDCGroupsClaim:         getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCRoleMapping:         getEnv("OIDC_ROLE_MAPPING", ""),
+		OIDCDefaultRole:         getEnv("OIDC_DEFAULT_ROLE", ""),
+		OIDCLinkByEmail:         getEnvBool("OIDC_LINK_BY_EMAIL", false),
+		OIDCFrontendURL:         getEnv("OIDC_FRONTEND_URL", ""),
+		Environment:             getEnv("GIN_MODE", "debug"),
+	}
+
+	// Portal tokens signed with the published default secret could be forged
+	if AppConfig.PortalEnabled && AppConfig.PortalJWTSecret == defaultPortalJWTSecret {
+		log.Println("User portal disabled: set PORTAL_JWT_SECRET to enable it")
+		AppConfig.PortalEnabled = false
 	}
 
 	return AppConfig
@@ -66,3 +220,70 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// getEnvList splits a comma separated variable, nil when it is empty
+func getEnvList(key string) []string {
+	var list []string
+	for _, s := range strings.Split(os.Getenv(key), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid value for %s: %q, using %v", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid value for %s: %q, using %v", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("Invalid value for %s: %q, using %v", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("Invalid value for %s: %q, using %v", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
@@ -16,6 +16,9 @@ import (
 
 var DB *gorm.DB
 
+// LogLevel is the SQL log level used by Connect
+var LogLevel = logger.Info
+
 // Connect initializes database connection
 func Connect() error {
 	var err error
@@ -25,7 +28,7 @@ func Connect() error {
 	}
 
 	gormConfig := &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger: logger.Default.LogMode(LogLevel),
 		NowFunc: func() time.Time {
 			return time.Now().UTC()
 		},
@@ -44,7 +47,7 @@ func Connect() error {
 	} else {
 		// SQLite for development
 		dbPath := getEnv("DB_PATH", "./hudautomata.db")
-		DB, err = gorm.Open(sqlite.Open(dbPath), gormConfig)
+		DB, err = gorm.Open(sqlite.New(sqlite.Config{DriverName: sqliteDriver, DSN: dbPath}), gormConfig)
 	}
 
 	if err != nil {
@@ -64,14 +67,35 @@ func Connect() error {
 func AutoMigrate() error {
 	return DB.AutoMigrate(
 		&models.Admin{},
+		&models.Role{},
+		&models.APIKey{},
+		&models.UserGroup{},
 		&models.User{},
 		&models.Transaction{},
+		&models.TransactionApproval{},
+		&models.TransactionRollup{},
+		&models.DailyCloseout{},
+		&models.CashSession{},
+		&models.Statement{},
+		&models.TopUpRequest{},
+		&models.Settlement{},
+		&models.PortalLoginToken{},
 		&models.SystemLog{},
+		&models.ScanEvent{},
+		&models.Device{},
+		&models.FraudAlert{},
+		&models.ChainCheckpoint{},
+		&models.ChainPrune{},
+		&models.RestoredLog{},
 	)
 }
 
-// SeedData creates initial admin user if not exists
+// SeedData creates built-in roles and the initial admin user if not exists
 func SeedData() error {
+	if err := seedRoles(); err != nil {
+		return err
+	}
+
 	var count int64
 	DB.Model(&models.Admin{}).Count(&count)
 	
@@ -100,6 +124,27 @@ func SeedData() error {
 	return nil
 }
 
+// seedRoles creates any built-in role that is missing, leaving edited ones untouched
+func seedRoles() error {
+	for _, role := range models.DefaultRoles() {
+		var existing models.Role
+		err := DB.Where("name = ?", role.Name).First(&existing).Error
+		if err == nil {
+			continue
+		}
+		if err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		if err := DB.Create(&role).Error; err != nil {
+			return err
+		}
+		log.Printf("Built-in role created: %s", role.Name)
+	}
+
+	return nil
+}
+
 func getEnv(key, fallback string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BucketUnit is the width of a time bucket in reports
+type BucketUnit string
+
+const (
+	BucketHour  BucketUnit = "hour"
+	BucketDay   BucketUnit = "day"
+	BucketWeek  BucketUnit = "week"
+	BucketMonth BucketUnit = "month"
+)
+
+// BucketLayout is the text format every dialect returns bucket starts in
+const BucketLayout = "2006-01-02 15:04:05"
+
+// Dialect hides the SQL differences between the supported drivers. Ranges
+// are always computed in Go and passed as parameters, so only expressions
+// that have to run inside the database live here.
+type Dialect interface {
+	// Name returns the GORM dialector name
+	Name() string
+	// DateBucket returns an expression giving the start of the bucket the
+	// column falls in, as wall clock text in BucketLayout for the given
+	// time zone. Weeks start on Monday.
+	DateBucket(column string, unit BucketUnit, loc *time.Location) (string, error)
+}
+
+// zonePattern matches IANA time zone names, which are inlined into SQL
+var zonePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_+\-/]*$`)
+
+// DialectOf returns the dialect for the database behind db
+func DialectOf(db *gorm.DB) Dialect {
+	if db.Dialector.Name() == "postgres" {
+		return postgresDialect{}
+	}
+	return sqliteDialect{}
+}
+
+// ParseBucket parses a bucket start returned by DateBucket for the same zone
+func ParseBucket(value string, loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation(BucketLayout, value, loc)
+}
+
+// BucketStart returns the start of the bucket t falls in, on the wall clock
+// of loc. Weeks start on Monday.
+func BucketStart(t time.Time, unit BucketUnit, loc *time.Location) (time.Time, error) {
+	t = t.In(loc)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+
+	switch unit {
+	case BucketHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc), nil
+	case BucketDay:
+		return day, nil
+	case BucketWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7)), nil
+	case BucketMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc), nil
+	}
+	return time.Time{}, fmt.Errorf("unsupported bucket %q", unit)
+}
+
+// NextBucket returns the start of the bucket following the one starting at start
+func NextBucket(start time.Time, unit BucketUnit) time.Time {
+	switch unit {
+	case BucketHour:
+		return start.Add(time.Hour)
+	case BucketWeek:
+		return start.AddDate(0, 0, 7)
+	case BucketMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// zoneName returns the IANA name of loc, safe to inline into SQL
+func zoneName(loc *time.Location) (string, error) {
+	name := loc.String()
+	if name == "Local" || !zonePattern.MatchString(name) {
+		return "", fmt.Errorf("unsupported time zone %q", name)
+	}
+	return name, nil
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return "sqlite" }
+
+func (sqliteDialect) DateBucket(column string, unit BucketUnit, loc *time.Location) (string, error) {
+	if loc != time.UTC {
+		// SQLite only knows UTC and the server's zone, so other zones are
+		// bucketed by the local_bucket function registered in sqlite.go
+		zone, err := zoneName(loc)
+		if err != nil {
+			return "", err
+		}
+		if _, err := BucketStart(time.Time{}, unit, loc); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("local_bucket(%s, '%s', '%s')", column, zone, unit), nil
+	}
+
+	switch unit {
+	case BucketHour:
+		return fmt.Sprintf("strftime('%%Y-%%m-%%d %%H:00:00', %s)", column), nil
+	case BucketDay:
+		return fmt.Sprintf("strftime('%%Y-%%m-%%d 00:00:00', %s)", column), nil
+	case BucketWeek:
+		// Forward to Sunday (or stay on it), then back to that week's Monday
+		return fmt.Sprintf("date(%s, 'weekday 0', '-6 days') || ' 00:00:00'", column), nil
+	case BucketMonth:
+		return fmt.Sprintf("strftime('%%Y-%%m-01 00:00:00', %s)", column), nil
+	}
+	return "", fmt.Errorf("unsupported bucket %q", unit)
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "postgres" }
+
+func (postgresDialect) DateBucket(column string, unit BucketUnit, loc *time.Location) (string, error) {
+	switch unit {
+	case BucketHour, BucketDay, BucketWeek, BucketMonth:
+	default:
+		return "", fmt.Errorf("unsupported bucket %q", unit)
+	}
+
+	zone, err := zoneName(loc)
+	if err != nil {
+		return "", err
+	}
+
+	// AT TIME ZONE turns the timestamptz into wall clock time in the zone;
+	// DATE_TRUNC weeks are ISO weeks, which start on Monday
+	return fmt.Sprintf("TO_CHAR(DATE_TRUNC('%s', %s AT TIME ZONE '%s'), 'YYYY-MM-DD HH24:MI:SS')", unit, column, zone), nil
+}
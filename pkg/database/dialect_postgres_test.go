@@ -0,0 +1,31 @@
+//go:build postgres
+
+package database
+
+import (
+	"os"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// TestPostgresDialect runs the dialect checks against the database in
+// TEST_POSTGRES_DSN:
+//
+//	TEST_POSTGRES_DSN="host=localhost user=huduser dbname=hudautomata_test sslmode=disable" \
+//	    go test -tags postgres ./pkg/database
+func TestPostgresDialect(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testDialect(t, db)
+}
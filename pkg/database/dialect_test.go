@@ -0,0 +1,210 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestBucketStart(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	newYork := mustLoad(t, "America/New_York")
+	istanbul := mustLoad(t, "Europe/Istanbul")
+
+	tests := []struct {
+		name string
+		at   time.Time
+		unit BucketUnit
+		loc  *time.Location
+		want time.Time
+	}{
+		{"hour", time.Date(2024, 5, 8, 13, 59, 59, 0, time.UTC), BucketHour, time.UTC, time.Date(2024, 5, 8, 13, 0, 0, 0, time.UTC)},
+		{"day in UTC", time.Date(2024, 5, 8, 23, 30, 0, 0, time.UTC), BucketDay, time.UTC, time.Date(2024, 5, 8, 0, 0, 0, 0, time.UTC)},
+		{"day rolls over east of UTC", time.Date(2024, 5, 8, 22, 30, 0, 0, time.UTC), BucketDay, istanbul, time.Date(2024, 5, 9, 0, 0, 0, 0, istanbul)},
+		{"day stays behind west of UTC", time.Date(2024, 5, 9, 2, 0, 0, 0, time.UTC), BucketDay, newYork, time.Date(2024, 5, 8, 0, 0, 0, 0, newYork)},
+		{"week from Wednesday", time.Date(2024, 5, 8, 12, 0, 0, 0, time.UTC), BucketWeek, time.UTC, time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)},
+		{"week from Monday", time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC), BucketWeek, time.UTC, time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)},
+		{"week from Sunday", time.Date(2024, 5, 12, 23, 0, 0, 0, time.UTC), BucketWeek, time.UTC, time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)},
+		{"week across a month", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), BucketWeek, time.UTC, time.Date(2024, 5, 27, 0, 0, 0, 0, time.UTC)},
+		{"week in local time", time.Date(2024, 5, 12, 22, 30, 0, 0, time.UTC), BucketWeek, istanbul, time.Date(2024, 5, 13, 0, 0, 0, 0, istanbul)},
+		{"month", time.Date(2024, 2, 29, 23, 0, 0, 0, time.UTC), BucketMonth, time.UTC, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"month in local time", time.Date(2024, 2, 29, 23, 0, 0, 0, time.UTC), BucketMonth, berlin, time.Date(2024, 3, 1, 0, 0, 0, 0, berlin)},
+		{"day after spring forward", time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC), BucketDay, berlin, time.Date(2024, 3, 31, 0, 0, 0, 0, berlin)},
+		{"hour after fall back", time.Date(2024, 10, 27, 1, 30, 0, 0, time.UTC), BucketHour, berlin, time.Date(2024, 10, 27, 2, 0, 0, 0, time.FixedZone("CET", 3600))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BucketStart(tt.at, tt.unit, tt.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("BucketStart(%s, %s, %s) = %s, want %s", tt.at, tt.unit, tt.loc, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := BucketStart(time.Now(), "year", time.UTC); err == nil {
+		t.Error("BucketStart accepted an unsupported unit")
+	}
+}
+
+func TestNextBucket(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+
+	tests := []struct {
+		name  string
+		start time.Time
+		unit  BucketUnit
+		want  time.Duration
+	}{
+		{"hour", time.Date(2024, 5, 8, 13, 0, 0, 0, berlin), BucketHour, time.Hour},
+		{"day", time.Date(2024, 5, 8, 0, 0, 0, 0, berlin), BucketDay, 24 * time.Hour},
+		{"spring forward day", time.Date(2024, 3, 31, 0, 0, 0, 0, berlin), BucketDay, 23 * time.Hour},
+		{"fall back day", time.Date(2024, 10, 27, 0, 0, 0, 0, berlin), BucketDay, 25 * time.Hour},
+		{"week", time.Date(2024, 5, 6, 0, 0, 0, 0, berlin), BucketWeek, 7 * 24 * time.Hour},
+		{"leap February", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), BucketMonth, 29 * 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NextBucket(tt.start, tt.unit).Sub(tt.start); got != tt.want {
+				t.Errorf("NextBucket(%s, %s) is %s later, want %s", tt.start, tt.unit, got, tt.want)
+			}
+		})
+	}
+}
+
+// bucketRow is a throwaway table for running the dialect SQL against a real database
+type bucketRow struct {
+	ID        uint
+	CreatedAt time.Time
+}
+
+// bucketTimes straddle midnight, the week boundary, month ends and a DST change
+var bucketTimes = []time.Time{
+	time.Date(2024, 2, 29, 22, 30, 0, 0, time.UTC),
+	time.Date(2024, 2, 29, 23, 30, 0, 0, time.UTC),
+	time.Date(2024, 3, 1, 0, 30, 0, 0, time.UTC),
+	time.Date(2024, 3, 30, 23, 30, 0, 0, time.UTC),
+	time.Date(2024, 3, 31, 1, 30, 0, 0, time.UTC),
+	time.Date(2024, 5, 5, 21, 59, 59, 0, time.UTC),
+	time.Date(2024, 5, 5, 22, 0, 0, 0, time.UTC),
+	time.Date(2024, 5, 6, 3, 15, 0, 0, time.UTC),
+	time.Date(2024, 5, 12, 23, 59, 0, 0, time.UTC),
+}
+
+// testDialect checks that DateBucket groups the stored rows exactly like
+// BucketStart does in Go, and that the ranges built from BucketStart and
+// NextBucket select the same rows in the database
+func testDialect(t *testing.T, db *gorm.DB) {
+	t.Helper()
+
+	if err := db.Migrator().DropTable(&bucketRow{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&bucketRow{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Migrator().DropTable(&bucketRow{}) })
+
+	for _, at := range bucketTimes {
+		if err := db.Create(&bucketRow{CreatedAt: at}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dialect := DialectOf(db)
+	zones := []*time.Location{time.UTC, mustLoad(t, "Europe/Berlin"), mustLoad(t, "America/New_York"), mustLoad(t, "Asia/Kolkata")}
+
+	for _, loc := range zones {
+		for _, unit := range []BucketUnit{BucketHour, BucketDay, BucketWeek, BucketMonth} {
+			t.Run(loc.String()+"/"+string(unit), func(t *testing.T) {
+				want := map[string]int64{}
+				for _, at := range bucketTimes {
+					start, err := BucketStart(at, unit, loc)
+					if err != nil {
+						t.Fatal(err)
+					}
+					want[start.Format(BucketLayout)]++
+				}
+
+				bucket, err := dialect.DateBucket("created_at", unit, loc)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var rows []struct {
+					Bucket string
+					Count  int64
+				}
+				err = db.Model(&bucketRow{}).
+					Select(bucket + " AS bucket, COUNT(*) AS count").
+					Group("bucket").
+					Scan(&rows).Error
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(rows) != len(want) {
+					t.Errorf("got %d buckets, want %d: %v", len(rows), len(want), rows)
+				}
+				for _, row := range rows {
+					if want[row.Bucket] != row.Count {
+						t.Errorf("bucket %s has %d rows, want %d", row.Bucket, row.Count, want[row.Bucket])
+					}
+
+					start, err := ParseBucket(row.Bucket, loc)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					// Times are sent to SQL as UTC, which SQLite needs to compare them as text
+					var inRange int64
+					err = db.Model(&bucketRow{}).
+						Where("created_at >= ? AND created_at < ?", start.UTC(), NextBucket(start, unit).UTC()).
+						Count(&inRange).Error
+					if err != nil {
+						t.Fatal(err)
+					}
+					if inRange != row.Count {
+						t.Errorf("range from %s selects %d rows, want %d", row.Bucket, inRange, row.Count)
+					}
+				}
+			})
+		}
+	}
+
+	if _, err := dialect.DateBucket("created_at", "year", time.UTC); err == nil {
+		t.Error("DateBucket accepted an unsupported unit")
+	}
+	if _, err := dialect.DateBucket("created_at", BucketDay, time.FixedZone("x'; DROP TABLE users; --", 0)); err == nil {
+		t.Error("DateBucket inlined an unsafe zone name")
+	}
+}
+
+func TestSQLiteDialect(t *testing.T) {
+	db, err := gorm.Open(sqlite.New(sqlite.Config{
+		DriverName: sqliteDriver,
+		DSN:        filepath.Join(t.TempDir(), "dialect.db"),
+	}), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testDialect(t, db)
+}
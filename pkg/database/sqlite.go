@@ -0,0 +1,88 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver is go-sqlite3 with the functions SQLite lacks registered on
+// every connection
+const sqliteDriver = "sqlite3_hud"
+
+// sqliteTimeLayouts are the formats go-sqlite3 stores times in
+var sqliteTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("local_bucket", localBucket, true)
+		},
+	})
+}
+
+// locations caches loaded time zones, local_bucket runs once per row
+var locations sync.Map
+
+// localBucket is the SQL function local_bucket(value, zone, unit). It
+// returns the start of the bucket the stored time falls in, in the given
+// IANA time zone, as text in BucketLayout.
+func localBucket(value interface{}, zone, unit string) (interface{}, error) {
+	var t time.Time
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case time.Time:
+		t = v
+	case string:
+		parsed, err := parseSQLiteTime(v)
+		if err != nil {
+			return nil, err
+		}
+		t = parsed
+	default:
+		return nil, fmt.Errorf("local_bucket: unsupported value %T", value)
+	}
+
+	loc, err := cachedLocation(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := BucketStart(t, BucketUnit(unit), loc)
+	if err != nil {
+		return nil, err
+	}
+	return start.Format(BucketLayout), nil
+}
+
+func parseSQLiteTime(value string) (time.Time, error) {
+	for _, layout := range sqliteTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("local_bucket: unrecognised time %q", value)
+}
+
+func cachedLocation(zone string) (*time.Location, error) {
+	if loc, ok := locations.Load(zone); ok {
+		return loc.(*time.Location), nil
+	}
+
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(zone, loc)
+	return loc, nil
+}
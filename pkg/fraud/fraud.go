@@ -0,0 +1,411 @@
+package fraud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errAlertOpen is returned inside raise when an alert is already open
+var errAlertOpen = errors.New("alert already open")
+
+// alertCooldown keeps a rule from raising another alert for the same card
+// while one raised within this time is still open
+const alertCooldown = time.Hour
+
+// spendHistory is how far back a card's usual spending is measured
+const spendHistory = 30 * 24 * time.Hour
+
+// Options configure the rules. A zero count, distance or factor turns the
+// rule off.
+type Options struct {
+	// Same card on devices at least TravelDistanceKm apart within TravelWindow
+	TravelWindow     time.Duration
+	TravelDistanceKm float64
+	// BurstScans scans of one card within BurstWindow
+	BurstScans  int
+	BurstWindow time.Duration
+	// OpeningHours as HH:MM-HH:MM in Location, e.g. 07:00-22:00 or
+	// 22:00-06:00 across midnight; empty means always open
+	OpeningHours string
+	// A charge of at least LargeSpendFactor times the card's average charge,
+	// once it has LargeSpendMinHistory charges in the past 30 days
+	LargeSpendFactor     float64
+	LargeSpendMinHistory int
+	// AutoBlock lists the rules whose alerts block the card, comma
+	// separated, or "all"
+	AutoBlock string
+	Location  *time.Location
+}
+
+// Detector checks scans against the fraud rules
+type Detector struct {
+	opts      Options
+	open      int
+	close     int
+	hours     bool
+	autoBlock map[models.FraudRule]bool
+	blockAll  bool
+}
+
+// Default is the detector configured from FRAUD_*, nil when disabled
+var Default *Detector
+
+// Rules lists every rule
+var Rules = []models.FraudRule{
+	models.RuleImpossibleTravel,
+	models.RuleBurst,
+	models.RuleOffHours,
+	models.RuleLargeSpend,
+}
+
+// New creates a detector, validating the opening hours and rule names
+func New(opts Options) (*Detector, error) {
+	d := &Detector{opts: opts, autoBlock: map[models.FraudRule]bool{}}
+	if d.opts.Location == nil {
+		d.opts.Location = time.UTC
+	}
+
+	if opts.OpeningHours != "" {
+		open, close, err := parseHours(opts.OpeningHours)
+		if err != nil {
+			return nil, err
+		}
+		d.open, d.close, d.hours = open, close, true
+	}
+
+	for _, name := range strings.Split(opts.AutoBlock, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+		case name == "all":
+			d.blockAll = true
+		case validRule(models.FraudRule(name)):
+			d.autoBlock[models.FraudRule(name)] = true
+		default:
+			return nil, fmt.Errorf("unknown fraud rule %q", name)
+		}
+	}
+
+	return d, nil
+}
+
+func validRule(rule models.FraudRule) bool {
+	for _, r := range Rules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHours parses HH:MM-HH:MM into minutes after midnight
+func parseHours(value string) (int, int, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("opening hours %q are not HH:MM-HH:MM", value)
+	}
+
+	var minutes [2]int
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return 0, 0, fmt.Errorf("opening hours %q are not HH:MM-HH:MM", value)
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+	if minutes[0] == minutes[1] {
+		return 0, 0, fmt.Errorf("opening hours %q are empty", value)
+	}
+	return minutes[0], minutes[1], nil
+}
+
+// finding is a rule match before it becomes an alert
+type finding struct {
+	rule    models.FraudRule
+	message string
+	details map[string]interface{}
+}
+
+// Inspect checks a stored scan against every rule and raises an alert for
+// each match. A nil detector does nothing. Errors are logged.
+func (d *Detector) Inspect(db *gorm.DB, scan models.ScanEvent) []models.FraudAlert {
+	if d == nil || scan.UserID == nil {
+		return nil
+	}
+
+	checks := []func(*gorm.DB, models.ScanEvent) (*finding, error){
+		d.impossibleTravel,
+		d.burst,
+		d.offHours,
+		d.largeSpend,
+	}
+
+	var alerts []models.FraudAlert
+	for _, check := range checks {
+		f, err := check(db, scan)
+		if err != nil {
+			log.Printf("fraud: checking scan %s: %v", scan.ID, err)
+			continue
+		}
+		if f == nil {
+			continue
+		}
+
+		alert, raised, err := d.raise(db, scan, *f)
+		if err != nil {
+			log.Printf("fraud: raising %s alert for scan %s: %v", f.rule, scan.ID, err)
+			continue
+		}
+		if raised {
+			log.Printf("fraud: %s alert %s for card %s: %s", alert.Rule, alert.ID, alert.RFIDCardID, alert.Message)
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+// raise stores an alert for f unless one is already open for the card and
+// rule, and blocks the card when the rule is set to
+func (d *Detector) raise(db *gorm.DB, scan models.ScanEvent, f finding) (models.FraudAlert, bool, error) {
+	alert := models.FraudAlert{
+		Rule:        f.rule,
+		Status:      models.AlertOpen,
+		UserID:      *scan.UserID,
+		RFIDCardID:  scan.RFIDCardID,
+		DeviceID:    scan.DeviceID,
+		ScanEventID: &scan.ID,
+		Message:     f.message,
+	}
+
+	details, err := json.Marshal(f.details)
+	if err != nil {
+		return alert, false, err
+	}
+	alert.Details = string(details)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Lock the card holder so the cooldown check and the insert cannot
+		// interleave with another inspection of the same card. SQLite
+		// serialises writers itself and does not understand FOR UPDATE.
+		users := tx.Model(&models.User{})
+		if tx.Dialector.Name() == "postgres" {
+			users = users.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+		var user models.User
+		if err := users.Select("id").First(&user, "id = ?", alert.UserID).Error; err != nil {
+			return err
+		}
+
+		var open int64
+		err := tx.Model(&models.FraudAlert{}).
+			Where("user_id = ? AND rule = ? AND status = ? AND created_at >= ?",
+				alert.UserID, alert.Rule, models.AlertOpen, scan.CreatedAt.Add(-alertCooldown).UTC()).
+			Count(&open).Error
+		if err != nil {
+			return err
+		}
+		if open > 0 {
+			return errAlertOpen
+		}
+
+		if d.blockAll || d.autoBlock[f.rule] {
+			result := tx.Model(&models.User{}).
+				Where("id = ? AND card_blocked = ?", alert.UserID, false).
+				UpdateColumns(map[string]interface{}{
+					"card_blocked":      true,
+					"card_blocked_at":   time.Now(),
+					"card_block_reason": "Fraud alert: " + string(f.rule),
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			alert.CardBlocked = result.RowsAffected == 1
+		}
+		return tx.Create(&alert).Error
+	})
+	if errors.Is(err, errAlertOpen) {
+		return alert, false, nil
+	}
+	return alert, err == nil, err
+}
+
+// impossibleTravel matches the card on another device at least
+// TravelDistanceKm away within TravelWindow
+func (d *Detector) impossibleTravel(db *gorm.DB, scan models.ScanEvent) (*finding, error) {
+	if d.opts.TravelDistanceKm <= 0 || d.opts.TravelWindow <= 0 || scan.DeviceID == "" {
+		return nil, nil
+	}
+
+	var here models.Device
+	err := db.Where("device_id = ?", scan.DeviceID).Limit(1).Find(&here).Error
+	if err != nil || !here.HasPosition() {
+		return nil, err
+	}
+
+	var earlier []models.ScanEvent
+	err = db.Where("user_id = ? AND device_id <> ? AND device_id <> '' AND id <> ? AND created_at >= ?",
+		*scan.UserID, scan.DeviceID, scan.ID, scan.CreatedAt.Add(-d.opts.TravelWindow).UTC()).
+		Order("created_at DESC").
+		Limit(20).
+		Find(&earlier).Error
+	if err != nil || len(earlier) == 0 {
+		return nil, err
+	}
+
+	var ids []string
+	for _, e := range earlier {
+		ids = append(ids, e.DeviceID)
+	}
+	var devices []models.Device
+	if err := db.Where("device_id IN ?", ids).Find(&devices).Error; err != nil {
+		return nil, err
+	}
+	positions := map[string]models.Device{}
+	for _, dev := range devices {
+		if dev.HasPosition() {
+			positions[dev.DeviceID] = dev
+		}
+	}
+
+	for _, e := range earlier {
+		there, ok := positions[e.DeviceID]
+		if !ok {
+			continue
+		}
+		km := distanceKm(*here.Latitude, *here.Longitude, *there.Latitude, *there.Longitude)
+		if km < d.opts.TravelDistanceKm {
+			continue
+		}
+
+		apart := scan.CreatedAt.Sub(e.CreatedAt)
+		return &finding{
+			rule: models.RuleImpossibleTravel,
+			message: fmt.Sprintf("Card used on %s and %s, %.1f km apart, within %s",
+				e.DeviceID, scan.DeviceID, km, apart.Round(time.Second)),
+			details: map[string]interface{}{
+				"previous_device":  e.DeviceID,
+				"previous_scan_id": e.ID,
+				"distance_km":      math.Round(km*10) / 10,
+				"seconds_apart":    int64(apart.Seconds()),
+			},
+		}, nil
+	}
+	return nil, nil
+}
+
+// burst matches BurstScans or more scans of the card within BurstWindow
+func (d *Detector) burst(db *gorm.DB, scan models.ScanEvent) (*finding, error) {
+	if d.opts.BurstScans <= 0 || d.opts.BurstWindow <= 0 {
+		return nil, nil
+	}
+
+	var count int64
+	err := db.Model(&models.ScanEvent{}).
+		Where("user_id = ? AND created_at >= ? AND created_at <= ?",
+			*scan.UserID, scan.CreatedAt.Add(-d.opts.BurstWindow).UTC(), scan.CreatedAt.UTC()).
+		Count(&count).Error
+	if err != nil || count < int64(d.opts.BurstScans) {
+		return nil, err
+	}
+
+	return &finding{
+		rule:    models.RuleBurst,
+		message: fmt.Sprintf("%d scans within %s", count, d.opts.BurstWindow),
+		details: map[string]interface{}{
+			"scans":          count,
+			"window_seconds": int64(d.opts.BurstWindow.Seconds()),
+		},
+	}, nil
+}
+
+// offHours matches scans outside the opening hours
+func (d *Detector) offHours(db *gorm.DB, scan models.ScanEvent) (*finding, error) {
+	if !d.hours {
+		return nil, nil
+	}
+
+	local := scan.CreatedAt.In(d.opts.Location)
+	minute := local.Hour()*60 + local.Minute()
+
+	var open bool
+	if d.open < d.close {
+		open = minute >= d.open && minute < d.close
+	} else {
+		open = minute >= d.open || minute < d.close
+	}
+	if open {
+		return nil, nil
+	}
+
+	return &finding{
+		rule:    models.RuleOffHours,
+		message: fmt.Sprintf("Scan at %s, outside opening hours %s", local.Format("15:04"), d.opts.OpeningHours),
+		details: map[string]interface{}{
+			"local_time":    local.Format(time.RFC3339),
+			"opening_hours": d.opts.OpeningHours,
+		},
+	}, nil
+}
+
+// largeSpend matches an approved charge of at least LargeSpendFactor times
+// the card's average charge over the past 30 days
+func (d *Detector) largeSpend(db *gorm.DB, scan models.ScanEvent) (*finding, error) {
+	if d.opts.LargeSpendFactor <= 0 || scan.Outcome != models.ScanApproved || scan.ServiceCost <= 0 {
+		return nil, nil
+	}
+
+	var history struct {
+		Count   int64
+		Average float64
+	}
+	query := db.Model(&models.Transaction{}).
+		Select("COUNT(*) AS count, COALESCE(AVG(amount), 0) AS average").
+		Where("user_id = ? AND type = ? AND source = ? AND status = ? AND created_at >= ?",
+			*scan.UserID, models.TypeDebit, models.SourceAutomation, models.StatusCompleted,
+			scan.CreatedAt.Add(-spendHistory).UTC())
+	if scan.TransactionID != nil {
+		query = query.Where("id <> ?", *scan.TransactionID)
+	}
+	if err := query.Scan(&history).Error; err != nil {
+		return nil, err
+	}
+
+	if history.Count < int64(d.opts.LargeSpendMinHistory) || history.Count == 0 {
+		return nil, nil
+	}
+	if scan.ServiceCost < d.opts.LargeSpendFactor*history.Average {
+		return nil, nil
+	}
+
+	return &finding{
+		rule: models.RuleLargeSpend,
+		message: fmt.Sprintf("Charge of %.2f is %.1f times the usual %.2f",
+			scan.ServiceCost, scan.ServiceCost/history.Average, history.Average),
+		details: map[string]interface{}{
+			"amount":          scan.ServiceCost,
+			"average":         math.Round(history.Average*100) / 100,
+			"history_charges": history.Count,
+		},
+	}, nil
+}
+
+// distanceKm is the great-circle distance between two points
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	rad := math.Pi / 180
+
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
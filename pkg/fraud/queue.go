@@ -0,0 +1,115 @@
+package fraud
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Scans is the queue scans are inspected from, set up by Start; nil when
+// fraud detection is disabled
+var Scans *Queue
+
+// Start creates the default queue feeding the Default detector
+func Start(db *gorm.DB, size int) *Queue {
+	Scans = NewQueue(Default, db, size)
+	return Scans
+}
+
+// Queue hands scans to a single background inspector through a bounded
+// buffer, so a burst of scans never piles up inspections
+type Queue struct {
+	detector *Detector
+	db       *gorm.DB
+
+	scans chan models.ScanEvent
+	done  chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+
+	dropped atomic.Uint64
+}
+
+// NewQueue creates a queue with room for size scans and starts its inspector
+func NewQueue(d *Detector, db *gorm.DB, size int) *Queue {
+	if size <= 0 {
+		size = 1000
+	}
+
+	q := &Queue{
+		detector: d,
+		db:       db,
+		scans:    make(chan models.ScanEvent, size),
+		done:     make(chan struct{}),
+	}
+
+	go q.run()
+	return q
+}
+
+// Submit queues a scan for inspection. It returns false when the scan was
+// dropped because the queue is full or closed. A nil queue drops every scan.
+func (q *Queue) Submit(scan models.ScanEvent) bool {
+	if q == nil {
+		return false
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		q.dropped.Add(1)
+		return false
+	}
+
+	select {
+	case q.scans <- scan:
+		return true
+	default:
+		q.dropped.Add(1)
+		return false
+	}
+}
+
+// Close stops accepting scans and waits until the queued ones are inspected
+// or ctx expires
+func (q *Queue) Close(ctx context.Context) error {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.scans)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Dropped returns how many scans were not inspected because the queue was
+// full or closed
+func (q *Queue) Dropped() uint64 {
+	if q == nil {
+		return 0
+	}
+	return q.dropped.Load()
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+
+	for scan := range q.scans {
+		q.detector.Inspect(q.db, scan)
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// actor identifies who performs a request: a logged-in admin or an API key
+type actor struct {
+	AdminID     *uuid.UUID
+	APIKeyID    *uuid.UUID
+	Permissions []string
+}
+
+// currentActor returns the authenticated actor of the request
+func currentActor(c *gin.Context) actor {
+	var a actor
+
+	if adminID, exists := c.Get("admin_id"); exists {
+		if id, ok := adminID.(uuid.UUID); ok {
+			a.AdminID = &id
+		}
+	}
+	if apiKeyID, exists := c.Get("api_key_id"); exists {
+		if id, ok := apiKeyID.(uuid.UUID); ok {
+			a.APIKeyID = &id
+		}
+	}
+	if perms, exists := c.Get("admin_permissions"); exists {
+		a.Permissions, _ = perms.([]string)
+	}
+
+	return a
+}
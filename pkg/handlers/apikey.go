@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/audit"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/utils"
+)
+
+// ListAPIKeys returns all API keys
+func ListAPIKeys(c *gin.Context) {
+	var keys []models.APIKey
+	if err := database.DB.Preload("CreatedBy").Order("created_at DESC").Find(&keys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// CreateAPIKey creates an API key; the plain key is only returned here
+func CreateAPIKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if invalid := invalidPermission(req.Scopes); invalid != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope: " + invalid})
+		return
+	}
+	for _, scope := range req.Scopes {
+		for _, forbidden := range models.ForbiddenAPIKeyScopes {
+			if scope == forbidden {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Scope not allowed for API keys: " + scope})
+				return
+			}
+		}
+	}
+	if denied := ungrantable(currentActor(c), req.Scopes); denied != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant a scope you do not hold: " + denied})
+		return
+	}
+
+	for _, entry := range req.AllowedIPs {
+		if !utils.ValidIPEntry(entry) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP or CIDR: " + entry})
+			return
+		}
+	}
+
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+		return
+	}
+
+	plain, prefix, hash, err := utils.GenerateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+
+	key := models.APIKey{
+		Name:                  req.Name,
+		Prefix:                prefix,
+		KeyHash:               hash,
+		Scopes:                req.Scopes,
+		AllowedIPs:            req.AllowedIPs,
+		MaxTransactionAmount:  req.MaxTransactionAmount,
+		DailyTransactionLimit: req.DailyTransactionLimit,
+		ExpiresAt:             req.ExpiresAt,
+		CreatedByID:           currentActor(c).AdminID,
+	}
+
+	if err := database.DB.Create(&key).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.APIKeyCreate,
+		Resource:   audit.ResourceAPIKey,
+		ResourceID: key.ID.String(),
+		After:      key,
+	})
+
+	c.JSON(http.StatusCreated, models.CreateAPIKeyResponse{
+		Key:    plain,
+		APIKey: key,
+	})
+}
+
+// GetAPIKey returns a single API key
+func GetAPIKey(c *gin.Context) {
+	id := c.Param("id")
+	keyID, err := uuid.Parse(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	var key models.APIKey
+	if err := database.DB.Preload("CreatedBy").First(&key, keyID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+// RevokeAPIKey revokes an API key; revoked keys are kept for attribution
+func RevokeAPIKey(c *gin.Context) {
+	id := c.Param("id")
+	keyID, err := uuid.Parse(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	var key models.APIKey
+	if err := database.DB.First(&key, keyID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	if key.RevokedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "API key is already revoked"})
+		return
+	}
+
+	before := key
+	now := time.Now()
+	key.RevokedAt = &now
+
+	if err := database.DB.Save(&key).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.APIKeyRevoke,
+		Resource:   audit.ResourceAPIKey,
+		ResourceID: key.ID.String(),
+		Before:     before,
+		After:      key,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm/logger"
+)
+
+func TestCreateAPIKeyScopes(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	database.LogLevel = logger.Silent
+	if err := database.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api-keys", func(c *gin.Context) {
+		c.Set("admin_permissions", []string{models.PermAPIKeysManage, models.PermUsersRead})
+	}, CreateAPIKey)
+
+	tests := []struct {
+		name   string
+		scopes string
+		want   int
+	}{
+		{"held scope", `["users:read"]`, http.StatusCreated},
+		{"scope the admin lacks", `["users:read", "users:write"]`, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := bytes.NewBufferString(`{"name": "kiosk", "scopes": ` + tt.scopes + `}`)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api-keys", body))
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
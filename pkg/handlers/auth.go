@@ -1,18 +1,31 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/audit"
+	"github.com/lazypwny751/hudautomata/pkg/config"
 	"github.com/lazypwny751/hudautomata/pkg/database"
 	"github.com/lazypwny751/hudautomata/pkg/models"
 	"github.com/lazypwny751/hudautomata/pkg/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// errLastSuperAdmin is returned when a change would leave no active super admin
+var errLastSuperAdmin = errors.New("last super admin")
+
 // Login handles admin login
 func Login(c *gin.Context) {
+	if !config.AppConfig.LocalLoginEnabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password login is disabled, use single sign-on"})
+		return
+	}
+
 	var req models.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -30,13 +43,19 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	if !utils.CheckPasswordHash(req.Password, admin.PasswordHash) {
+	if admin.AuthProvider == models.AuthProviderOIDC || !utils.CheckPasswordHash(req.Password, admin.PasswordHash) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
+	role, err := loadRole(admin.Role)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Assigned role does not exist"})
+		return
+	}
+
 	// Generate token
-	token, expiresAt, err := utils.GenerateToken(admin.ID, admin.Username, string(admin.Role))
+	token, expiresAt, err := utils.GenerateToken(admin.ID, admin.Username, string(admin.Role), role.Permissions)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
@@ -48,16 +67,28 @@ func Login(c *gin.Context) {
 	database.DB.Save(&admin)
 
 	c.JSON(http.StatusOK, models.LoginResponse{
-		Token:     token,
-		Admin:     admin,
-		ExpiresAt: expiresAt,
+		Token:       token,
+		Admin:       admin,
+		Permissions: role.Permissions,
+		ExpiresAt:   expiresAt,
 	})
 }
 
-// GetMe returns current admin info
+// GetMe returns current admin info, or the API key used to authenticate
 func GetMe(c *gin.Context) {
+	act := currentActor(c)
+	if act.APIKeyID != nil {
+		var key models.APIKey
+		if err := database.DB.First(&key, *act.APIKeyID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		c.JSON(http.StatusOK, key)
+		return
+	}
+
 	adminID, _ := c.Get("admin_id")
-	
+
 	var admin models.Admin
 	if err := database.DB.First(&admin, adminID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Admin not found"})
@@ -76,7 +107,15 @@ func Logout(c *gin.Context) {
 // ListAdmins returns all admins (super admin only)
 func ListAdmins(c *gin.Context) {
 	var admins []models.Admin
-	if err := database.DB.Find(&admins).Error; err != nil {
+
+	query := database.DB.Model(&models.Admin{})
+
+	// List soft-deleted admins instead, so they can be restored
+	if c.Query("deleted") == "true" {
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+
+	if err := query.Order("created_at ASC").Find(&admins).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch admins"})
 		return
 	}
@@ -92,6 +131,15 @@ func CreateAdmin(c *gin.Context) {
 		return
 	}
 
+	role, err := loadRole(req.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+	if !assignable(c, role) {
+		return
+	}
+
 	// Hash password
 	hashedPassword, err := utils.HashPassword(req.Password)
 	if err != nil {
@@ -105,6 +153,7 @@ func CreateAdmin(c *gin.Context) {
 		PasswordHash: hashedPassword,
 		Role:         req.Role,
 		IsActive:     true,
+		AuthProvider: models.AuthProviderLocal,
 	}
 
 	if err := database.DB.Create(&admin).Error; err != nil {
@@ -112,6 +161,13 @@ func CreateAdmin(c *gin.Context) {
 		return
 	}
 
+	audit.Record(c, audit.Event{
+		Action:     audit.AdminCreate,
+		Resource:   audit.ResourceAdmin,
+		ResourceID: admin.ID.String(),
+		After:      admin,
+	})
+
 	c.JSON(http.StatusCreated, admin)
 }
 
@@ -133,6 +189,106 @@ func GetAdmin(c *gin.Context) {
 	c.JSON(http.StatusOK, admin)
 }
 
+// UpdateAdmin updates email, role or active status of an admin (super admin only)
+func UpdateAdmin(c *gin.Context) {
+	id := c.Param("id")
+	adminID, err := uuid.Parse(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid admin ID"})
+		return
+	}
+
+	var req models.UpdateAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var admin models.Admin
+	if err := database.DB.First(&admin, adminID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Admin not found"})
+		return
+	}
+
+	if !manageable(c, admin) {
+		return
+	}
+
+	// Admins cannot raise their own rights
+	self := currentActor(c).AdminID
+	if self != nil && *self == adminID {
+		if (req.Role != "" && req.Role != admin.Role) || req.MaxTransactionAmount != nil ||
+			req.DailyTransactionLimit != nil || req.ApprovalThreshold != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Cannot change your own role or limits"})
+			return
+		}
+	}
+
+	before := admin
+
+	if req.Email != "" {
+		admin.Email = req.Email
+	}
+	if req.Role != "" && req.Role != admin.Role {
+		role, err := loadRole(req.Role)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+			return
+		}
+		if !assignable(c, role) {
+			return
+		}
+		admin.Role = req.Role
+	}
+	if req.IsActive != nil {
+		admin.IsActive = *req.IsActive
+	}
+	if req.MaxTransactionAmount != nil {
+		admin.MaxTransactionAmount = limitOrNil(*req.MaxTransactionAmount)
+	}
+	if req.DailyTransactionLimit != nil {
+		admin.DailyTransactionLimit = limitOrNil(*req.DailyTransactionLimit)
+	}
+	if req.ApprovalThreshold != nil {
+		admin.ApprovalThreshold = limitOrNil(*req.ApprovalThreshold)
+	}
+
+	// Prevent locking yourself out
+	currentAdminID, _ := c.Get("admin_id")
+	if currentAdminID == adminID && !admin.IsActive {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot deactivate yourself"})
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Demoting or deactivating must leave at least one active super admin
+		if isActiveSuperAdmin(before) && !isActiveSuperAdmin(admin) {
+			if err := guardLastSuperAdmin(tx, before.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Save(&admin).Error
+	})
+	if errors.Is(err, errLastSuperAdmin) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot demote or deactivate the last super admin"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update admin"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.AdminUpdate,
+		Resource:   audit.ResourceAdmin,
+		ResourceID: admin.ID.String(),
+		Before:     before,
+		After:      admin,
+	})
+
+	c.JSON(http.StatusOK, admin)
+}
+
 // DeleteAdmin deletes an admin (super admin only)
 func DeleteAdmin(c *gin.Context) {
 	id := c.Param("id")
@@ -149,10 +305,119 @@ func DeleteAdmin(c *gin.Context) {
 		return
 	}
 
-	if err := database.DB.Delete(&models.Admin{}, adminID).Error; err != nil {
+	var admin models.Admin
+	if err := database.DB.First(&admin, adminID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Admin not found"})
+		return
+	}
+
+	if !manageable(c, admin) {
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if isActiveSuperAdmin(admin) {
+			if err := guardLastSuperAdmin(tx, admin.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&admin).Error
+	})
+	if errors.Is(err, errLastSuperAdmin) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot delete the last super admin"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete admin"})
 		return
 	}
 
+	audit.Record(c, audit.Event{
+		Action:     audit.AdminDelete,
+		Resource:   audit.ResourceAdmin,
+		ResourceID: admin.ID.String(),
+		Before:     admin,
+	})
+
 	c.JSON(http.StatusOK, gin.H{"message": "Admin deleted successfully"})
 }
+
+// RestoreAdmin restores a soft-deleted admin (super admin only)
+func RestoreAdmin(c *gin.Context) {
+	id := c.Param("id")
+	adminID, err := uuid.Parse(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid admin ID"})
+		return
+	}
+
+	var admin models.Admin
+	if err := database.DB.Unscoped().Where("deleted_at IS NOT NULL").First(&admin, adminID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deleted admin not found"})
+		return
+	}
+
+	if !manageable(c, admin) {
+		return
+	}
+
+	if err := database.DB.Unscoped().Model(&admin).Update("deleted_at", nil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore admin"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.AdminRestore,
+		Resource:   audit.ResourceAdmin,
+		ResourceID: admin.ID.String(),
+		After:      admin,
+	})
+
+	c.JSON(http.StatusOK, admin)
+}
+
+// manageable checks that the actor may change the admin: an admin whose role
+// grants "*" can only be changed by another holder of "*". The error response
+// has been written if not.
+func manageable(c *gin.Context, admin models.Admin) bool {
+	if models.HasPermission(currentActor(c).Permissions, models.PermAll) {
+		return true
+	}
+
+	role, err := loadRole(admin.Role)
+	if err == nil && models.HasPermission(role.Permissions, models.PermAll) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only super admins can change a super admin"})
+		return false
+	}
+	return true
+}
+
+// isActiveSuperAdmin reports whether the admin currently holds super admin rights
+func isActiveSuperAdmin(admin models.Admin) bool {
+	return admin.Role == models.RoleSuperAdmin && admin.IsActive
+}
+
+// guardLastSuperAdmin returns errLastSuperAdmin when no active super admin
+// other than adminID exists. It runs inside the transaction that demotes or
+// removes the admin and locks every active super admin row where supported,
+// so concurrent demotions are serialised and re-check what the first one
+// left behind. SQLite serialises writers itself.
+func guardLastSuperAdmin(tx *gorm.DB, adminID uuid.UUID) error {
+	query := tx.Model(&models.Admin{}).
+		Where("role = ? AND is_active = ?", models.RoleSuperAdmin, true).
+		Order("id")
+	if tx.Dialector.Name() == "postgres" {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+
+	var ids []uuid.UUID
+	if err := query.Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id != adminID {
+			return nil
+		}
+	}
+	return errLastSuperAdmin
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm/logger"
+)
+
+func TestUpdateAdminGuards(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	database.LogLevel = logger.Silent
+	if err := database.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.SeedData(); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := models.Admin{Username: "manager", Email: "manager@example.com", Role: models.RoleAdmin, IsActive: true}
+	boss := models.Admin{Username: "boss", Email: "boss@example.com", Role: models.RoleSuperAdmin, IsActive: true}
+	for _, a := range []*models.Admin{&manager, &boss} {
+		if err := database.DB.Create(a).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	role, err := loadRole(models.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/admins/:id", func(c *gin.Context) {
+		c.Set("admin_id", manager.ID)
+		c.Set("admin_permissions", []string(role.Permissions))
+	}, UpdateAdmin)
+
+	tests := []struct {
+		name   string
+		target models.Admin
+		body   string
+		want   int
+	}{
+		{"own daily limit", manager, `{"daily_transaction_limit": 100000}`, http.StatusForbidden},
+		{"own approval threshold", manager, `{"approval_threshold": 100000}`, http.StatusForbidden},
+		{"own role", manager, `{"role": "cashier"}`, http.StatusForbidden},
+		{"own email", manager, `{"email": "me@example.com"}`, http.StatusOK},
+		{"deactivate a super admin", boss, `{"is_active": false}`, http.StatusForbidden},
+		{"demote a super admin", boss, `{"role": "cashier"}`, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/admins/"+tt.target.ID.String(), bytes.NewBufferString(tt.body))
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -1,91 +1,314 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lazypwny751/hudautomata/pkg/config"
 	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/fraud"
+	"github.com/lazypwny751/hudautomata/pkg/ledger"
 	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/pin"
+	"github.com/lazypwny751/hudautomata/pkg/reports"
+	"github.com/lazypwny751/hudautomata/pkg/spending"
 	"gorm.io/gorm"
 )
 
 // AutomationScan handles RFID scan from automation device
 func AutomationScan(c *gin.Context) {
+	start := time.Now()
+	scan := models.ScanEvent{
+		Outcome:   models.ScanError,
+		RequestID: c.GetString("request_id"),
+		IPAddress: c.ClientIP(),
+	}
+	// Every scan is recorded, including rejected ones
+	defer recordScan(&scan, start)
+
 	var req models.AutomationScanRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	err := c.ShouldBindJSON(&req)
+	scan.RFIDCardID = req.RFIDCardID
+	scan.DeviceID = req.DeviceID
+	scan.Service = req.Service
+	scan.ServiceCost = req.ServiceCost
+	if err != nil {
+		scan.Outcome = models.ScanInvalidRequest
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": scan.Outcome})
 		return
 	}
 
 	// Find user by RFID
 	var user models.User
-	if err := database.DB.Where("rfid_card_id = ? AND is_active = ?", req.RFIDCardID, true).First(&user).Error; err != nil {
+	if err := database.DB.Where("rfid_card_id = ?", req.RFIDCardID).First(&user).Error; err != nil || !user.IsActive {
+		scan.Outcome = models.ScanUnknownCard
+		if err == nil {
+			scan.Outcome = models.ScanInactiveUser
+			scan.UserID = &user.ID
+		}
 		c.JSON(http.StatusOK, models.AutomationScanResponse{
 			Success: false,
+			Code:    scan.Outcome,
 			Message: "RFID kartı kayıtlı değil veya kullanıcı aktif değil",
 		})
 		return
 	}
+	scan.UserID = &user.ID
+	scan.Balance = &user.Balance
 
-	// Check balance
-	if user.Balance < req.ServiceCost {
+	if user.CardBlocked {
+		scan.Outcome = models.ScanCardBlocked
 		c.JSON(http.StatusOK, models.AutomationScanResponse{
-			Success:        false,
-			UserID:         user.ID,
-			UserName:       user.Name,
-			CurrentBalance: user.Balance,
-			RequiredAmount: req.ServiceCost,
-			Deficit:        req.ServiceCost - user.Balance,
-			Message:        "Yetersiz bakiye. Lütfen yöneticiye başvurun.",
+			Success: false,
+			Code:    scan.Outcome,
+			Message: "Kart bloke edilmiş. Lütfen yöneticiye başvurun.",
 		})
 		return
 	}
 
-	// Process transaction
-	balanceBefore := user.Balance
-	balanceAfter := balanceBefore - req.ServiceCost
+	// Too many wrong PINs lock the card for every scan until the lockout ends
+	if pin.Locked(user, time.Now()) {
+		scan.Outcome = models.ScanCardLocked
+		c.JSON(http.StatusOK, cardLocked(user))
+		return
+	}
+
+	// The PIN is checked before the balance, so a wrong one reveals nothing
+	if pin.Required(user, req.Service, req.ServiceCost) {
+		if req.PIN == "" {
+			scan.Outcome = models.ScanPINRequired
+			c.JSON(http.StatusOK, models.AutomationScanResponse{
+				Success:  false,
+				Code:     scan.Outcome,
+				UserID:   user.ID,
+				UserName: user.Name,
+				Message:  "Bu işlem için PIN gerekli.",
+			})
+			return
+		}
 
+		err := pin.Verify(database.DB, &user, req.PIN)
+		switch {
+		case errors.Is(err, pin.ErrWrong):
+			scan.Outcome = models.ScanPINInvalid
+			left := config.AppConfig.PINMaxAttempts - user.PINFailedAttempts
+			c.JSON(http.StatusOK, models.AutomationScanResponse{
+				Success:      false,
+				Code:         scan.Outcome,
+				UserID:       user.ID,
+				UserName:     user.Name,
+				AttemptsLeft: &left,
+				Message:      "Hatalı PIN.",
+			})
+			return
+		case errors.Is(err, pin.ErrLocked):
+			scan.Outcome = models.ScanCardLocked
+			c.JSON(http.StatusOK, cardLocked(user))
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check PIN", "code": scan.Outcome})
+			return
+		}
+	}
+
+	// Spending limits of the user or their group
+	limits, usage, err := userSpending(database.DB, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check spending limits", "code": scan.Outcome})
+		return
+	}
+	if outcome, left := spending.Check(limits, usage, req.ServiceCost); outcome != "" {
+		scan.Outcome = outcome
+		c.JSON(http.StatusOK, limitReached(user, req, outcome, left))
+		return
+	}
+
+	// Check balance, which may go down to the credit limit
+	if user.Available() < req.ServiceCost {
+		scan.Outcome = models.ScanInsufficientBalance
+		c.JSON(http.StatusOK, insufficientBalance(user, req, user.Balance))
+		return
+	}
+
+	// Process transaction
 	transaction := models.Transaction{
-		UserID:        user.ID,
-		Type:          models.TypeDebit,
-		Amount:        req.ServiceCost,
-		BalanceBefore: balanceBefore,
-		BalanceAfter:  balanceAfter,
-		Description:   req.Description,
-		Source:        models.SourceAutomation,
+		UserID:      user.ID,
+		Type:        models.TypeDebit,
+		Amount:      req.ServiceCost,
+		Description: req.Description,
+		Source:      models.SourceAutomation,
+		DeviceID:    req.DeviceID,
+		Service:     req.Service,
+		RequestID:   c.GetString("request_id"),
 	}
 
-	// Update balance and create transaction atomically
-	err := database.DB.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Create(&transaction).Error; err != nil {
+	// Update balance and create transaction atomically. The limits are
+	// checked again with the user locked, so concurrent scans of the card
+	// cannot both pass the check above.
+	var limitOutcome models.ScanOutcome
+	var limitLeft *float64
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		locked, err := ledger.LockUser(tx, user.ID)
+		if err != nil {
 			return err
 		}
-
-		user.Balance = balanceAfter
-		if err := tx.Save(&user).Error; err != nil {
+		limits, usage, err := userSpending(tx, locked)
+		if err != nil {
 			return err
 		}
+		if limitOutcome, limitLeft = spending.Check(limits, usage, req.ServiceCost); limitOutcome != "" {
+			return errSpendingLimit
+		}
 
-		return nil
+		return ledger.Apply(tx, &transaction)
 	})
 
+	if errors.Is(err, errSpendingLimit) {
+		// Another scan of the card used up the limit since it was checked above
+		scan.Outcome = limitOutcome
+		c.JSON(http.StatusOK, limitReached(user, req, limitOutcome, limitLeft))
+		return
+	}
+
+	if errors.Is(err, ledger.ErrInsufficientBalance) {
+		// Balance changed since it was checked above
+		scan.Outcome = models.ScanInsufficientBalance
+		scan.Balance = &transaction.BalanceBefore
+		c.JSON(http.StatusOK, insufficientBalance(user, req, transaction.BalanceBefore))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process transaction"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process transaction", "code": scan.Outcome})
 		return
 	}
 
+	scan.Outcome = models.ScanApproved
+	scan.Balance = &transaction.BalanceBefore
+	scan.TransactionID = &transaction.ID
+
 	// Success response
 	c.JSON(http.StatusOK, models.AutomationScanResponse{
 		Success:       true,
+		Code:          scan.Outcome,
 		UserID:        user.ID,
 		UserName:      user.Name,
-		BalanceBefore: balanceBefore,
-		BalanceAfter:  balanceAfter,
+		BalanceBefore: transaction.BalanceBefore,
+		BalanceAfter:  transaction.BalanceAfter,
 		TransactionID: transaction.ID,
+		CreditLimit:   user.CreditLimit,
+		Available:     availableOf(user, transaction.BalanceAfter),
 		Message:       "Hizmet verildi",
 	})
 }
 
+// errSpendingLimit is returned when a spending limit denies a charge
+var errSpendingLimit = errors.New("spending limit reached")
+
+// limitMessages are shown on the device when a spending limit denies a scan
+var limitMessages = map[models.ScanOutcome]string{
+	models.ScanDailyLimit:  "Günlük harcama limitine ulaşıldı.",
+	models.ScanWeeklyLimit: "Haftalık harcama limitine ulaşıldı.",
+	models.ScanRateLimit:   "Çok sık kullanım. Lütfen daha sonra tekrar deneyin.",
+}
+
+// limitReached builds the response for a scan denied by a spending limit
+func limitReached(user models.User, req models.AutomationScanRequest, outcome models.ScanOutcome, left *float64) models.AutomationScanResponse {
+	return models.AutomationScanResponse{
+		Success:        false,
+		Code:           outcome,
+		UserID:         user.ID,
+		UserName:       user.Name,
+		RequiredAmount: req.ServiceCost,
+		LimitRemaining: left,
+		Message:        limitMessages[outcome],
+	}
+}
+
+// userSpending resolves the user's spending limits and, when any applies,
+// measures the usage against them
+func userSpending(db *gorm.DB, user models.User) (spending.Limits, spending.Usage, error) {
+	limits, err := spending.Resolve(db, user)
+	if err != nil || limits.None() {
+		return limits, spending.Usage{}, err
+	}
+	usage, err := spending.Measure(db, user, time.Now(), reports.Location)
+	return limits, usage, err
+}
+
+// allowanceOf returns what is left of the user's spending limits, nil when
+// no limit applies
+func allowanceOf(user models.User) *spending.Status {
+	limits, usage, err := userSpending(database.DB, user)
+	if err != nil {
+		log.Printf("Failed to check spending limits: %v", err)
+		return nil
+	}
+	if limits.None() {
+		return nil
+	}
+	status := spending.Allowances(limits, usage)
+	return &status
+}
+
+// cardLocked builds the response for a card whose PIN is locked
+func cardLocked(user models.User) models.AutomationScanResponse {
+	return models.AutomationScanResponse{
+		Success:     false,
+		Code:        models.ScanCardLocked,
+		UserID:      user.ID,
+		UserName:    user.Name,
+		LockedUntil: user.PINLockedUntil,
+		Message:     "Çok fazla hatalı PIN. Kart geçici olarak kilitlendi.",
+	}
+}
+
+// insufficientBalance builds the response for a scan the balance does not
+// cover, with the card's top-up request if there is one
+func insufficientBalance(user models.User, req models.AutomationScanRequest, balance float64) models.AutomationScanResponse {
+	deficit := req.ServiceCost - (balance + user.Overdraft())
+	return models.AutomationScanResponse{
+		Success:        false,
+		Code:           models.ScanInsufficientBalance,
+		UserID:         user.ID,
+		UserName:       user.Name,
+		CurrentBalance: balance,
+		RequiredAmount: req.ServiceCost,
+		Deficit:        deficit,
+		CreditLimit:    user.CreditLimit,
+		Available:      availableOf(user, balance),
+		TopUpRequest:   scanTopUp(user, req, deficit),
+		Message:        "Yetersiz bakiye. Lütfen yöneticiye başvurun.",
+	}
+}
+
+// availableOf returns what the user can still spend at balance, nil for
+// users without a credit limit
+func availableOf(user models.User, balance float64) *float64 {
+	if user.CreditLimit == nil {
+		return nil
+	}
+	available := balance + *user.CreditLimit
+	return &available
+}
+
+// recordScan stores the scan event once the response has been decided
+func recordScan(scan *models.ScanEvent, start time.Time) {
+	scan.LatencyMs = time.Since(start).Milliseconds()
+	if err := database.DB.Create(scan).Error; err != nil {
+		log.Printf("Failed to record scan event: %v", err)
+		return
+	}
+
+	// Fraud rules look back over earlier scans, so they run off the request
+	// path; when the queue is full the scan goes unchecked and is counted
+	if scan.UserID != nil {
+		fraud.Scans.Submit(*scan)
+	}
+}
+
 // CheckBalance checks user balance without deducting
 func CheckBalance(c *gin.Context) {
 	var req struct {
@@ -104,15 +327,29 @@ func CheckBalance(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"user_id":   user.ID,
-		"user_name": user.Name,
-		"balance":   user.Balance,
-		"is_active": user.IsActive,
+		"user_id":      user.ID,
+		"user_name":    user.Name,
+		"balance":      user.Balance,
+		"credit_limit": user.CreditLimit,
+		"available":    user.Available(),
+		"is_active":    user.IsActive,
+		"card_blocked": user.CardBlocked,
+		"card_locked":  pin.Locked(user, time.Now()),
+		// What is left of the spending limits, if any apply
+		"allowance": allowanceOf(user),
+		// The pending or recently resolved top-up request, if any
+		"topup_request": topUpStatus(user.ID),
 	})
 }
 
 // GetAutomationHistory returns automation transaction history
 func GetAutomationHistory(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var transactions []models.Transaction
 	
 	query := database.DB.Where("source = ?", models.SourceAutomation).
@@ -121,10 +358,10 @@ func GetAutomationHistory(c *gin.Context) {
 
 	// Filter by date range
 	if from := c.Query("from"); from != "" {
-		query = query.Where("created_at >= ?", from)
+		query = query.Where("created_at >= ?", timeParam(from, loc))
 	}
 	if to := c.Query("to"); to != "" {
-		query = query.Where("created_at <= ?", to)
+		query = query.Where("created_at <= ?", timeParam(to, loc))
 	}
 
 	if err := query.Limit(200).Find(&transactions).Error; err != nil {
@@ -134,3 +371,107 @@ func GetAutomationHistory(c *gin.Context) {
 
 	c.JSON(http.StatusOK, transactions)
 }
+
+// ListScanEvents returns scan events, including denied and failed scans
+func ListScanEvents(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var events []models.ScanEvent
+
+	query := database.DB.Model(&models.ScanEvent{}).Preload("User")
+
+	// Filter by outcome
+	if outcome := c.Query("outcome"); outcome != "" {
+		query = query.Where("outcome = ?", outcome)
+	}
+
+	// Only failed scans
+	if c.Query("failed") == "true" {
+		query = query.Where("outcome <> ?", models.ScanApproved)
+	}
+
+	// Filter by device
+	if deviceID := c.Query("device_id"); deviceID != "" {
+		query = query.Where("device_id = ?", deviceID)
+	}
+
+	// Filter by card
+	if cardID := c.Query("rfid_card_id"); cardID != "" {
+		query = query.Where("rfid_card_id = ?", cardID)
+	}
+
+	// Filter by user
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	// Filter by request ID
+	if requestID := c.Query("request_id"); requestID != "" {
+		query = query.Where("request_id = ?", requestID)
+	}
+
+	// Date range
+	if from := c.Query("from"); from != "" {
+		query = query.Where("created_at >= ?", timeParam(from, loc))
+	}
+	if to := c.Query("to"); to != "" {
+		query = query.Where("created_at <= ?", timeParam(to, loc))
+	}
+
+	var total int64
+	query.Count(&total)
+
+	if err := query.Order("created_at DESC").Limit(100).Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scan events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  events,
+		"total": total,
+	})
+}
+
+// GetScanStats counts scans per outcome, per device when grouped by device
+func GetScanStats(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rows []struct {
+		DeviceID string             `json:"device_id,omitempty"`
+		Outcome  models.ScanOutcome `json:"outcome"`
+		Count    int64              `json:"count"`
+	}
+
+	query := database.DB.Model(&models.ScanEvent{})
+
+	// Default to the last 24 hours
+	if from := c.Query("from"); from != "" {
+		query = query.Where("created_at >= ?", timeParam(from, loc))
+	} else {
+		query = query.Where("created_at >= ?", time.Now().UTC().Add(-24*time.Hour))
+	}
+	if to := c.Query("to"); to != "" {
+		query = query.Where("created_at <= ?", timeParam(to, loc))
+	}
+
+	if c.Query("group") == "device" {
+		query = query.Select("device_id, outcome, COUNT(*) as count").Group("device_id, outcome")
+	} else {
+		query = query.Select("outcome, COUNT(*) as count").Group("outcome")
+	}
+
+	if err := query.Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scan stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rows)
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/audit"
+	"github.com/lazypwny751/hudautomata/pkg/cashdrawer"
+	"github.com/lazypwny751/hudautomata/pkg/config"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+)
+
+// errNoCashSession is returned when CASH_SESSION_REQUIRED is set and an
+// admin issues credit without an open cash session
+var errNoCashSession = errors.New("no open cash session")
+
+// recordCashCredit records a credit issued by an admin against the admin's
+// open cash session
+func recordCashCredit(tx *gorm.DB, t *models.Transaction) error {
+	if t.Type != models.TypeCredit || t.AdminID == nil {
+		return nil
+	}
+
+	session, err := cashdrawer.Current(tx, *t.AdminID)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		if config.AppConfig.CashSessionRequired {
+			return errNoCashSession
+		}
+		return nil
+	}
+
+	t.CashSessionID = &session.ID
+	return nil
+}
+
+// OpenCashSession starts a cash session for the current admin
+func OpenCashSession(c *gin.Context) {
+	var req models.OpenCashSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	act := currentActor(c)
+	if act.AdminID == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can open cash sessions"})
+		return
+	}
+
+	session, err := cashdrawer.Open(database.DB, *act.AdminID, req.OpeningFloat, req.Note)
+	if errors.Is(err, cashdrawer.ErrSessionOpen) {
+		c.JSON(http.StatusConflict, gin.H{"error": "You already have an open cash session"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open cash session"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.CashSessionOpen,
+		Resource:   audit.ResourceCashSession,
+		ResourceID: session.ID.String(),
+		After:      session,
+	})
+
+	c.JSON(http.StatusCreated, session)
+}
+
+// GetCurrentCashSession returns the current admin's open session with its
+// running totals
+func GetCurrentCashSession(c *gin.Context) {
+	act := currentActor(c)
+	if act.AdminID == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No open cash session"})
+		return
+	}
+
+	session, err := cashdrawer.Current(database.DB, *act.AdminID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cash session"})
+		return
+	}
+	if session == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No open cash session"})
+		return
+	}
+
+	report, err := cashdrawer.BuildReport(database.DB, *session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cash session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ListCashSessions returns cash sessions, newest first
+func ListCashSessions(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var sessions []models.CashSession
+
+	query := database.DB.Model(&models.CashSession{}).Preload("Admin").Preload("ClosedBy")
+
+	if adminID := c.Query("admin_id"); adminID != "" {
+		query = query.Where("admin_id = ?", adminID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	// Sessions opened in the date range
+	from, to, err := rangeParams(c.Query("from"), c.Query("to"), loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !from.IsZero() {
+		query = query.Where("opened_at >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("opened_at < ?", to)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	if err := query.Order("opened_at DESC").Limit(100).Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cash sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  sessions,
+		"total": total,
+	})
+}
+
+// GetCashSession returns the report of a session: every credit recorded
+// against it and the cash expected in the drawer
+func GetCashSession(c *gin.Context) {
+	session, ok := findCashSession(c)
+	if !ok {
+		return
+	}
+
+	report, err := cashdrawer.BuildReport(database.DB, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build cash session report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetCashSessionPDF downloads the printable session report
+func GetCashSessionPDF(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, ok := findCashSession(c)
+	if !ok {
+		return
+	}
+
+	report, err := cashdrawer.BuildReport(database.DB, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build cash session report"})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="cash-session-`+session.ID.String()+`.pdf"`)
+	c.Data(http.StatusOK, "application/pdf", cashdrawer.ReportPDF(report, loc))
+}
+
+// CloseCashSession closes a session with the cash counted in the drawer.
+// Admins close their own sessions; cash:manage closes anyone's.
+func CloseCashSession(c *gin.Context) {
+	var req models.CloseCashSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, ok := findCashSession(c)
+	if !ok {
+		return
+	}
+
+	act := currentActor(c)
+	session, err := cashdrawer.Close(database.DB, before.ID, *req.CountedCash, req.Note, act.AdminID)
+	if errors.Is(err, cashdrawer.ErrSessionClosed) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cash session is already closed"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close cash session"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.CashSessionClose,
+		Resource:   audit.ResourceCashSession,
+		ResourceID: session.ID.String(),
+		Before:     before,
+		After:      session,
+	})
+
+	c.JSON(http.StatusOK, session)
+}
+
+// findCashSession loads the session in the id parameter and checks that
+// the actor may see it, writing the error response when not
+func findCashSession(c *gin.Context) (models.CashSession, bool) {
+	var session models.CashSession
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cash session ID"})
+		return session, false
+	}
+
+	if err := database.DB.Preload("Admin").Preload("ClosedBy").First(&session, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cash session not found"})
+		return session, false
+	}
+
+	act := currentActor(c)
+	own := act.AdminID != nil && *act.AdminID == session.AdminID
+	if !own && !models.HasPermission(act.Permissions, models.PermCashManage) {
+		// Other admins' sessions are not disclosed
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cash session not found"})
+		return session, false
+	}
+
+	return session, true
+}
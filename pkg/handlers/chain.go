@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lazypwny751/hudautomata/pkg/chain"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+)
+
+// VerifyChain recomputes the hash chains of logs and transactions and
+// reports any edited, deleted or reordered entries
+func VerifyChain(c *gin.Context) {
+	chains := chain.All
+	if name := c.Query("chain"); name != "" {
+		selected, ok := chain.Get(name)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown chain"})
+			return
+		}
+		chains = []*chain.Chain{selected}
+	}
+
+	ok := true
+	reports := make([]chain.Report, 0, len(chains))
+	for _, ch := range chains {
+		report, err := ch.Verify(database.DB)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify chain"})
+			return
+		}
+		ok = ok && report.OK
+		reports = append(reports, report)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":     ok,
+		"chains": reports,
+	})
+}
+
+// ListChainCheckpoints exports the signed checkpoints together with the
+// public key they should be verified against
+func ListChainCheckpoints(c *gin.Context) {
+	var checkpoints []models.ChainCheckpoint
+
+	query := database.DB.Order("created_at")
+	if name := c.Query("chain"); name != "" {
+		query = query.Where("chain = ?", name)
+	}
+
+	if err := query.Find(&checkpoints).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch checkpoints"})
+		return
+	}
+
+	if c.Query("download") == "true" {
+		c.Header("Content-Disposition", `attachment; filename="chain-checkpoints.json"`)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"public_key":  chain.PublicKey(),
+		"algorithm":   "ed25519",
+		"message":     "chain, seq, hash and created_at (unix milliseconds) joined by newlines",
+		"checkpoints": checkpoints,
+	})
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lazypwny751/hudautomata/pkg/audit"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/reports"
+)
+
+// ListCloseouts returns the closed business days, newest first
+func ListCloseouts(c *gin.Context) {
+	var closeouts []models.DailyCloseout
+
+	query := database.DB.Omit("report", "pdf").Preload("ClosedBy")
+	if from := c.Query("from"); from != "" {
+		query = query.Where("business_date >= ?", from)
+	}
+	if to := c.Query("to"); to != "" {
+		query = query.Where("business_date <= ?", to)
+	}
+
+	if err := query.Order("business_date DESC").Limit(100).Find(&closeouts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch closeouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, closeouts)
+}
+
+// PreviewCloseout computes the statement of a business day, today by
+// default, without closing it
+func PreviewCloseout(c *gin.Context) {
+	date := c.DefaultQuery("date", time.Now().In(reports.Location).Format("2006-01-02"))
+
+	report, err := reports.BuildCloseout(database.DB, date)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// CreateCloseout closes a business day, yesterday by default
+func CreateCloseout(c *gin.Context) {
+	var req struct {
+		BusinessDate string `json:"business_date"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.BusinessDate == "" {
+		req.BusinessDate = time.Now().In(reports.Location).AddDate(0, 0, -1).Format("2006-01-02")
+	}
+
+	act := currentActor(c)
+	closeout, err := reports.CloseDay(database.DB, req.BusinessDate, act.AdminID, c.GetString("admin_username"))
+	switch {
+	case errors.Is(err, reports.ErrInvalidDate):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, reports.ErrDayNotOver):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Business day has not ended yet"})
+		return
+	case errors.Is(err, reports.ErrAlreadyClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Business day is already closed"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close business day"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.CloseoutCreate,
+		Resource:   audit.ResourceCloseout,
+		ResourceID: closeout.BusinessDate,
+		After:      closeout,
+	})
+
+	c.JSON(http.StatusCreated, closeout)
+}
+
+// GetCloseout returns a closeout with its signed JSON statement
+func GetCloseout(c *gin.Context) {
+	closeout, err := findCloseout(c.Param("date"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Closeout not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"closeout": closeout,
+		"report":   json.RawMessage(closeout.Report),
+	})
+}
+
+// GetCloseoutPDF downloads the printable statement
+func GetCloseoutPDF(c *gin.Context) {
+	closeout, err := findCloseout(c.Param("date"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Closeout not found"})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="closeout-`+closeout.BusinessDate+`.pdf"`)
+	c.Data(http.StatusOK, "application/pdf", closeout.PDF)
+}
+
+// VerifyCloseout checks a closeout's signature and documents and whether
+// the transactions of its day changed after closing
+func VerifyCloseout(c *gin.Context) {
+	closeout, err := findCloseout(c.Param("date"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Closeout not found"})
+		return
+	}
+
+	check, err := reports.VerifyCloseout(database.DB, closeout)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify closeout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, check)
+}
+
+// findCloseout loads the closeout of a business date
+func findCloseout(date string) (models.DailyCloseout, error) {
+	var closeout models.DailyCloseout
+	err := database.DB.Preload("ClosedBy").Where("business_date = ?", date).First(&closeout).Error
+	return closeout, err
+}
@@ -2,20 +2,31 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/logwriter"
 	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/reports"
 )
 
 // GetDashboardStats returns dashboard statistics
 func GetDashboardStats(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var stats struct {
-		TotalUsers        int64   `json:"total_users"`
-		ActiveUsers       int64   `json:"active_users"`
-		TotalBalance      float64 `json:"total_balance"`
-		TodayTransactions int64   `json:"today_transactions"`
-		TodayRevenue      float64 `json:"today_revenue"`
+		TotalUsers        int64                        `json:"total_users"`
+		ActiveUsers       int64                        `json:"active_users"`
+		TotalBalance      float64                      `json:"total_balance"`
+		TodayTransactions int64                        `json:"today_transactions"`
+		TodayRevenue      float64                      `json:"today_revenue"`
+		TodayScans        map[models.ScanOutcome]int64 `json:"today_scans"`
+		Timezone          string                       `json:"timezone"`
 	}
 
 	// Total users
@@ -27,16 +38,29 @@ func GetDashboardStats(c *gin.Context) {
 	// Total balance
 	database.DB.Model(&models.User{}).Select("COALESCE(SUM(balance), 0)").Scan(&stats.TotalBalance)
 	
-	// Today's transactions
-	database.DB.Model(&models.Transaction{}).
-		Where("DATE(created_at) = CURRENT_DATE").
-		Count(&stats.TodayTransactions)
-	
+	// Today's transactions, "today" being the business day
+	todayStart, todayEnd := reports.Day(time.Now(), loc)
+	stats.Timezone = loc.String()
+	stats.TodayTransactions, _, _ = reports.Totals(database.DB, todayStart, todayEnd, loc)
+
 	// Today's revenue (debit transactions)
-	database.DB.Model(&models.Transaction{}).
-		Where("DATE(created_at) = CURRENT_DATE AND type = ?", models.TypeDebit).
-		Select("COALESCE(SUM(amount), 0)").
-		Scan(&stats.TodayRevenue)
+	_, stats.TodayRevenue, _ = reports.Totals(database.DB, todayStart, todayEnd, loc, "type = ?", models.TypeDebit)
+
+	// Today's scans by outcome
+	var scans []struct {
+		Outcome models.ScanOutcome
+		Count   int64
+	}
+	database.DB.Model(&models.ScanEvent{}).
+		Select("outcome, COUNT(*) as count").
+		Where("created_at >= ? AND created_at < ?", todayStart, todayEnd).
+		Group("outcome").
+		Scan(&scans)
+
+	stats.TodayScans = map[models.ScanOutcome]int64{}
+	for _, s := range scans {
+		stats.TodayScans[s.Outcome] = s.Count
+	}
 
 	c.JSON(http.StatusOK, stats)
 }
@@ -58,49 +82,90 @@ func GetRecentActivities(c *gin.Context) {
 	c.JSON(http.StatusOK, transactions)
 }
 
-// GetChartData returns data for charts
+// chartPeriods maps the legacy period parameter to a default range and bucket
+var chartPeriods = map[string]struct {
+	span   time.Duration
+	bucket database.BucketUnit
+}{
+	"day":   {24 * time.Hour, database.BucketHour},
+	"week":  {7 * 24 * time.Hour, database.BucketDay},
+	"month": {30 * 24 * time.Hour, database.BucketDay},
+}
+
+// GetChartData returns completed transaction amounts and counts per bucket,
+// split into one series per transaction type or source
 func GetChartData(c *gin.Context) {
-	period := c.DefaultQuery("period", "week") // day, week, month
-
-	var chartData []struct {
-		Date   string  `json:"date"`
-		Amount float64 `json:"amount"`
-		Count  int64   `json:"count"`
-	}
-
-	var dateFormat string
-	var dateGroup string
-
-	switch period {
-	case "day":
-		dateFormat = "YYYY-MM-DD HH24:00:00"
-		dateGroup = "DATE_TRUNC('hour', created_at)"
-	case "month":
-		dateFormat = "YYYY-MM-DD"
-		dateGroup = "DATE_TRUNC('day', created_at)"
-	default: // week
-		dateFormat = "YYYY-MM-DD"
-		dateGroup = "DATE_TRUNC('day', created_at)"
-	}
-
-	// Note: This is PostgreSQL syntax, for SQLite you'll need to adjust
-	database.DB.Model(&models.Transaction{}).
-		Select("TO_CHAR("+dateGroup+", '"+dateFormat+"') as date, "+
-			"COALESCE(SUM(amount), 0) as amount, "+
-			"COUNT(*) as count").
-		Where("created_at >= NOW() - INTERVAL '7 days'").
-		Group(dateGroup).
-		Order("date ASC").
-		Scan(&chartData)
-
-	c.JSON(http.StatusOK, chartData)
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	period, ok := chartPeriods[c.DefaultQuery("period", "week")]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period must be day, week or month"})
+		return
+	}
+
+	from, to, err := rangeParams(c.Query("from"), c.Query("to"), loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+	if from.IsZero() {
+		from = to.Add(-period.span)
+	}
+
+	query := reports.ChartQuery{
+		From:     from,
+		To:       to,
+		Bucket:   database.BucketUnit(c.DefaultQuery("bucket", string(period.bucket))),
+		SeriesBy: c.DefaultQuery("series", reports.SeriesType),
+		Location: loc,
+	}
+
+	if err := query.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	chart, err := reports.BuildChart(database.DB, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chart data"})
+		return
+	}
+
+	// Same chart for the period of equal length right before this one
+	if c.Query("compare") == "previous" {
+		chart.Previous, err = reports.BuildChart(database.DB, query.Previous())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chart data"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, chart)
 }
 
 // ListLogs returns system logs
 func ListLogs(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var logs []models.SystemLog
 	
 	query := database.DB.Model(&models.SystemLog{}).Preload("Admin")
+
+	// Logs restored from the archive for an investigation
+	if c.Query("archive") == "true" {
+		query = database.DB.Model(&models.RestoredLog{}).Preload("Admin")
+	}
 	
 	// Filter by action
 	if action := c.Query("action"); action != "" {
@@ -112,12 +177,30 @@ func ListLogs(c *gin.Context) {
 		query = query.Where("admin_id = ?", adminID)
 	}
 
+	// Filter by request ID, to tie audit records and failures to one request
+	if requestID := c.Query("request_id"); requestID != "" {
+		query = query.Where("request_id = ?", requestID)
+	}
+
+	// Filter by category (http, audit)
+	if category := c.Query("category"); category != "" {
+		query = query.Where("category = ?", category)
+	}
+
+	// Filter by affected resource, e.g. the full history of one user
+	if resource := c.Query("resource"); resource != "" {
+		query = query.Where("resource = ?", resource)
+	}
+	if resourceID := c.Query("resource_id"); resourceID != "" {
+		query = query.Where("resource_id = ?", resourceID)
+	}
+
 	// Date range
 	if from := c.Query("from"); from != "" {
-		query = query.Where("created_at >= ?", from)
+		query = query.Where("created_at >= ?", timeParam(from, loc))
 	}
 	if to := c.Query("to"); to != "" {
-		query = query.Where("created_at <= ?", to)
+		query = query.Where("created_at <= ?", timeParam(to, loc))
 	}
 
 	var total int64
@@ -133,3 +216,13 @@ func ListLogs(c *gin.Context) {
 		"total": total,
 	})
 }
+
+// GetLogQueueStats reports the depth and counters of the batched log writer
+func GetLogQueueStats(c *gin.Context) {
+	if logwriter.Default == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Log writer is not running"})
+		return
+	}
+
+	c.JSON(http.StatusOK, logwriter.Default.Stats())
+}
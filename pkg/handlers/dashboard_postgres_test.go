@@ -0,0 +1,211 @@
+//go:build postgres
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/reports"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// dashboardSchema keeps the test tables apart from anything else in the
+// Postgres database
+const dashboardSchema = "hud_dashboard_test"
+
+// dashboardRequests cover the business zone and others, DST changes, every
+// bucket, both series and the rollup and transaction table paths
+var dashboardRequests = []string{
+	"/stats",
+	"/stats?tz=America/New_York",
+	"/stats?tz=Asia/Kolkata",
+	"/charts?from=2024-03-25&to=2024-04-02&bucket=day",
+	"/charts?from=2024-03-25&to=2024-04-02&bucket=day&series=source",
+	"/charts?from=2024-03-30T00:00:00Z&to=2024-04-01T00:00:00Z&bucket=hour",
+	"/charts?from=2024-03-30T00:30:00Z&to=2024-03-31T12:30:00Z&bucket=hour&tz=Asia/Kolkata",
+	"/charts?from=2024-03-01&to=2024-05-01&bucket=week",
+	"/charts?from=2024-03-01&to=2024-05-01&bucket=day&tz=America/New_York",
+	"/charts?from=2024-01-01&to=2024-06-01&bucket=month&compare=previous",
+	"/charts?from=2024-01-01&to=2024-06-01&bucket=month&series=source&tz=Asia/Kolkata",
+}
+
+// TestDashboardAcrossDrivers seeds the same fixtures into SQLite and the
+// database in TEST_POSTGRES_DSN and checks that the dashboard and chart
+// endpoints answer the same on both:
+//
+//	TEST_POSTGRES_DSN="host=localhost user=huduser dbname=hudautomata_test sslmode=disable" \
+//	    go test -tags postgres ./pkg/handlers
+func TestDashboardAcrossDrivers(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	berlin, err := reports.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := reports.Location
+	reports.Location = berlin
+	t.Cleanup(func() { reports.Location = previous })
+
+	now := time.Now().UTC()
+	want := dashboardResponses(t, openSQLite(t), now)
+	got := dashboardResponses(t, openPostgres(t, dsn), now)
+
+	for i, path := range dashboardRequests {
+		if got[i] != want[i] {
+			t.Errorf("%s differs\nsqlite:   %s\npostgres: %s", path, want[i], got[i])
+		}
+	}
+}
+
+func openSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	database.LogLevel = logger.Silent
+	if err := database.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	return database.DB
+}
+
+// openPostgres connects to a fresh schema that is dropped after the test
+func openPostgres(t *testing.T, dsn string) *gorm.DB {
+	t.Helper()
+
+	gormConfig := &gorm.Config{
+		Logger:  logger.Default.LogMode(logger.Silent),
+		NowFunc: func() time.Time { return time.Now().UTC() },
+	}
+
+	admin, err := gorm.Open(postgres.Open(dsn), gormConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Exec("DROP SCHEMA IF EXISTS " + dashboardSchema + " CASCADE").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Exec("CREATE SCHEMA " + dashboardSchema).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA IF EXISTS " + dashboardSchema + " CASCADE") })
+
+	db, err := gorm.Open(postgres.Open(dsn+" search_path="+dashboardSchema), gormConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	database.DB = db
+	if err := database.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// dashboardResponses seeds db, which must be database.DB, and returns the
+// body of every dashboard request
+func dashboardResponses(t *testing.T, db *gorm.DB, now time.Time) []string {
+	t.Helper()
+
+	seedDashboard(t, db, now)
+	if _, err := reports.RebuildRollups(db); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/stats", GetDashboardStats)
+	r.GET("/charts", GetChartData)
+
+	bodies := make([]string, len(dashboardRequests))
+	for i, path := range dashboardRequests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", path, w.Code, w.Body.String())
+		}
+		bodies[i] = w.Body.String()
+	}
+	return bodies
+}
+
+// seedDashboard stores users, transactions and scans around midnight, the
+// Berlin DST change of 31 March 2024 and now. Amounts are exact in binary,
+// so sums cannot differ in rounding between the drivers.
+func seedDashboard(t *testing.T, db *gorm.DB, now time.Time) {
+	t.Helper()
+
+	users := []models.User{
+		{ID: uuid.MustParse("00000000-0000-0000-0000-000000000001"), RFIDCardID: "CARD1", Name: "One", Email: "one@example.com", Balance: 120.5, IsActive: true},
+		{ID: uuid.MustParse("00000000-0000-0000-0000-000000000002"), RFIDCardID: "CARD2", Name: "Two", Email: "two@example.com", Balance: -15.25, IsActive: true},
+		{ID: uuid.MustParse("00000000-0000-0000-0000-000000000003"), RFIDCardID: "CARD3", Name: "Three", Email: "three@example.com", Balance: 40, IsActive: false},
+	}
+	for i := range users {
+		if err := db.Create(&users[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// IsActive false is a zero value that Create leaves to the column default
+	db.Model(&users[2]).Update("is_active", false)
+
+	at := func(value string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	resolved := func(value time.Time) *time.Time { return &value }
+
+	transactions := []models.Transaction{
+		{UserID: users[0].ID, Type: models.TypeCredit, Amount: 100, Source: models.SourceAdmin, Status: models.StatusCompleted, CreatedAt: at("2024-03-25T09:00:00Z")},
+		{UserID: users[0].ID, Type: models.TypeDebit, Amount: 2.5, Source: models.SourceAutomation, DeviceID: "kiosk-1", Service: "coffee", Status: models.StatusCompleted, CreatedAt: at("2024-03-29T22:30:00Z")},
+		{UserID: users[1].ID, Type: models.TypeDebit, Amount: 7.25, Source: models.SourceAutomation, DeviceID: "kiosk-2", Service: "lunch", Status: models.StatusCompleted, CreatedAt: at("2024-03-30T23:15:00Z")},
+		{UserID: users[1].ID, Type: models.TypeDebit, Amount: 3.75, Source: models.SourceAutomation, DeviceID: "kiosk-1", Service: "coffee", Status: models.StatusCompleted, CreatedAt: at("2024-03-31T00:45:00Z")},
+		{UserID: users[0].ID, Type: models.TypeDebit, Amount: 4.5, Source: models.SourceAPI, Status: models.StatusCompleted, CreatedAt: at("2024-03-31T01:30:00Z")},
+		{UserID: users[2].ID, Type: models.TypeRefund, Amount: 1.25, Source: models.SourceAdmin, Status: models.StatusCompleted, CreatedAt: at("2024-03-31T21:59:59Z")},
+		{UserID: users[2].ID, Type: models.TypeCredit, Amount: 50, Source: models.SourceAdmin, Status: models.StatusCompleted, CreatedAt: at("2024-03-31T22:00:00Z")},
+		// Approved after midnight, so booked on the next day
+		{UserID: users[1].ID, Type: models.TypeCredit, Amount: 200, Source: models.SourceAdmin, Status: models.StatusCompleted, CreatedAt: at("2024-03-31T21:30:00Z"), ResolvedAt: resolved(at("2024-04-01T06:00:00Z"))},
+		{UserID: users[0].ID, Type: models.TypeCredit, Amount: 500, Source: models.SourceAdmin, Status: models.StatusPending, CreatedAt: at("2024-03-30T10:00:00Z")},
+		{UserID: users[0].ID, Type: models.TypeCredit, Amount: 300, Source: models.SourceAdmin, Status: models.StatusRejected, CreatedAt: at("2024-03-30T11:00:00Z"), ResolvedAt: resolved(at("2024-03-30T12:00:00Z"))},
+		{UserID: users[1].ID, Type: models.TypeDebit, Amount: 12.5, Source: models.SourceAutomation, DeviceID: "kiosk-2", Service: "lunch", Status: models.StatusCompleted, CreatedAt: at("2024-01-31T23:30:00Z")},
+		{UserID: users[0].ID, Type: models.TypeCredit, Amount: 25, Source: models.SourceSystem, Status: models.StatusCompleted, CreatedAt: at("2024-04-30T22:30:00Z")},
+		{UserID: users[0].ID, Type: models.TypeDebit, Amount: 6.5, Source: models.SourceAutomation, DeviceID: "kiosk-1", Service: "coffee", Status: models.StatusCompleted, CreatedAt: at("2024-05-31T23:00:00Z")},
+		// Today, for the stats
+		{UserID: users[0].ID, Type: models.TypeDebit, Amount: 8.75, Source: models.SourceAutomation, DeviceID: "kiosk-1", Service: "coffee", Status: models.StatusCompleted, CreatedAt: now.Add(-time.Minute)},
+		{UserID: users[1].ID, Type: models.TypeCredit, Amount: 60, Source: models.SourceAdmin, Status: models.StatusCompleted, CreatedAt: now.Add(-2 * time.Minute)},
+		{UserID: users[1].ID, Type: models.TypeCredit, Amount: 900, Source: models.SourceAdmin, Status: models.StatusPending, CreatedAt: now.Add(-3 * time.Minute)},
+	}
+	for i := range transactions {
+		if err := db.Create(&transactions[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scans := []models.ScanEvent{
+		{RFIDCardID: "CARD1", UserID: &users[0].ID, DeviceID: "kiosk-1", Outcome: models.ScanApproved, CreatedAt: now.Add(-time.Minute)},
+		{RFIDCardID: "CARD2", UserID: &users[1].ID, DeviceID: "kiosk-1", Outcome: models.ScanApproved, CreatedAt: now.Add(-4 * time.Minute)},
+		{RFIDCardID: "CARD9", DeviceID: "kiosk-2", Outcome: models.ScanError, CreatedAt: now.Add(-5 * time.Minute)},
+		{RFIDCardID: "CARD1", UserID: &users[0].ID, DeviceID: "kiosk-1", Outcome: models.ScanApproved, CreatedAt: at("2024-03-31T00:45:00Z")},
+	}
+	for i := range scans {
+		if err := db.Create(&scans[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
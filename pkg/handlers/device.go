@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/audit"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+)
+
+// ListDevices returns all registered devices
+func ListDevices(c *gin.Context) {
+	var devices []models.Device
+	if err := database.DB.Order("device_id ASC").Find(&devices).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch devices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, devices)
+}
+
+// GetDevice returns a single device
+func GetDevice(c *gin.Context) {
+	device, ok := findDevice(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, device)
+}
+
+// CreateDevice registers a device under the ID it sends with its scans
+func CreateDevice(c *gin.Context) {
+	var req models.CreateDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if (req.Latitude == nil) != (req.Longitude == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Latitude and longitude must be set together"})
+		return
+	}
+
+	var existing models.Device
+	if err := database.DB.Where("device_id = ?", req.DeviceID).First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Device already registered"})
+		return
+	}
+
+	device := models.Device{
+		DeviceID:    req.DeviceID,
+		Name:        req.Name,
+		Location:    req.Location,
+		Latitude:    req.Latitude,
+		Longitude:   req.Longitude,
+		Description: req.Description,
+	}
+
+	if err := database.DB.Create(&device).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register device"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.DeviceCreate,
+		Resource:   audit.ResourceDevice,
+		ResourceID: device.ID.String(),
+		After:      device,
+	})
+
+	c.JSON(http.StatusCreated, device)
+}
+
+// UpdateDevice updates a device's name, location or coordinates
+func UpdateDevice(c *gin.Context) {
+	var req models.UpdateDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	device, ok := findDevice(c)
+	if !ok {
+		return
+	}
+
+	before := device
+
+	if req.Name != nil {
+		device.Name = *req.Name
+	}
+	if req.Location != nil {
+		device.Location = *req.Location
+	}
+	if req.Latitude != nil {
+		device.Latitude = req.Latitude
+	}
+	if req.Longitude != nil {
+		device.Longitude = req.Longitude
+	}
+	if req.Description != nil {
+		device.Description = *req.Description
+	}
+
+	if (device.Latitude == nil) != (device.Longitude == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Latitude and longitude must be set together"})
+		return
+	}
+
+	if err := database.DB.Save(&device).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.DeviceUpdate,
+		Resource:   audit.ResourceDevice,
+		ResourceID: device.ID.String(),
+		Before:     before,
+		After:      device,
+	})
+
+	c.JSON(http.StatusOK, device)
+}
+
+// DeleteDevice removes a device from the registry. Its scans are kept.
+func DeleteDevice(c *gin.Context) {
+	device, ok := findDevice(c)
+	if !ok {
+		return
+	}
+
+	if err := database.DB.Delete(&device).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete device"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.DeviceDelete,
+		Resource:   audit.ResourceDevice,
+		ResourceID: device.ID.String(),
+		Before:     device,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Device deleted successfully"})
+}
+
+func findDevice(c *gin.Context) (models.Device, bool) {
+	var device models.Device
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return device, false
+	}
+
+	if err := database.DB.First(&device, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return device, false
+	}
+	return device, true
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lazypwny751/hudautomata/pkg/audit"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+)
+
+var errAlertReviewed = errors.New("fraud alert is already reviewed")
+
+// ListFraudAlerts returns fraud alerts, open ones unless another status is
+// asked for
+func ListFraudAlerts(c *gin.Context) {
+	var alerts []models.FraudAlert
+
+	status := c.DefaultQuery("status", string(models.AlertOpen))
+	query := database.DB.Model(&models.FraudAlert{}).Preload("User").Preload("ReviewedBy")
+
+	if status != "all" {
+		query = query.Where("status = ?", status)
+	}
+	if rule := c.Query("rule"); rule != "" {
+		query = query.Where("rule = ?", rule)
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if deviceID := c.Query("device_id"); deviceID != "" {
+		query = query.Where("device_id = ?", deviceID)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	if err := query.Order("created_at DESC").Limit(100).Find(&alerts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fraud alerts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  alerts,
+		"total": total,
+	})
+}
+
+// GetFraudAlert returns a fraud alert with the scan that raised it
+func GetFraudAlert(c *gin.Context) {
+	var alert models.FraudAlert
+	if err := database.DB.Preload("User").Preload("ReviewedBy").
+		First(&alert, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fraud alert not found"})
+		return
+	}
+
+	var scan *models.ScanEvent
+	if alert.ScanEventID != nil {
+		var event models.ScanEvent
+		if err := database.DB.First(&event, "id = ?", *alert.ScanEventID).Error; err == nil {
+			scan = &event
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"alert": alert,
+		"scan":  scan,
+	})
+}
+
+// ReviewFraudAlert confirms or dismisses an open alert. Confirming blocks
+// the card; dismissing unblocks it if the alert blocked it and nothing has
+// blocked it again since.
+func ReviewFraudAlert(c *gin.Context) {
+	var req models.ReviewAlertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var alert models.FraudAlert
+	if err := database.DB.First(&alert, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fraud alert not found"})
+		return
+	}
+	if alert.Status != models.AlertOpen {
+		c.JSON(http.StatusConflict, gin.H{"error": "Fraud alert is already reviewed"})
+		return
+	}
+
+	act := currentActor(c)
+	now := time.Now()
+	cardChanged := false
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.FraudAlert{}).
+			Where("id = ? AND status = ?", alert.ID, models.AlertOpen).
+			Updates(map[string]interface{}{
+				"status":         req.Status,
+				"review_note":    req.Note,
+				"reviewed_by_id": act.AdminID,
+				"reviewed_at":    now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errAlertReviewed
+		}
+
+		users := tx.Model(&models.User{}).Where("id = ?", alert.UserID)
+		switch {
+		case req.Status == models.AlertConfirmed:
+			result = users.Where("card_blocked = ?", false).
+				UpdateColumns(map[string]interface{}{
+					"card_blocked":      true,
+					"card_blocked_at":   now,
+					"card_block_reason": "Fraud alert confirmed: " + string(alert.Rule),
+				})
+		case alert.CardBlocked:
+			result = users.Where("card_blocked = ? AND card_block_reason = ?", true, "Fraud alert: "+string(alert.Rule)).
+				UpdateColumns(map[string]interface{}{
+					"card_blocked":      false,
+					"card_blocked_at":   nil,
+					"card_block_reason": "",
+				})
+		default:
+			return nil
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+		cardChanged = result.RowsAffected == 1
+		return nil
+	})
+	if errors.Is(err, errAlertReviewed) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Fraud alert is already reviewed"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to review fraud alert"})
+		return
+	}
+
+	alert.Status = req.Status
+	alert.ReviewNote = req.Note
+	alert.ReviewedByID = act.AdminID
+	alert.ReviewedAt = &now
+
+	audit.Record(c, audit.Event{
+		Action:     audit.FraudAlertReview,
+		Resource:   audit.ResourceFraudAlert,
+		ResourceID: alert.ID.String(),
+		Before:     gin.H{"status": models.AlertOpen},
+		After:      gin.H{"status": alert.Status, "note": alert.ReviewNote, "card_changed": cardChanged},
+	})
+
+	database.DB.Preload("User").Preload("ReviewedBy").First(&alert, "id = ?", alert.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"alert":        alert,
+		"card_changed": cardChanged,
+	})
+}
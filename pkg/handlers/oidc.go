@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lazypwny751/hudautomata/pkg/config"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/oidc"
+	"github.com/lazypwny751/hudautomata/pkg/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	oidcMu       sync.Mutex
+	oidcProvider *oidc.Provider
+	oidcStates   = oidc.NewStateStore(10 * time.Minute)
+)
+
+// getOIDCProvider discovers the identity provider on first use, so the
+// server still starts while the provider is unreachable
+func getOIDCProvider(ctx context.Context) (*oidc.Provider, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+
+	if oidcProvider != nil {
+		return oidcProvider, nil
+	}
+
+	cfg := config.AppConfig
+	provider, err := oidc.Discover(ctx, oidc.Config{
+		IssuerURL:    cfg.OIDCIssuerURL,
+		ClientID:     cfg.OIDCClientID,
+		ClientSecret: cfg.OIDCClientSecret,
+		RedirectURL:  cfg.OIDCRedirectURL,
+		Scopes:       strings.Fields(cfg.OIDCScopes),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	oidcProvider = provider
+	return provider, nil
+}
+
+// GetAuthProviders tells the login page which login methods are available
+func GetAuthProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"local": config.AppConfig.LocalLoginEnabled,
+		"oidc":  config.AppConfig.OIDCEnabled,
+	})
+}
+
+// OIDCLogin redirects the browser to the identity provider
+func OIDCLogin(c *gin.Context) {
+	if !config.AppConfig.OIDCEnabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not enabled"})
+		return
+	}
+
+	provider, err := getOIDCProvider(c.Request.Context())
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	state, req, err := oidcStates.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, provider.AuthCodeURL(state, req.Nonce, req.Verifier))
+}
+
+// OIDCCallback completes the authorization code flow, provisions the admin
+// on first login and issues a regular API token
+func OIDCCallback(c *gin.Context) {
+	if !config.AppConfig.OIDCEnabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not enabled"})
+		return
+	}
+
+	if idpErr := c.Query("error"); idpErr != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Identity provider denied login: " + idpErr})
+		return
+	}
+
+	pending, ok := oidcStates.Take(c.Query("state"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login state"})
+		return
+	}
+
+	provider, err := getOIDCProvider(c.Request.Context())
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(c.Request.Context(), c.Query("code"), pending.Verifier)
+	if err != nil {
+		log.Printf("oidc: code exchange failed: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to exchange authorization code"})
+		return
+	}
+
+	claims, err := provider.VerifyIDToken(c.Request.Context(), rawIDToken, pending.Nonce)
+	if err != nil {
+		log.Printf("oidc: invalid ID token: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ID token"})
+		return
+	}
+
+	roleName, ok := oidcRole(claims)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No admin role is mapped to your groups"})
+		return
+	}
+
+	if _, err := loadRole(roleName); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Mapped role does not exist"})
+		return
+	}
+
+	admin, err := provisionOIDCAdmin(claims, roleName)
+	if errors.Is(err, errOIDCAdminDeleted) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Account has been deleted"})
+		return
+	}
+	if errors.Is(err, errLastSuperAdmin) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot demote the last super admin"})
+		return
+	}
+	if err != nil {
+		log.Printf("oidc: provisioning failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to provision admin"})
+		return
+	}
+
+	if !admin.IsActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is inactive"})
+		return
+	}
+
+	// Linked local admins keep their own role, so load whichever the admin ended up with
+	role, err := loadRole(admin.Role)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Role does not exist"})
+		return
+	}
+
+	token, expiresAt, err := utils.GenerateToken(admin.ID, admin.Username, string(admin.Role), role.Permissions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	now := time.Now()
+	admin.LastLogin = &now
+	database.DB.Save(&admin)
+
+	// Hand the token to the admin panel in the URL fragment, which never reaches a server log
+	if frontend := config.AppConfig.OIDCFrontendURL; frontend != "" {
+		fragment := url.Values{
+			"token":      {token},
+			"expires_at": {expiresAt.Format(time.RFC3339)},
+		}
+		c.Redirect(http.StatusFound, frontend+"#"+fragment.Encode())
+		return
+	}
+
+	c.JSON(http.StatusOK, models.LoginResponse{
+		Token:       token,
+		Admin:       admin,
+		Permissions: role.Permissions,
+		ExpiresAt:   expiresAt,
+	})
+}
+
+// oidcRole maps the token's groups to a role, falling back to OIDC_DEFAULT_ROLE
+func oidcRole(claims jwt.MapClaims) (models.AdminRole, bool) {
+	cfg := config.AppConfig
+	groups := oidc.Groups(claims, cfg.OIDCGroupsClaim)
+
+	if role, ok := oidc.ParseRoleMapping(cfg.OIDCRoleMapping).Resolve(groups); ok {
+		return models.AdminRole(role), true
+	}
+	if cfg.OIDCDefaultRole != "" {
+		return models.AdminRole(cfg.OIDCDefaultRole), true
+	}
+	return "", false
+}
+
+// errOIDCAdminDeleted is returned when the token belongs to a deleted admin
+var errOIDCAdminDeleted = errors.New("admin account has been deleted")
+
+// provisionOIDCAdmin finds the admin linked to the token's subject or creates
+// a new one. With OIDC_LINK_BY_EMAIL an existing admin with the same verified
+// email is linked instead. Admins created through single sign-on follow the
+// identity provider's role on every login; linked local admins keep theirs.
+func provisionOIDCAdmin(claims jwt.MapClaims, role models.AdminRole) (models.Admin, error) {
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return models.Admin{}, fmt.Errorf("ID token has no subject")
+	}
+	externalID := issuer + "|" + subject
+
+	email, _ := claims["email"].(string)
+	emailVerified, _ := claims["email_verified"].(bool)
+
+	// Deleted admins keep their subject and email, so look them up too and refuse them
+	var admin models.Admin
+	err := database.DB.Unscoped().Where("external_subject = ?", externalID).First(&admin).Error
+	if err == nil {
+		if admin.DeletedAt.Valid {
+			return models.Admin{}, errOIDCAdminDeleted
+		}
+		return admin, syncOIDCRole(&admin, role)
+	}
+
+	if config.AppConfig.OIDCLinkByEmail && email != "" && emailVerified {
+		err = database.DB.Unscoped().Where("email = ? AND external_subject IS NULL", email).First(&admin).Error
+		if err == nil {
+			if admin.DeletedAt.Valid {
+				return models.Admin{}, errOIDCAdminDeleted
+			}
+			if err := database.DB.Model(&admin).Update("external_subject", externalID).Error; err != nil {
+				return models.Admin{}, err
+			}
+			admin.ExternalSubject = &externalID
+			log.Printf("oidc: linked admin %s to %s", admin.Username, externalID)
+			return admin, nil
+		}
+	}
+
+	// Email must stay unique; unverified addresses never take over an existing admin
+	var taken int64
+	database.DB.Unscoped().Model(&models.Admin{}).Where("email = ?", email).Count(&taken)
+	if email == "" || taken > 0 {
+		email = subject + "@" + hostOf(issuer)
+	}
+
+	admin = models.Admin{
+		Username:        uniqueUsername(claims, subject),
+		Email:           email,
+		AuthProvider:    models.AuthProviderOIDC,
+		ExternalSubject: &externalID,
+		Role:            role,
+		IsActive:        true,
+	}
+
+	if err := database.DB.Create(&admin).Error; err != nil {
+		return models.Admin{}, err
+	}
+
+	log.Printf("oidc: provisioned admin %s with role %s", admin.Username, role)
+	return admin, nil
+}
+
+// syncOIDCRole moves an admin created through single sign-on to the role the
+// identity provider maps it to. Linked local admins keep their role, and the
+// last active super admin is not demoted.
+func syncOIDCRole(admin *models.Admin, role models.AdminRole) error {
+	if admin.AuthProvider != models.AuthProviderOIDC || admin.Role == role {
+		return nil
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if admin.Role == models.RoleSuperAdmin && admin.IsActive {
+			if err := guardLastSuperAdmin(tx, admin.ID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(admin).Update("role", role).Error; err != nil {
+			return err
+		}
+		admin.Role = role
+		log.Printf("oidc: admin %s now has role %s", admin.Username, role)
+		return nil
+	})
+}
+
+var usernameCleaner = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// uniqueUsername derives a free username from the token's preferred username or email
+func uniqueUsername(claims jwt.MapClaims, subject string) string {
+	base, _ := claims["preferred_username"].(string)
+	if base == "" {
+		if email, _ := claims["email"].(string); email != "" {
+			base, _, _ = strings.Cut(email, "@")
+		}
+	}
+	base = usernameCleaner.ReplaceAllString(base, "")
+	if len(base) < 3 {
+		base = "sso-" + subject
+	}
+
+	username := base
+	for i := 2; ; i++ {
+		var count int64
+		database.DB.Unscoped().Model(&models.Admin{}).Where("username = ?", username).Count(&count)
+		if count == 0 {
+			return username
+		}
+		username = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+func hostOf(issuer string) string {
+	if u, err := url.Parse(issuer); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "sso.local"
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lazypwny751/hudautomata/pkg/config"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm/logger"
+)
+
+// mockIssuer is an identity provider serving discovery, a key set and a
+// token endpoint that returns an ID token with the next claims
+type mockIssuer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+	nonce  string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		claims := jwt.MapClaims{
+			"iss":   m.URL,
+			"aud":   "hudautomata",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": m.nonce,
+		}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// setupOIDC points the handlers at a fresh SQLite database and a mock issuer
+func setupOIDC(t *testing.T) (*mockIssuer, *gin.Engine) {
+	t.Helper()
+
+	issuer := newMockIssuer(t)
+
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	database.LogLevel = logger.Silent
+	if err := database.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.SeedData(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.AppConfig = &config.Config{
+		JWTSecret:       "test-secret",
+		OIDCEnabled:     true,
+		OIDCIssuerURL:   issuer.URL,
+		OIDCClientID:    "hudautomata",
+		OIDCRedirectURL: "http://localhost/callback",
+		OIDCScopes:      "openid email",
+		OIDCGroupsClaim: "groups",
+		OIDCRoleMapping: "hud-admins=super_admin,hud-cashiers=cashier",
+	}
+
+	oidcMu.Lock()
+	oidcProvider = nil
+	oidcMu.Unlock()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/login", OIDCLogin)
+	r.GET("/callback", OIDCCallback)
+	return issuer, r
+}
+
+// login runs the authorization code flow for a user with the given claims
+func login(t *testing.T, issuer *mockIssuer, r *gin.Engine, claims jwt.MapClaims) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: got %d: %s", w.Code, w.Body.String())
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer.claims = claims
+	issuer.nonce = location.Query().Get("nonce")
+
+	callback := url.Values{"code": {"code"}, "state": {location.Query().Get("state")}}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/callback?"+callback.Encode(), nil))
+	return w
+}
+
+func loginAdmin(t *testing.T, w *httptest.ResponseRecorder) models.Admin {
+	t.Helper()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback: got %d: %s", w.Code, w.Body.String())
+	}
+	var resp models.LoginResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Admin
+}
+
+func TestOIDCProvisionsAndFollowsRole(t *testing.T) {
+	issuer, r := setupOIDC(t)
+
+	claims := jwt.MapClaims{"sub": "jane", "preferred_username": "jane", "groups": []string{"hud-cashiers"}}
+	admin := loginAdmin(t, login(t, issuer, r, claims))
+	if admin.Role != models.RoleCashier || admin.AuthProvider != models.AuthProviderOIDC {
+		t.Fatalf("provisioned %s/%s, want cashier/oidc", admin.Role, admin.AuthProvider)
+	}
+
+	claims["groups"] = []string{"hud-admins"}
+	again := loginAdmin(t, login(t, issuer, r, claims))
+	if again.ID != admin.ID || again.Role != models.RoleSuperAdmin {
+		t.Fatalf("second login: got %s with role %s, want %s with super_admin", again.ID, again.Role, admin.ID)
+	}
+}
+
+func TestOIDCEmailLinking(t *testing.T) {
+	tests := []struct {
+		name     string
+		link     bool
+		wantSeed bool
+	}{
+		{"disabled creates a new admin", false, false},
+		{"enabled links and keeps the local role", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer, r := setupOIDC(t)
+			config.AppConfig.OIDCLinkByEmail = tt.link
+
+			var seeded models.Admin
+			database.DB.Where("username = ?", "admin").First(&seeded)
+
+			admin := loginAdmin(t, login(t, issuer, r, jwt.MapClaims{
+				"sub":            "boss",
+				"email":          seeded.Email,
+				"email_verified": true,
+				"groups":         []string{"hud-cashiers"},
+			}))
+
+			if got := admin.ID == seeded.ID; got != tt.wantSeed {
+				t.Fatalf("linked to seeded admin = %v, want %v", got, tt.wantSeed)
+			}
+			if tt.wantSeed && admin.Role != models.RoleSuperAdmin {
+				t.Fatalf("linked admin role = %s, want super_admin", admin.Role)
+			}
+			if !tt.wantSeed && admin.Email == seeded.Email {
+				t.Fatal("new admin took the seeded admin's email")
+			}
+		})
+	}
+}
+
+func TestOIDCRejectsDeletedAdmin(t *testing.T) {
+	issuer, r := setupOIDC(t)
+
+	claims := jwt.MapClaims{"sub": "gone", "groups": []string{"hud-cashiers"}}
+	admin := loginAdmin(t, login(t, issuer, r, claims))
+	database.DB.Delete(&admin)
+
+	if w := login(t, issuer, r, claims); w.Code != http.StatusUnauthorized {
+		t.Fatalf("deleted admin: got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOIDCKeepsLastSuperAdmin(t *testing.T) {
+	issuer, r := setupOIDC(t)
+
+	claims := jwt.MapClaims{"sub": "root", "groups": []string{"hud-admins"}}
+	admin := loginAdmin(t, login(t, issuer, r, claims))
+	database.DB.Model(&models.Admin{}).Where("id <> ?", admin.ID).Update("is_active", false)
+
+	claims["groups"] = []string{"hud-cashiers"}
+	if w := login(t, issuer, r, claims); w.Code != http.StatusConflict {
+		t.Fatalf("demoting last super admin: got %d: %s", w.Code, w.Body.String())
+	}
+
+	var stored models.Admin
+	database.DB.First(&stored, admin.ID)
+	if stored.Role != models.RoleSuperAdmin {
+		t.Fatalf("role = %s, want super_admin", stored.Role)
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lazypwny751/hudautomata/pkg/reports"
+)
+
+// dateLayout is the plain date accepted wherever an RFC 3339 time is
+const dateLayout = "2006-01-02"
+
+// requestLocation returns the time zone named by the tz query parameter,
+// or the business time zone when there is none
+func requestLocation(c *gin.Context) (*time.Location, error) {
+	if tz := c.Query("tz"); tz != "" {
+		return reports.LoadLocation(tz)
+	}
+	return reports.Location, nil
+}
+
+// timeParam turns a from/to query value into a time, so range filters
+// compare timestamps on every database instead of strings. Plain dates are
+// midnight in loc. Values that are neither RFC 3339 nor a plain date are
+// passed through unchanged.
+func timeParam(value string, loc *time.Location) interface{} {
+	if t, _, err := parseTime(value, loc); err == nil {
+		return t
+	}
+	return value
+}
+
+// rangeParams parses optional from/to query values into a half open range.
+// A plain date as "to" includes that whole day. Missing bounds are zero.
+func rangeParams(from, to string, loc *time.Location) (time.Time, time.Time, error) {
+	var start, end time.Time
+
+	if from != "" {
+		t, _, err := parseTime(from, loc)
+		if err != nil {
+			return start, end, fmt.Errorf("invalid from: %w", err)
+		}
+		start = t
+	}
+
+	if to != "" {
+		t, date, err := parseTime(to, loc)
+		if err != nil {
+			return start, end, fmt.Errorf("invalid to: %w", err)
+		}
+		if date {
+			t = t.AddDate(0, 0, 1)
+		}
+		end = t
+	}
+
+	return start, end, nil
+}
+
+// parseTime parses an RFC 3339 time or a plain date in loc, reporting which
+// it was. The time is returned in UTC, the zone timestamps are stored in;
+// SQLite compares them as text.
+func parseTime(value string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), false, nil
+	}
+	t, err := time.ParseInLocation(dateLayout, value, loc)
+	if err != nil {
+		return t, false, fmt.Errorf("%q is neither RFC 3339 nor YYYY-MM-DD", value)
+	}
+	return t.UTC(), true, nil
+}
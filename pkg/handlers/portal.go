@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/audit"
+	"github.com/lazypwny751/hudautomata/pkg/config"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/mailer"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/pin"
+	"github.com/lazypwny751/hudautomata/pkg/receipt"
+	"github.com/lazypwny751/hudautomata/pkg/utils"
+)
+
+// Portal login methods recorded in the token
+const (
+	portalLoginPIN  = "pin"
+	portalLoginLink = "link"
+)
+
+// maxLinkUsers caps how many cards one login e-mail covers
+const maxLinkUsers = 10
+
+// linkLimitWindow is the period PORTAL_LINK_LIMIT counts login links over
+const linkLimitWindow = time.Hour
+
+// PortalLogin logs a card holder in to the portal with their card and PIN
+func PortalLogin(c *gin.Context) {
+	var req models.PortalLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Where("rfid_card_id = ?", req.RFIDCardID).First(&user).Error; err != nil || !user.IsActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid card or PIN"})
+		return
+	}
+	if user.CardBlocked {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Card is blocked, log in with an e-mail link"})
+		return
+	}
+
+	if err := pin.Verify(database.DB, &user, req.PIN); err != nil {
+		pinError(c, user, err)
+		return
+	}
+
+	// A temporary PIN from an admin reset only lets the user pick a new one
+	issuePortalToken(c, user, portalLoginPIN, user.PINMustChange)
+}
+
+// pinError writes the response for a failed PIN check
+func pinError(c *gin.Context, user models.User, err error) {
+	switch {
+	case errors.Is(err, pin.ErrNotSet):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No PIN is set for this card, ask an admin or log in with an e-mail link"})
+	case errors.Is(err, pin.ErrLocked):
+		c.JSON(http.StatusLocked, gin.H{"error": "Too many wrong PINs, try again later", "locked_until": user.PINLockedUntil})
+	case errors.Is(err, pin.ErrWrong):
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":         "Invalid card or PIN",
+			"attempts_left": config.AppConfig.PINMaxAttempts - user.PINFailedAttempts,
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check PIN"})
+	}
+}
+
+func issuePortalToken(c *gin.Context, user models.User, method string, pinChangeOnly bool) {
+	token, expiresAt, err := utils.GenerateUserToken(user.ID, method, pinChangeOnly)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.PortalLoginResponse{
+		Token:     token,
+		User:      user,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// PortalRequestLink e-mails a single use login link for every active card
+// holder with the address. Cards that were sent PORTAL_LINK_LIMIT links in
+// the past hour get no more. The answer is the same whether or not the
+// address is known or a link was sent.
+func PortalRequestLink(c *gin.Context) {
+	if mailer.Default == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Mail is not configured"})
+		return
+	}
+
+	var req models.MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var users []models.User
+	if err := database.DB.Where("LOWER(email) = LOWER(?) AND is_active = ?", req.Email, true).
+		Order("created_at ASC").
+		Limit(maxLinkUsers).
+		Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send login link"})
+		return
+	}
+
+	users, err := underLinkLimit(users)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send login link"})
+		return
+	}
+
+	if len(users) > 0 {
+		if err := sendLoginLinks(c, req.Email, users); err != nil {
+			log.Printf("Failed to send portal login link: %v", err)
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the address belongs to a card holder, a login link is on its way"})
+}
+
+// underLinkLimit returns the users that were sent fewer than
+// PORTAL_LINK_LIMIT login links within linkLimitWindow
+func underLinkLimit(users []models.User) ([]models.User, error) {
+	limit := config.AppConfig.PortalLinkLimit
+	if limit <= 0 {
+		return users, nil
+	}
+
+	var allowed []models.User
+	since := time.Now().UTC().Add(-linkLimitWindow)
+	for _, user := range users {
+		var sent int64
+		err := database.DB.Model(&models.PortalLoginToken{}).
+			Where("user_id = ? AND created_at >= ?", user.ID, since).
+			Count(&sent).Error
+		if err != nil {
+			return nil, err
+		}
+		if sent < int64(limit) {
+			allowed = append(allowed, user)
+		}
+	}
+	return allowed, nil
+}
+
+// sendLoginLinks stores a login token per user and mails the links
+func sendLoginLinks(c *gin.Context, to string, users []models.User) error {
+	expiresAt := time.Now().UTC().Add(config.AppConfig.PortalLinkExpiration)
+
+	var body strings.Builder
+	body.WriteString("Hello,\n\nUse the link for your card below to log in to your HUD Automata account.\n\n")
+
+	for _, user := range users {
+		token, err := newLinkToken()
+		if err != nil {
+			return err
+		}
+
+		err = database.DB.Create(&models.PortalLoginToken{
+			UserID:    user.ID,
+			TokenHash: utils.HashAPIKey(token),
+			ExpiresAt: expiresAt,
+			IPAddress: c.ClientIP(),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&body, "%s, card %s:\n%s\n\n", user.Name, receipt.MaskCard(user.RFIDCardID), loginLink(token))
+	}
+
+	fmt.Fprintf(&body, "Each link works once and expires in %d minutes. If you did not ask for it, ignore this e-mail.\n",
+		int(config.AppConfig.PortalLinkExpiration.Minutes()))
+
+	return mailer.Default.Send(mailer.Message{
+		To:      to,
+		Subject: "Your HUD Automata login link",
+		Body:    body.String(),
+	})
+}
+
+func newLinkToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// loginLink appends the token to PORTAL_LINK_URL
+func loginLink(token string) string {
+	link := config.AppConfig.PortalLinkURL
+	if strings.Contains(link, "?") {
+		return link + "&token=" + token
+	}
+	return link + "?token=" + token
+}
+
+// PortalLinkLogin logs a card holder in with the token from a login link.
+// A token works once.
+func PortalLinkLogin(c *gin.Context) {
+	var req models.MagicLinkLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now().UTC()
+	hash := utils.HashAPIKey(req.Token)
+
+	// Claim the token first, so it cannot be used twice concurrently
+	result := database.DB.Model(&models.PortalLoginToken{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login link"})
+		return
+	}
+	if result.RowsAffected != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login link"})
+		return
+	}
+
+	var token models.PortalLoginToken
+	if err := database.DB.Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login link"})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", token.UserID).Error; err != nil || !user.IsActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login link"})
+		return
+	}
+
+	issuePortalToken(c, user, portalLoginLink, false)
+}
+
+// portalUser loads the logged-in card holder, writing the error response
+// when the account is gone or inactive
+func portalUser(c *gin.Context) (models.User, bool) {
+	var user models.User
+
+	userID, _ := c.Get("portal_user_id")
+	id, ok := userID.(uuid.UUID)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not logged in"})
+		return user, false
+	}
+
+	if err := database.DB.First(&user, "id = ?", id).Error; err != nil || !user.IsActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is not active"})
+		return user, false
+	}
+
+	return user, true
+}
+
+// PortalMe returns the card holder's account and balance
+func PortalMe(c *gin.Context) {
+	user, ok := portalUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":          user,
+		"balance":       user.Balance,
+		"credit_limit":  user.CreditLimit,
+		"available":     user.Available(),
+		"has_pin":       user.PINHash != "",
+		"allowance":     allowanceOf(user),
+		"topup_request": topUpStatus(user.ID),
+	})
+}
+
+// PortalTransactions returns the card holder's transactions, newest first
+func PortalTransactions(c *gin.Context) {
+	user, ok := portalUser(c)
+	if !ok {
+		return
+	}
+
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var transactions []models.Transaction
+
+	query := database.DB.Model(&models.Transaction{}).Where("user_id = ?", user.ID)
+
+	if txType := c.Query("type"); txType != "" {
+		query = query.Where("type = ?", txType)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	from, to, err := rangeParams(c.Query("from"), c.Query("to"), loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !from.IsZero() {
+		query = query.Where("created_at >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("created_at < ?", to)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	if err := query.Order("created_at DESC").Limit(100).Find(&transactions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  transactions,
+		"total": total,
+	})
+}
+
+// PortalCards returns the card holder's cards
+func PortalCards(c *gin.Context) {
+	user, ok := portalUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, []models.PortalCard{portalCard(user)})
+}
+
+func portalCard(user models.User) models.PortalCard {
+	return models.PortalCard{
+		RFIDCardID:  receipt.MaskCard(user.RFIDCardID),
+		Blocked:     user.CardBlocked,
+		BlockedAt:   user.CardBlockedAt,
+		BlockReason: user.CardBlockReason,
+	}
+}
+
+// PortalBlockCard blocks the card holder's card, e.g. when it is lost.
+// Only an admin can unblock it.
+func PortalBlockCard(c *gin.Context) {
+	var req models.BlockCardRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := portalUser(c)
+	if !ok {
+		return
+	}
+	if user.CardBlocked {
+		c.JSON(http.StatusOK, portalCard(user))
+		return
+	}
+
+	before := user
+	if req.Reason == "" {
+		req.Reason = "Blocked by card holder"
+	}
+	blockCard(&user, req.Reason)
+
+	if err := database.DB.Model(&user).UpdateColumns(map[string]interface{}{
+		"card_blocked":      true,
+		"card_blocked_at":   user.CardBlockedAt,
+		"card_block_reason": user.CardBlockReason,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to block card"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.PortalBlockCard,
+		Resource:   audit.ResourceUser,
+		ResourceID: user.ID.String(),
+		Before:     before,
+		After:      user,
+	})
+
+	c.JSON(http.StatusOK, portalCard(user))
+}
+
+// blockCard marks the user's card as blocked now
+func blockCard(user *models.User, reason string) {
+	now := time.Now()
+	user.CardBlocked = true
+	user.CardBlockedAt = &now
+	user.CardBlockReason = reason
+}
+
+// PortalChangePIN sets the card holder's PIN. Changing an existing PIN
+// needs the current one.
+func PortalChangePIN(c *gin.Context) {
+	var req models.ChangePINRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := portalUser(c)
+	if !ok {
+		return
+	}
+
+	if !pin.Valid(req.NewPIN) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": pin.ErrInvalidFormat.Error()})
+		return
+	}
+
+	if user.PINHash != "" {
+		if err := pin.Verify(database.DB, &user, req.CurrentPIN); err != nil {
+			pinError(c, user, err)
+			return
+		}
+	}
+
+	if err := pin.Set(database.DB, &user, req.NewPIN); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set PIN"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.PortalChangePIN,
+		Resource:   audit.ResourceUser,
+		ResourceID: user.ID.String(),
+		After:      gin.H{"pin_set_at": user.PINSetAt},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "PIN changed", "pin_set_at": user.PINSetAt})
+}
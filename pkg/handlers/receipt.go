@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/config"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/receipt"
+	"github.com/lazypwny751/hudautomata/pkg/reports"
+)
+
+// deviceReceiptWindow is how long after a scan the device can fetch its
+// receipt without authentication
+const deviceReceiptWindow = 10 * time.Minute
+
+// GetTransactionReceipt returns the receipt of a transaction as a PDF or,
+// with format=escpos, as a raw ESC/POS byte stream
+func GetTransactionReceipt(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+		return
+	}
+
+	transaction, err := receipt.Load(database.DB, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+
+	writeReceipt(c, receipt.New(transaction, config.AppConfig.ReceiptHeader, loc), c.DefaultQuery("format", "pdf"))
+}
+
+// GetDeviceReceipt lets the device that charged a card print the receipt
+// on its attached printer. Only the device's own charges from the last few
+// minutes are served, as ESC/POS unless format=pdf.
+func GetDeviceReceipt(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+		return
+	}
+
+	deviceID := c.Query("device_id")
+	transaction, err := receipt.Load(database.DB, id)
+	if err != nil || deviceID == "" || transaction.DeviceID != deviceID ||
+		transaction.Source != models.SourceAutomation ||
+		time.Since(transaction.CreatedAt) > deviceReceiptWindow {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
+		return
+	}
+
+	writeReceipt(c, receipt.New(transaction, config.AppConfig.ReceiptHeader, reports.Location), c.DefaultQuery("format", "escpos"))
+}
+
+// writeReceipt renders a receipt in the requested format. ESC/POS output
+// takes the printer's characters per line from the columns parameter.
+func writeReceipt(c *gin.Context, r receipt.Receipt, format string) {
+	filename := "receipt-" + r.TransactionID
+
+	switch format {
+	case "pdf":
+		c.Header("Content-Disposition", `inline; filename="`+filename+`.pdf"`)
+		c.Data(http.StatusOK, "application/pdf", receipt.PDF(r))
+	case "escpos":
+		columns := receipt.DefaultColumns
+		if value := c.Query("columns"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < receipt.MinColumns || n > receipt.MaxColumns {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "columns must be between " +
+					strconv.Itoa(receipt.MinColumns) + " and " + strconv.Itoa(receipt.MaxColumns)})
+				return
+			}
+			columns = n
+		}
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.bin"`)
+		c.Data(http.StatusOK, "application/octet-stream", receipt.ESCPOS(r, columns))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be pdf or escpos"})
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lazypwny751/hudautomata/pkg/audit"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/reports"
+)
+
+// GetReportSummary totals completed transactions per user, device, service,
+// type or source over a range, today by default
+func GetReportSummary(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	from, to, err := rangeParams(c.Query("from"), c.Query("to"), loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	todayStart, todayEnd := reports.Day(time.Now(), loc)
+	if from.IsZero() {
+		from = todayStart
+	}
+	if to.IsZero() {
+		to = todayEnd
+	}
+
+	query := reports.SummaryQuery{
+		From:     from,
+		To:       to,
+		GroupBy:  c.DefaultQuery("group", "type"),
+		Location: loc,
+	}
+	if err := query.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := reports.Summarize(database.DB, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build summary"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":     from.In(loc),
+		"to":       to.In(loc),
+		"timezone": loc.String(),
+		"group":    query.GroupBy,
+		"data":     rows,
+	})
+}
+
+// RebuildRollups recomputes the transaction rollups from the ledger
+func RebuildRollups(c *gin.Context) {
+	rows, err := reports.RebuildRollups(database.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild rollups"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:   audit.RollupsRebuild,
+		Resource: audit.ResourceRollups,
+		After:    gin.H{"rows": rows, "timezone": reports.Location.String()},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"rows": rows, "timezone": reports.Location.String()})
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/audit"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+)
+
+// ListRoles returns all roles with their permissions
+func ListRoles(c *gin.Context) {
+	var roles []models.Role
+	if err := database.DB.Order("name ASC").Find(&roles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, roles)
+}
+
+// ListPermissions returns every permission that can be assigned to a role
+func ListPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, models.AllPermissions)
+}
+
+// GetRole returns a single role
+func GetRole(c *gin.Context) {
+	id := c.Param("id")
+	roleID, err := uuid.Parse(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	var role models.Role
+	if err := database.DB.First(&role, roleID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+// CreateRole creates a custom role
+func CreateRole(c *gin.Context) {
+	var req models.CreateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if invalid := invalidPermission(req.Permissions); invalid != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown permission: " + invalid})
+		return
+	}
+	if denied := ungrantable(currentActor(c), req.Permissions); denied != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant a permission you do not hold: " + denied})
+		return
+	}
+
+	if _, err := loadRole(req.Name); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Role already exists"})
+		return
+	}
+
+	role := models.Role{
+		Name:                  req.Name,
+		Description:           req.Description,
+		Permissions:           req.Permissions,
+		MaxTransactionAmount:  req.MaxTransactionAmount,
+		DailyTransactionLimit: req.DailyTransactionLimit,
+		ApprovalThreshold:     req.ApprovalThreshold,
+	}
+
+	if err := database.DB.Create(&role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.RoleCreate,
+		Resource:   audit.ResourceRole,
+		ResourceID: role.ID.String(),
+		After:      role,
+	})
+
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole updates the description, permissions or limit of a role.
+// Admins with the role get the new permissions on their next request.
+func UpdateRole(c *gin.Context) {
+	id := c.Param("id")
+	roleID, err := uuid.Parse(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	var req models.UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var role models.Role
+	if err := database.DB.First(&role, roleID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	if role.Name == models.RoleSuperAdmin && req.Permissions != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Super admin permissions cannot be changed"})
+		return
+	}
+
+	before := role
+
+	if req.Description != nil {
+		role.Description = *req.Description
+	}
+	if req.Permissions != nil {
+		if invalid := invalidPermission(req.Permissions); invalid != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown permission: " + invalid})
+			return
+		}
+		// Permissions the role already has may stay; new ones must be the actor's own
+		var added []string
+		for _, p := range req.Permissions {
+			if !models.HasPermission(role.Permissions, p) {
+				added = append(added, p)
+			}
+		}
+		if denied := ungrantable(currentActor(c), added); denied != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant a permission you do not hold: " + denied})
+			return
+		}
+		role.Permissions = req.Permissions
+	}
+	if req.MaxTransactionAmount != nil {
+		role.MaxTransactionAmount = limitOrNil(*req.MaxTransactionAmount)
+	}
+	if req.DailyTransactionLimit != nil {
+		role.DailyTransactionLimit = limitOrNil(*req.DailyTransactionLimit)
+	}
+	if req.ApprovalThreshold != nil {
+		role.ApprovalThreshold = limitOrNil(*req.ApprovalThreshold)
+	}
+
+	if err := database.DB.Save(&role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.RoleUpdate,
+		Resource:   audit.ResourceRole,
+		ResourceID: role.ID.String(),
+		Before:     before,
+		After:      role,
+	})
+
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole deletes a custom role that is no longer assigned
+func DeleteRole(c *gin.Context) {
+	id := c.Param("id")
+	roleID, err := uuid.Parse(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	var role models.Role
+	if err := database.DB.First(&role, roleID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	if role.BuiltIn {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Built-in roles cannot be deleted"})
+		return
+	}
+
+	var assigned int64
+	database.DB.Unscoped().Model(&models.Admin{}).Where("role = ?", role.Name).Count(&assigned)
+	if assigned > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Role is still assigned to admins"})
+		return
+	}
+
+	if err := database.DB.Delete(&role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.RoleDelete,
+		Resource:   audit.ResourceRole,
+		ResourceID: role.ID.String(),
+		Before:     role,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
+}
+
+// loadRole looks up a role by name
+func loadRole(name models.AdminRole) (models.Role, error) {
+	var role models.Role
+	err := database.DB.Where("name = ?", name).First(&role).Error
+	return role, err
+}
+
+// currentRole returns the role of the authenticated admin
+func currentRole(c *gin.Context) (models.Role, error) {
+	role, _ := c.Get("admin_role")
+	name, _ := role.(string)
+	return loadRole(models.AdminRole(name))
+}
+
+// limitOrNil treats a zero limit as "no limit"
+func limitOrNil(limit float64) *float64 {
+	if limit == 0 {
+		return nil
+	}
+	return &limit
+}
+
+// invalidPermission returns the first unknown permission in perms, if any
+func invalidPermission(perms []string) string {
+	for _, p := range perms {
+		if !models.IsValidPermission(p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// ungrantable returns the first of perms the actor may not hand out, if any.
+// Actors can only grant permissions they hold themselves, so "*" is left to
+// super admins.
+func ungrantable(act actor, perms []string) string {
+	for _, p := range perms {
+		if !models.HasPermission(act.Permissions, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// assignable checks that the actor may give an admin the role: a role's
+// permissions must all be the actor's own, and only super admins can make
+// other super admins. The error response has been written if not.
+func assignable(c *gin.Context, role models.Role) bool {
+	act := currentActor(c)
+	if role.Name == models.RoleSuperAdmin && !models.HasPermission(act.Permissions, models.PermAll) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only super admins can assign the super admin role"})
+		return false
+	}
+	if denied := ungrantable(act, role.Permissions); denied != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot assign a role with a permission you do not hold: " + denied})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/audit"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+)
+
+var errExceedsDebt = errors.New("amount exceeds the debt")
+
+// ListDebtors reports the users whose balance is below zero, deepest debt
+// first, with the totals
+func ListDebtors(c *gin.Context) {
+	var users []models.User
+	if err := database.DB.Where("balance < 0").Order("balance ASC").Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users in debt"})
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+
+	// Newest first, so the first one seen per user is the latest
+	var settlements []models.Settlement
+	if len(ids) > 0 {
+		if err := database.DB.Select("user_id", "created_at").
+			Where("user_id IN ? AND status = ?", ids, models.SettlementCompleted).
+			Order("created_at DESC").Find(&settlements).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settlements"})
+			return
+		}
+	}
+	lastSettled := map[uuid.UUID]models.Settlement{}
+	for _, s := range settlements {
+		if _, ok := lastSettled[s.UserID]; !ok {
+			lastSettled[s.UserID] = s
+		}
+	}
+
+	debtors := make([]models.DebtorInfo, 0, len(users))
+	var totalDebt float64
+	var overLimit int
+	for _, user := range users {
+		info := models.DebtorInfo{
+			UserID:      user.ID,
+			RFIDCardID:  user.RFIDCardID,
+			Name:        user.Name,
+			Email:       user.Email,
+			Balance:     user.Balance,
+			Debt:        user.Debt(),
+			CreditLimit: user.CreditLimit,
+			Available:   round(user.Available()),
+			OverLimit:   round(user.Debt()) > round(user.Overdraft()),
+		}
+		if s, ok := lastSettled[user.ID]; ok {
+			at := s.CreatedAt
+			info.LastSettledAt = &at
+		}
+		if info.OverLimit {
+			overLimit++
+		}
+		totalDebt += info.Debt
+		debtors = append(debtors, info)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":       debtors,
+		"total":      len(debtors),
+		"total_debt": round(totalDebt),
+		"over_limit": overLimit,
+	})
+}
+
+// SettleDebt records a repayment of the user's debt, the whole debt unless
+// an amount is given. The repayment is booked as a credit under the usual
+// limits and approval threshold; cash repayments count towards the admin's
+// cash session. A credit held for approval leaves the settlement pending
+// with the projected debt until the credit is resolved.
+func SettleDebt(c *gin.Context) {
+	var req models.SettleDebtRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	debt := round(user.Debt())
+	if debt == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "User has no debt"})
+		return
+	}
+
+	amount := debt
+	if req.Amount != nil {
+		amount = round(*req.Amount)
+	}
+	if amount > debt {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Amount exceeds the debt", "debt": debt})
+		return
+	}
+
+	description := "Debt settlement (" + string(req.Method) + ")"
+	if req.Reference != "" {
+		description += ": " + req.Reference
+	}
+
+	act := currentActor(c)
+	var settlement models.Settlement
+	transaction, ok, err := issueTransaction(c, models.CreateTransactionRequest{
+		UserID:      user.ID,
+		Type:        models.TypeCredit,
+		Amount:      amount,
+		Description: description,
+		NonCash:     req.Method != models.SettleCash,
+	}, func(tx *gorm.DB, t *models.Transaction) error {
+		// The balance may have moved since it was read above
+		debtBefore := round(math.Max(0, -t.BalanceBefore))
+		if amount > debtBefore {
+			return errExceedsDebt
+		}
+
+		status := models.SettlementCompleted
+		if t.Status == models.StatusPending {
+			status = models.SettlementPending
+		}
+
+		settlement = models.Settlement{
+			UserID:        user.ID,
+			TransactionID: t.ID,
+			Amount:        amount,
+			DebtBefore:    debtBefore,
+			DebtAfter:     round(math.Max(0, -t.BalanceAfter)),
+			Method:        req.Method,
+			Status:        status,
+			Reference:     req.Reference,
+			Note:          req.Note,
+			AdminID:       act.AdminID,
+		}
+		return tx.Create(&settlement).Error
+	})
+	if errors.Is(err, errExceedsDebt) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Amount exceeds the debt"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to settle debt"})
+		return
+	}
+	if !ok {
+		return
+	}
+
+	settlement.Transaction = &transaction
+
+	audit.Record(c, audit.Event{
+		Action:     audit.SettlementCreate,
+		Resource:   audit.ResourceSettlement,
+		ResourceID: settlement.ID.String(),
+		Before:     gin.H{"user_id": user.ID, "debt": settlement.DebtBefore},
+		After:      gin.H{"user_id": user.ID, "debt": settlement.DebtAfter, "amount": amount, "method": req.Method, "status": settlement.Status},
+	})
+
+	if settlement.Status == models.SettlementPending {
+		c.JSON(http.StatusAccepted, settlement)
+		return
+	}
+	c.JSON(http.StatusCreated, settlement)
+}
+
+// resolveSettlement completes the pending settlement booked by a credit once
+// the credit is approved, with the debt as it was then, or voids it when the
+// credit is rejected. It runs inside the resolving transaction.
+func resolveSettlement(tx *gorm.DB, t *models.Transaction) error {
+	updates := map[string]interface{}{"status": models.SettlementVoid}
+	if t.Status == models.StatusCompleted {
+		updates = map[string]interface{}{
+			"status":      models.SettlementCompleted,
+			"debt_before": round(math.Max(0, -t.BalanceBefore)),
+			"debt_after":  round(math.Max(0, -t.BalanceAfter)),
+		}
+	}
+
+	return tx.Model(&models.Settlement{}).
+		Where("transaction_id = ? AND status = ?", t.ID, models.SettlementPending).
+		Updates(updates).Error
+}
+
+// ListSettlements returns recorded debt repayments, newest first
+func ListSettlements(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var settlements []models.Settlement
+
+	query := database.DB.Model(&models.Settlement{}).Preload("User").Preload("Admin").Preload("Transaction")
+
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if method := c.Query("method"); method != "" {
+		query = query.Where("method = ?", method)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if from := c.Query("from"); from != "" {
+		query = query.Where("created_at >= ?", timeParam(from, loc))
+	}
+	if to := c.Query("to"); to != "" {
+		query = query.Where("created_at <= ?", timeParam(to, loc))
+	}
+
+	var total int64
+	query.Count(&total)
+
+	if err := query.Order("created_at DESC").Limit(100).Find(&settlements).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settlements"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  settlements,
+		"total": total,
+	})
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
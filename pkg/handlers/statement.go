@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lazypwny751/hudautomata/pkg/audit"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/mailer"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/reports"
+	"github.com/lazypwny751/hudautomata/pkg/statements"
+	"gorm.io/gorm"
+)
+
+// ListUserStatements returns the stored statements of a user, newest first
+func ListUserStatements(c *gin.Context) {
+	var list []models.Statement
+
+	if err := database.DB.Omit("pdf", "csv").
+		Where("user_id = ?", c.Param("id")).
+		Order("period DESC").
+		Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch statements"})
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// GetUserStatement returns a user's statement for a month (YYYY-MM) as
+// JSON, or with format=pdf or csv as a document. Stored documents are
+// served as they were generated; other months, including the current one,
+// are built on the fly.
+func GetUserStatement(c *gin.Context) {
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	period := c.Param("period")
+	if _, _, err := statements.Period(period, reports.Location); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "pdf" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json, pdf or csv"})
+		return
+	}
+
+	var stored *models.Statement
+	var found models.Statement
+	err := database.DB.Where("user_id = ? AND period = ?", user.ID, period).First(&found).Error
+	switch {
+	case err == nil:
+		stored = &found
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch statement"})
+		return
+	}
+
+	if stored != nil && (format == "pdf" || format == "csv") {
+		writeStatement(c, period, format, stored.PDF, stored.CSV)
+		return
+	}
+
+	st, err := statements.Build(database.DB, user, period)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build statement"})
+		return
+	}
+
+	switch format {
+	case "pdf":
+		writeStatement(c, period, format, statements.PDF(st), nil)
+	case "csv":
+		writeStatement(c, period, format, nil, statements.CSV(st))
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"statement": st,
+			"stored":    stored,
+		})
+	}
+}
+
+func writeStatement(c *gin.Context, period, format string, pdf, csv []byte) {
+	filename := `attachment; filename="statement-` + period + `.` + format + `"`
+	c.Header("Content-Disposition", filename)
+	if format == "pdf" {
+		c.Data(http.StatusOK, "application/pdf", pdf)
+		return
+	}
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", csv)
+}
+
+// EmailUserStatement stores a user's statement for an ended month if
+// needed and e-mails it to the user
+func EmailUserStatement(c *gin.Context) {
+	if mailer.Default == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Mail is not configured"})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User has no e-mail address"})
+		return
+	}
+
+	stored, _, err := statements.Generate(database.DB, user, c.Param("period"))
+	if errors.Is(err, statements.ErrInvalidPeriod) || errors.Is(err, statements.ErrPeriodNotOver) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate statement"})
+		return
+	}
+
+	if err := statements.Email(database.DB, mailer.Default, user, stored); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send statement: " + err.Error()})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.StatementEmail,
+		Resource:   audit.ResourceStatement,
+		ResourceID: stored.ID.String(),
+		After:      gin.H{"user_id": user.ID, "period": stored.Period, "emailed_to": stored.EmailedTo},
+	})
+
+	c.JSON(http.StatusOK, stored)
+}
+
+// RunStatements stores the statements of all active users for an ended
+// month, last month by default, and optionally e-mails them
+func RunStatements(c *gin.Context) {
+	var req models.RunStatementsRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Period == "" {
+		req.Period = statements.LastPeriod(time.Now(), reports.Location)
+	}
+
+	result, err := statements.Run(database.DB, req.Period, req.Email, mailer.Default)
+	switch {
+	case errors.Is(err, statements.ErrInvalidPeriod), errors.Is(err, statements.ErrPeriodNotOver):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, mailer.ErrNotConfigured):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Mail is not configured"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate statements"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.StatementsRun,
+		Resource:   audit.ResourceStatement,
+		ResourceID: req.Period,
+		After:      result,
+	})
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/audit"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/ledger"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+)
+
+// topUpStatusWindow is how long a resolved request is still shown to the
+// card holder when the card is checked
+const topUpStatusWindow = 7 * 24 * time.Hour
+
+// errTopUpResolved is returned when a request was resolved concurrently
+var errTopUpResolved = errors.New("top-up request is already resolved")
+
+// fileTopUp creates a pending top-up request for a user, or returns the one
+// already pending; created reports which. The user row is locked, so two
+// concurrent requests cannot both find none pending.
+func fileTopUp(req models.TopUpRequest) (models.TopUpRequest, bool, error) {
+	created := false
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := ledger.LockUser(tx, req.UserID); err != nil {
+			return err
+		}
+
+		var pending models.TopUpRequest
+		err := tx.Where("user_id = ? AND status = ?", req.UserID, models.TopUpPending).
+			Order("created_at DESC").
+			First(&pending).Error
+		if err == nil {
+			req = pending
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		req.Status = models.TopUpPending
+		created = true
+		return tx.Create(&req).Error
+	})
+	return req, created, err
+}
+
+// topUpStatus returns the user's pending top-up request, or else the latest
+// one resolved within topUpStatusWindow; nil when there is none
+func topUpStatus(userID uuid.UUID) *models.TopUpStatusInfo {
+	var req models.TopUpRequest
+	err := database.DB.Where("user_id = ?", userID).
+		Where("status = ? OR resolved_at >= ?", models.TopUpPending, time.Now().UTC().Add(-topUpStatusWindow)).
+		Order("CASE WHEN status = 'pending' THEN 0 ELSE 1 END, created_at DESC").
+		First(&req).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Failed to fetch top-up request status: %v", err)
+		}
+		return nil
+	}
+	return topUpInfo(req)
+}
+
+func topUpInfo(req models.TopUpRequest) *models.TopUpStatusInfo {
+	return &models.TopUpStatusInfo{
+		ID:     req.ID,
+		Status: req.Status,
+		Amount: req.Amount,
+		Reason: req.Reason,
+	}
+}
+
+// scanTopUp files a top-up request for a scan that lacked balance when the
+// device asked for one; otherwise it reports the latest request
+func scanTopUp(user models.User, req models.AutomationScanRequest, deficit float64) *models.TopUpStatusInfo {
+	if !req.RequestTopUp {
+		return topUpStatus(user.ID)
+	}
+
+	amount := req.TopUpAmount
+	if amount < deficit {
+		amount = deficit
+	}
+
+	filed, _, err := fileTopUp(models.TopUpRequest{
+		UserID:   user.ID,
+		Amount:   amount,
+		Origin:   models.TopUpFromScan,
+		DeviceID: req.DeviceID,
+		Service:  req.Service,
+	})
+	if err != nil {
+		log.Printf("Failed to file top-up request: %v", err)
+		return nil
+	}
+	return topUpInfo(filed)
+}
+
+// FileTopUpRequest lets a card holder ask for balance at a kiosk. A user
+// with a pending request gets that request back instead of a new one.
+func FileTopUpRequest(c *gin.Context) {
+	var req models.FileTopUpRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Where("rfid_card_id = ?", req.RFIDCardID).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if !user.IsActive {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User is not active"})
+		return
+	}
+	if user.CardBlocked {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Card is blocked"})
+		return
+	}
+
+	filed, created, err := fileTopUp(models.TopUpRequest{
+		UserID:   user.ID,
+		Amount:   req.Amount,
+		Origin:   models.TopUpFromKiosk,
+		DeviceID: req.DeviceID,
+		Note:     req.Note,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to file top-up request"})
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, topUpInfo(filed))
+}
+
+// ListTopUpRequests returns the top-up queue, pending requests by default,
+// oldest first
+func ListTopUpRequests(c *gin.Context) {
+	var list []models.TopUpRequest
+
+	status := c.DefaultQuery("status", string(models.TopUpPending))
+	query := database.DB.Model(&models.TopUpRequest{}).Preload("User").Preload("ResolvedBy")
+
+	if status != "all" {
+		query = query.Where("status = ?", status)
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	order := "created_at DESC"
+	if status == string(models.TopUpPending) {
+		order = "created_at ASC"
+	}
+
+	if err := query.Order(order).Limit(100).Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch top-up requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  list,
+		"total": total,
+	})
+}
+
+// GetTopUpRequest returns a top-up request with its credit, if approved
+func GetTopUpRequest(c *gin.Context) {
+	var req models.TopUpRequest
+	if err := database.DB.Preload("User").Preload("ResolvedBy").Preload("Transaction").
+		First(&req, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Top-up request not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+// ApproveTopUpRequest books a credit for a pending top-up request, for the
+// requested amount unless another is given. The credit goes through the
+// same checks as any other; at or above the approval threshold it is held
+// for a second admin and the request stays pending until the credit is
+// resolved.
+func ApproveTopUpRequest(c *gin.Context) {
+	var body models.ApproveTopUpRequest
+	if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req, ok := findPendingTopUp(c)
+	if !ok {
+		return
+	}
+
+	amount := req.Amount
+	if body.Amount != nil {
+		amount = *body.Amount
+	}
+	description := "Top-up request " + req.ID.String()
+	if body.Comment != "" {
+		description += ": " + body.Comment
+	}
+
+	act := currentActor(c)
+	now := time.Now()
+	transaction, ok, err := issueTransaction(c, models.CreateTransactionRequest{
+		UserID:      req.UserID,
+		Type:        models.TypeCredit,
+		Amount:      amount,
+		Description: description,
+	}, func(tx *gorm.DB, t *models.Transaction) error {
+		updates := map[string]interface{}{"transaction_id": t.ID}
+		if t.Status != models.StatusPending {
+			updates["status"] = models.TopUpApproved
+			updates["resolved_by_id"] = act.AdminID
+			updates["resolved_at"] = now
+		}
+
+		// Only the first of two concurrent approvals may book its credit
+		result := tx.Model(&models.TopUpRequest{}).
+			Where("id = ? AND status = ? AND transaction_id IS NULL", req.ID, models.TopUpPending).
+			Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errTopUpResolved
+		}
+		return nil
+	})
+	if errors.Is(err, errTopUpResolved) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Top-up request is already resolved"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve top-up request"})
+		return
+	}
+	if !ok {
+		return
+	}
+
+	before := req
+	req.TransactionID = &transaction.ID
+	req.Transaction = &transaction
+	if transaction.Status != models.StatusPending {
+		req.Status = models.TopUpApproved
+		req.ResolvedByID = act.AdminID
+		req.ResolvedAt = &now
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.TopUpApprove,
+		Resource:   audit.ResourceTopUp,
+		ResourceID: req.ID.String(),
+		Before:     gin.H{"status": before.Status, "amount": before.Amount},
+		After:      gin.H{"status": req.Status, "amount": amount, "transaction_id": transaction.ID},
+	})
+
+	if req.Status == models.TopUpPending {
+		c.JSON(http.StatusAccepted, req)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// resolveTopUp approves or rejects the top-up request whose credit was held
+// for approval, along with the credit. It runs inside the resolving
+// transaction.
+func resolveTopUp(tx *gorm.DB, t *models.Transaction) error {
+	status := models.TopUpRejected
+	if t.Status == models.StatusCompleted {
+		status = models.TopUpApproved
+	}
+
+	return tx.Model(&models.TopUpRequest{}).
+		Where("transaction_id = ? AND status = ?", t.ID, models.TopUpPending).
+		Updates(map[string]interface{}{
+			"status":         status,
+			"resolved_by_id": t.ResolvedByID,
+			"resolved_at":    t.ResolvedAt,
+		}).Error
+}
+
+// RejectTopUpRequest rejects a pending top-up request; the reason is shown
+// to the card holder
+func RejectTopUpRequest(c *gin.Context) {
+	var body models.RejectTopUpRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req, ok := findPendingTopUp(c)
+	if !ok {
+		return
+	}
+
+	act := currentActor(c)
+	now := time.Now()
+	result := database.DB.Model(&models.TopUpRequest{}).
+		Where("id = ? AND status = ? AND transaction_id IS NULL", req.ID, models.TopUpPending).
+		Updates(map[string]interface{}{
+			"status":         models.TopUpRejected,
+			"reason":         body.Reason,
+			"resolved_by_id": act.AdminID,
+			"resolved_at":    now,
+		})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject top-up request"})
+		return
+	}
+	if result.RowsAffected != 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Top-up request is already resolved"})
+		return
+	}
+
+	req.Status = models.TopUpRejected
+	req.Reason = body.Reason
+	req.ResolvedByID = act.AdminID
+	req.ResolvedAt = &now
+
+	audit.Record(c, audit.Event{
+		Action:     audit.TopUpReject,
+		Resource:   audit.ResourceTopUp,
+		ResourceID: req.ID.String(),
+		Before:     gin.H{"status": models.TopUpPending},
+		After:      gin.H{"status": req.Status, "reason": req.Reason},
+	})
+
+	c.JSON(http.StatusOK, req)
+}
+
+// findPendingTopUp loads the request named in the path and checks that it
+// still waits for an answer and has no credit held for approval, writing
+// the error response if not
+func findPendingTopUp(c *gin.Context) (models.TopUpRequest, bool) {
+	var req models.TopUpRequest
+	if err := database.DB.First(&req, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Top-up request not found"})
+		return req, false
+	}
+	if req.Status != models.TopUpPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Top-up request is already resolved"})
+		return req, false
+	}
+	if req.TransactionID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Top-up request's credit is waiting for approval"})
+		return req, false
+	}
+	return req, true
+}
@@ -1,16 +1,26 @@
 package handlers
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/audit"
+	"github.com/lazypwny751/hudautomata/pkg/config"
 	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/ledger"
 	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/reports"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// CreateTransaction creates a new transaction (admin initiated)
+// CreateTransaction creates a new transaction (admin initiated).
+// Credits and refunds at or above the admin's approval threshold are held
+// as pending until a different admin approves them.
 func CreateTransaction(c *gin.Context) {
 	var req models.CreateTransactionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -18,70 +28,449 @@ func CreateTransaction(c *gin.Context) {
 		return
 	}
 
-	adminID, _ := c.Get("admin_id")
-	adminUUID := adminID.(uuid.UUID)
+	transaction, ok, _ := issueTransaction(c, req, nil)
+	if !ok {
+		return
+	}
+
+	if transaction.Status == models.StatusPending {
+		c.JSON(http.StatusAccepted, transaction)
+		return
+	}
+	c.JSON(http.StatusCreated, transaction)
+}
+
+// issueTransaction books a transaction for the current actor: it checks
+// permissions and limits, holds it for approval at or above the threshold,
+// records cash credits against the admin's cash session and audits it. within,
+// if set, runs in the same database transaction. On failure ok is false and
+// the error response has been written, except for errors returned by within,
+// which are returned for the caller to answer.
+func issueTransaction(c *gin.Context, req models.CreateTransactionRequest, within func(tx *gorm.DB, t *models.Transaction) error) (models.Transaction, bool, error) {
+	var transaction models.Transaction
+	act := currentActor(c)
+
+	// Debits and refunds need their own permission on top of transactions:create
+	switch req.Type {
+	case models.TypeDebit:
+		if !models.HasPermission(act.Permissions, models.PermTransactionsDebit) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Missing permission: " + models.PermTransactionsDebit})
+			return transaction, false, nil
+		}
+	case models.TypeRefund:
+		if !models.HasPermission(act.Permissions, models.PermTransactionsRefund) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Missing permission: " + models.PermTransactionsRefund})
+			return transaction, false, nil
+		}
+	}
+
+	limits, err := actorLimits(c, act)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return transaction, false, nil
+	}
+
+	if limits.Single != nil && req.Amount > *limits.Single {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Amount exceeds your single transaction limit"})
+		return transaction, false, nil
+	}
+
+	if limits.Daily != nil {
+		if spent := dailyVolume(database.DB, act); spent+req.Amount > *limits.Daily {
+			dailyLimitReached(c, *limits.Daily, spent)
+			return transaction, false, nil
+		}
+	}
 
 	// Get user
 	var user models.User
 	if err := database.DB.First(&user, req.UserID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
+		return transaction, false, nil
+	}
+
+	transaction = models.Transaction{
+		UserID:      req.UserID,
+		AdminID:     act.AdminID,
+		APIKeyID:    act.APIKeyID,
+		Type:        req.Type,
+		Amount:      req.Amount,
+		Description: req.Description,
+		Source:      models.SourceAdmin,
+		RequestID:   c.GetString("request_id"),
 	}
+	if act.APIKeyID != nil {
+		transaction.Source = models.SourceAPI
+	}
+
+	needsApproval := transaction.IsIncoming() && limits.Approval != nil && req.Amount >= *limits.Approval
+
+	// Update user balance and create transaction in a transaction
+	var withinErr error
+	var spent float64
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Check the daily limit again with the actor locked, so concurrent
+		// issues by the same admin or key cannot both pass the check above
+		if limits.Daily != nil {
+			if err := lockActor(tx, act); err != nil {
+				return err
+			}
+			if spent = dailyVolume(tx, act); spent+req.Amount > *limits.Daily {
+				return errDailyLimit
+			}
+		}
+
+		if !req.NonCash {
+			if err := recordCashCredit(tx, &transaction); err != nil {
+				return err
+			}
+		}
 
-	// Validate transaction
-	if req.Type == models.TypeDebit && user.Balance < req.Amount {
+		if !needsApproval {
+			if err := ledger.Apply(tx, &transaction); err != nil {
+				return err
+			}
+		} else {
+			if err := ledger.Hold(tx, &transaction); err != nil {
+				return err
+			}
+
+			err := tx.Create(&models.TransactionApproval{
+				TransactionID: transaction.ID,
+				AdminID:       act.AdminID,
+				APIKeyID:      act.APIKeyID,
+				Action:        models.ApprovalRequested,
+				Comment:       req.Description,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if within != nil {
+			withinErr = within(tx, &transaction)
+			return withinErr
+		}
+		return nil
+	})
+
+	if withinErr != nil {
+		return transaction, false, withinErr
+	}
+	if errors.Is(err, errDailyLimit) {
+		dailyLimitReached(c, *limits.Daily, spent)
+		return transaction, false, nil
+	}
+	if errors.Is(err, ledger.ErrInsufficientBalance) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient balance"})
+		return transaction, false, nil
+	}
+	if errors.Is(err, ledger.ErrDayClosed) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Business day is closed"})
+		return transaction, false, nil
+	}
+	if errors.Is(err, errNoCashSession) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Open a cash session before issuing credit"})
+		return transaction, false, nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create transaction"})
+		return transaction, false, nil
+	}
+
+	if needsApproval {
+		audit.Record(c, audit.Event{
+			Action:     audit.TransactionRequest,
+			Resource:   audit.ResourceTransaction,
+			ResourceID: transaction.ID.String(),
+			After:      transaction,
+		})
+		return transaction, true, nil
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.TransactionCreate,
+		Resource:   audit.ResourceTransaction,
+		ResourceID: transaction.ID.String(),
+		Before:     gin.H{"user_id": user.ID, "balance": transaction.BalanceBefore},
+		After:      gin.H{"user_id": user.ID, "balance": transaction.BalanceAfter},
+	})
+
+	return transaction, true, nil
+}
+
+// ListPendingTransactions returns transactions waiting for approval
+func ListPendingTransactions(c *gin.Context) {
+	var transactions []models.Transaction
+
+	if err := database.DB.Where("status = ?", models.StatusPending).
+		Preload("User").
+		Preload("Admin").
+		Preload("Approvals.Admin").
+		Order("created_at ASC").
+		Find(&transactions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transactions"})
 		return
 	}
 
-	// Create transaction
-	balanceBefore := user.Balance
-	var balanceAfter float64
+	c.JSON(http.StatusOK, transactions)
+}
 
-	if req.Type == models.TypeCredit || req.Type == models.TypeRefund {
-		balanceAfter = balanceBefore + req.Amount
-	} else {
-		balanceAfter = balanceBefore - req.Amount
+// ApproveTransaction applies a pending transaction to the user's balance.
+// The approving admin must differ from the one who requested it.
+func ApproveTransaction(c *gin.Context) {
+	resolveTransaction(c, models.ApprovalApproved)
+}
+
+// RejectTransaction rejects a pending transaction; a comment is required
+func RejectTransaction(c *gin.Context) {
+	resolveTransaction(c, models.ApprovalRejected)
+}
+
+// errNotPending is returned when another admin resolved the transaction first
+var errNotPending = errors.New("transaction is not pending")
+
+func resolveTransaction(c *gin.Context, action models.ApprovalAction) {
+	id := c.Param("id")
+	txID, err := uuid.Parse(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+		return
 	}
 
-	transaction := models.Transaction{
-		UserID:        req.UserID,
-		AdminID:       &adminUUID,
-		Type:          req.Type,
-		Amount:        req.Amount,
-		BalanceBefore: balanceBefore,
-		BalanceAfter:  balanceAfter,
-		Description:   req.Description,
-		Source:        models.SourceAdmin,
+	var req models.ResolveTransactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	// Update user balance and create transaction in a transaction
-	err := database.DB.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Create(&transaction).Error; err != nil {
-			return err
+	if action == models.ApprovalRejected && req.Comment == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required to reject a transaction"})
+		return
+	}
+
+	act := currentActor(c)
+	if act.AdminID == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can resolve transactions"})
+		return
+	}
+	adminUUID := *act.AdminID
+
+	var transaction models.Transaction
+	if err := database.DB.First(&transaction, txID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+
+	if transaction.Status != models.StatusPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Transaction is not pending"})
+		return
+	}
+
+	if transaction.AdminID != nil && *transaction.AdminID == adminUUID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "A different admin must resolve this transaction"})
+		return
+	}
+
+	before := transaction
+	now := time.Now().UTC()
+	transaction.ResolvedByID = &adminUUID
+	transaction.ResolvedAt = &now
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Claim the row so only the first of two concurrent resolutions books it
+		result := tx.Model(&models.Transaction{}).
+			Where("id = ? AND status = ?", transaction.ID, models.StatusPending).
+			UpdateColumns(map[string]interface{}{
+				"resolved_by_id": adminUUID,
+				"resolved_at":    now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errNotPending
 		}
 
-		user.Balance = balanceAfter
-		if err := tx.Save(&user).Error; err != nil {
+		if action == models.ApprovalApproved {
+			if err := ledger.Apply(tx, &transaction); err != nil {
+				return err
+			}
+		} else {
+			if err := ledger.Reject(tx, &transaction); err != nil {
+				return err
+			}
+		}
+		if err := resolveSettlement(tx, &transaction); err != nil {
+			return err
+		}
+		if err := resolveTopUp(tx, &transaction); err != nil {
 			return err
 		}
 
-		return nil
+		return tx.Create(&models.TransactionApproval{
+			TransactionID: transaction.ID,
+			AdminID:       &adminUUID,
+			Action:        action,
+			Comment:       req.Comment,
+		}).Error
 	})
 
+	if errors.Is(err, errNotPending) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Transaction is not pending"})
+		return
+	}
+	if errors.Is(err, ledger.ErrInsufficientBalance) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient balance"})
+		return
+	}
+	if errors.Is(err, ledger.ErrDayClosed) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Business day is closed"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create transaction"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve transaction"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, transaction)
+	auditAction := audit.TransactionApprove
+	if action == models.ApprovalRejected {
+		auditAction = audit.TransactionReject
+	}
+	audit.Record(c, audit.Event{
+		Action:     auditAction,
+		Resource:   audit.ResourceTransaction,
+		ResourceID: transaction.ID.String(),
+		Before:     before,
+		After:      transaction,
+	})
+
+	database.DB.Preload("Approvals.Admin").First(&transaction, transaction.ID)
+
+	c.JSON(http.StatusOK, transaction)
+}
+
+// transactionLimits holds the limits that apply to an actor
+type transactionLimits struct {
+	Single   *float64
+	Daily    *float64
+	Approval *float64
+}
+
+// actorLimits resolves the limits of the actor. For admins per-admin
+// overrides win over the role; API keys carry their own limits. The
+// global approval threshold applies when nothing more specific is set.
+func actorLimits(c *gin.Context, act actor) (transactionLimits, error) {
+	if act.APIKeyID != nil {
+		var key models.APIKey
+		if err := database.DB.First(&key, *act.APIKeyID).Error; err != nil {
+			return transactionLimits{}, errors.New("API key not found")
+		}
+		return transactionLimits{
+			Single:   key.MaxTransactionAmount,
+			Daily:    key.DailyTransactionLimit,
+			Approval: configThreshold(),
+		}, nil
+	}
+
+	if act.AdminID == nil {
+		return transactionLimits{}, errors.New("Access denied")
+	}
+
+	var admin models.Admin
+	if err := database.DB.First(&admin, *act.AdminID).Error; err != nil {
+		return transactionLimits{}, errors.New("Admin not found")
+	}
+
+	role, err := currentRole(c)
+	if err != nil {
+		return transactionLimits{}, errors.New("Assigned role does not exist")
+	}
+
+	return transactionLimits{
+		Single:   firstLimit(admin.MaxTransactionAmount, role.MaxTransactionAmount),
+		Daily:    firstLimit(admin.DailyTransactionLimit, role.DailyTransactionLimit),
+		Approval: firstLimit(admin.ApprovalThreshold, role.ApprovalThreshold, configThreshold()),
+	}, nil
+}
+
+// firstLimit returns the first configured limit
+func firstLimit(limits ...*float64) *float64 {
+	for _, limit := range limits {
+		if limit != nil {
+			return limit
+		}
+	}
+	return nil
+}
+
+// configThreshold returns the global approval threshold, if enabled
+func configThreshold() *float64 {
+	if config.AppConfig == nil || config.AppConfig.ApprovalThreshold <= 0 {
+		return nil
+	}
+	return &config.AppConfig.ApprovalThreshold
+}
+
+// errDailyLimit is returned when a transaction would exceed the actor's
+// daily limit
+var errDailyLimit = errors.New("daily transaction limit reached")
+
+// dailyLimitReached answers a transaction that would take the actor past
+// their daily limit
+func dailyLimitReached(c *gin.Context, limit, spent float64) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":     "Amount exceeds your daily transaction limit",
+		"remaining": limit - spent,
+	})
+}
+
+// lockActor locks the admin or API key row for the rest of the transaction.
+// SQLite serialises writers itself and does not understand FOR UPDATE.
+func lockActor(tx *gorm.DB, act actor) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id")
+	if act.APIKeyID != nil {
+		return query.First(&models.APIKey{}, *act.APIKeyID).Error
+	}
+	return query.First(&models.Admin{}, *act.AdminID).Error
+}
+
+// dailyVolume sums the amounts the actor has booked or requested today, in
+// the business time zone
+func dailyVolume(db *gorm.DB, act actor) float64 {
+	var total float64
+	dayStart, _ := database.BucketStart(time.Now(), database.BucketDay, reports.Location)
+
+	query := db.Model(&models.Transaction{}).
+		Where("created_at >= ? AND status IN ?", dayStart.UTC(),
+			[]models.TransactionStatus{models.StatusCompleted, models.StatusPending})
+
+	if act.APIKeyID != nil {
+		query = query.Where("api_key_id = ?", *act.APIKeyID)
+	} else {
+		query = query.Where("admin_id = ?", *act.AdminID)
+	}
+
+	query.Select("COALESCE(SUM(amount), 0)").Scan(&total)
+
+	return total
 }
 
 // ListTransactions returns all transactions with pagination
 func ListTransactions(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var transactions []models.Transaction
 	
-	query := database.DB.Model(&models.Transaction{}).Preload("User").Preload("Admin")
+	query := database.DB.Model(&models.Transaction{}).Preload("User").Preload("Admin").Preload("APIKey")
 	
 	// Filter by user
 	if userID := c.Query("user_id"); userID != "" {
@@ -98,12 +487,22 @@ func ListTransactions(c *gin.Context) {
 		query = query.Where("source = ?", source)
 	}
 
+	// Filter by request ID
+	if requestID := c.Query("request_id"); requestID != "" {
+		query = query.Where("request_id = ?", requestID)
+	}
+
+	// Filter by status
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
 	// Date range
 	if from := c.Query("from"); from != "" {
-		query = query.Where("created_at >= ?", from)
+		query = query.Where("created_at >= ?", timeParam(from, loc))
 	}
 	if to := c.Query("to"); to != "" {
-		query = query.Where("created_at <= ?", to)
+		query = query.Where("created_at <= ?", timeParam(to, loc))
 	}
 
 	var total int64
@@ -130,7 +529,7 @@ func GetTransaction(c *gin.Context) {
 	}
 
 	var transaction models.Transaction
-	if err := database.DB.Preload("User").Preload("Admin").First(&transaction, txID).Error; err != nil {
+	if err := database.DB.Preload("User").Preload("Admin").Preload("APIKey").Preload("ResolvedBy").Preload("Approvals.Admin").First(&transaction, txID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
 		return
 	}
@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/audit"
 	"github.com/lazypwny751/hudautomata/pkg/database"
 	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/pin"
 )
 
 // ListUsers returns all users with pagination
@@ -25,6 +28,11 @@ func ListUsers(c *gin.Context) {
 		query = query.Where("is_active = ?", isActive == "true")
 	}
 
+	// Filter by group
+	if groupID := c.Query("group_id"); groupID != "" {
+		query = query.Where("group_id = ?", groupID)
+	}
+
 	// Pagination
 	page := c.DefaultQuery("page", "1")
 	limit := c.DefaultQuery("limit", "20")
@@ -60,13 +68,23 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
+	if req.GroupID != nil && !userGroupExists(*req.GroupID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown user group"})
+		return
+	}
+
 	user := models.User{
-		RFIDCardID: req.RFIDCardID,
-		Name:       req.Name,
-		Email:      req.Email,
-		Phone:      req.Phone,
-		Balance:    req.Balance,
-		IsActive:   true,
+		RFIDCardID:       req.RFIDCardID,
+		Name:             req.Name,
+		Email:            req.Email,
+		Phone:            req.Phone,
+		Balance:          req.Balance,
+		IsActive:         true,
+		GroupID:          req.GroupID,
+		DailySpendLimit:  req.DailySpendLimit,
+		WeeklySpendLimit: req.WeeklySpendLimit,
+		HourlyScanLimit:  req.HourlyScanLimit,
+		CreditLimit:      req.CreditLimit,
 	}
 
 	if err := database.DB.Create(&user).Error; err != nil {
@@ -74,6 +92,13 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
+	audit.Record(c, audit.Event{
+		Action:     audit.UserCreate,
+		Resource:   audit.ResourceUser,
+		ResourceID: user.ID.String(),
+		After:      user,
+	})
+
 	c.JSON(http.StatusCreated, user)
 }
 
@@ -87,7 +112,7 @@ func GetUser(c *gin.Context) {
 	}
 
 	var user models.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
+	if err := database.DB.Preload("Group").First(&user, userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
@@ -129,24 +154,211 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
+	before := user
+
+	// Only the edited columns are written, so balances, PIN attempts and
+	// card blocks changed concurrently by the ledger, PIN checks and portal
+	// are not reverted
+	var columns []string
 	if req.Name != "" {
 		user.Name = req.Name
+		columns = append(columns, "name")
 	}
 	if req.Email != "" {
 		user.Email = req.Email
+		columns = append(columns, "email")
 	}
 	if req.Phone != "" {
 		user.Phone = req.Phone
+		columns = append(columns, "phone")
 	}
 	if req.IsActive != nil {
 		user.IsActive = *req.IsActive
+		columns = append(columns, "is_active")
+	}
+	if req.CardBlocked != nil && *req.CardBlocked != user.CardBlocked {
+		if *req.CardBlocked {
+			blockCard(&user, "Blocked by admin")
+		} else {
+			user.CardBlocked = false
+			user.CardBlockedAt = nil
+			user.CardBlockReason = ""
+		}
+		columns = append(columns, "card_blocked", "card_blocked_at", "card_block_reason")
+	}
+	if req.GroupID != nil {
+		if *req.GroupID == "" {
+			user.GroupID = nil
+		} else {
+			groupID, err := uuid.Parse(*req.GroupID)
+			if err != nil || !userGroupExists(groupID) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown user group"})
+				return
+			}
+			user.GroupID = &groupID
+		}
+		columns = append(columns, "group_id")
+	}
+	if req.DailySpendLimit != nil {
+		user.DailySpendLimit = limitOrNil(*req.DailySpendLimit)
+		columns = append(columns, "daily_spend_limit")
+	}
+	if req.WeeklySpendLimit != nil {
+		user.WeeklySpendLimit = limitOrNil(*req.WeeklySpendLimit)
+		columns = append(columns, "weekly_spend_limit")
+	}
+	if req.HourlyScanLimit != nil {
+		user.HourlyScanLimit = countOrNil(*req.HourlyScanLimit)
+		columns = append(columns, "hourly_scan_limit")
+	}
+	if req.CreditLimit != nil {
+		user.CreditLimit = limitOrNil(*req.CreditLimit)
+		columns = append(columns, "credit_limit")
+	}
+
+	if len(columns) > 0 {
+		err := database.DB.Model(&user).Select(append(columns, "updated_at")).Updates(&user).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+			return
+		}
+	}
+
+	// Answer with the stored row, including concurrent changes
+	database.DB.First(&user, userID)
+
+	audit.Record(c, audit.Event{
+		Action:     audit.UserUpdate,
+		Resource:   audit.ResourceUser,
+		ResourceID: user.ID.String(),
+		Before:     before,
+		After:      user,
+	})
+
+	c.JSON(http.StatusOK, user)
+}
+
+// userGroupExists reports whether a user group exists
+func userGroupExists(id uuid.UUID) bool {
+	var count int64
+	database.DB.Model(&models.UserGroup{}).Where("id = ?", id).Count(&count)
+	return count > 0
+}
+
+// SetUserPIN sets a user's PIN and clears any PIN lockout
+func SetUserPIN(c *gin.Context) {
+	var req models.SetPINRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	before := user
+	if err := pin.Set(database.DB, &user, req.PIN); err != nil {
+		if errors.Is(err, pin.ErrInvalidFormat) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set PIN"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.UserSetPIN,
+		Resource:   audit.ResourceUser,
+		ResourceID: user.ID.String(),
+		Before:     before,
+		After:      user,
+	})
+
+	c.JSON(http.StatusOK, user)
+}
+
+// ResetUserPIN replaces a user's PIN with a temporary one, returned once
+// for the admin to hand over; the user is asked to change it in the portal
+func ResetUserPIN(c *gin.Context) {
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	before := user
+	temporary, err := pin.Reset(database.DB, &user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset PIN"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.UserResetPIN,
+		Resource:   audit.ResourceUser,
+		ResourceID: user.ID.String(),
+		Before:     before,
+		After:      user,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":          user,
+		"temporary_pin": temporary,
+	})
+}
+
+// UnlockUserPIN lifts a PIN lockout, unlocking the card, without changing
+// the PIN
+func UnlockUserPIN(c *gin.Context) {
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
 	}
 
-	if err := database.DB.Save(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+	before := user
+	if err := pin.Unlock(database.DB, &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock PIN"})
 		return
 	}
 
+	audit.Record(c, audit.Event{
+		Action:     audit.UserUnlockPIN,
+		Resource:   audit.ResourceUser,
+		ResourceID: user.ID.String(),
+		Before:     before,
+		After:      user,
+	})
+
+	c.JSON(http.StatusOK, user)
+}
+
+// ClearUserPIN removes a user's PIN; scans stop asking for one and the
+// portal takes e-mail links only
+func ClearUserPIN(c *gin.Context) {
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	before := user
+	if err := pin.Clear(database.DB, &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove PIN"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.UserClearPIN,
+		Resource:   audit.ResourceUser,
+		ResourceID: user.ID.String(),
+		Before:     before,
+		After:      user,
+	})
+
 	c.JSON(http.StatusOK, user)
 }
 
@@ -159,11 +371,24 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 
-	if err := database.DB.Delete(&models.User{}, userID).Error; err != nil {
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := database.DB.Delete(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 		return
 	}
 
+	audit.Record(c, audit.Event{
+		Action:     audit.UserDelete,
+		Resource:   audit.ResourceUser,
+		ResourceID: user.ID.String(),
+		Before:     user,
+	})
+
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 }
 
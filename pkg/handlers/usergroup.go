@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/audit"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+)
+
+// ListUserGroups returns all user groups
+func ListUserGroups(c *gin.Context) {
+	var groups []models.UserGroup
+	if err := database.DB.Order("name ASC").Find(&groups).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user groups"})
+		return
+	}
+
+	c.JSON(http.StatusOK, groups)
+}
+
+// GetUserGroup returns a single user group with its number of users
+func GetUserGroup(c *gin.Context) {
+	group, ok := findUserGroup(c)
+	if !ok {
+		return
+	}
+
+	var members int64
+	database.DB.Model(&models.User{}).Where("group_id = ?", group.ID).Count(&members)
+
+	c.JSON(http.StatusOK, gin.H{
+		"group":   group,
+		"members": members,
+	})
+}
+
+// CreateUserGroup creates a user group
+func CreateUserGroup(c *gin.Context) {
+	var req models.CreateUserGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing models.UserGroup
+	if err := database.DB.Where("name = ?", req.Name).First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "User group already exists"})
+		return
+	}
+
+	group := models.UserGroup{
+		Name:             req.Name,
+		Description:      req.Description,
+		DailySpendLimit:  req.DailySpendLimit,
+		WeeklySpendLimit: req.WeeklySpendLimit,
+		HourlyScanLimit:  req.HourlyScanLimit,
+	}
+
+	if err := database.DB.Create(&group).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user group"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.UserGroupCreate,
+		Resource:   audit.ResourceUserGroup,
+		ResourceID: group.ID.String(),
+		After:      group,
+	})
+
+	c.JSON(http.StatusCreated, group)
+}
+
+// UpdateUserGroup updates the name, description or limits of a user group
+func UpdateUserGroup(c *gin.Context) {
+	var req models.UpdateUserGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	group, ok := findUserGroup(c)
+	if !ok {
+		return
+	}
+
+	before := group
+
+	if req.Name != nil && *req.Name != group.Name {
+		var existing models.UserGroup
+		if err := database.DB.Where("name = ?", *req.Name).First(&existing).Error; err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "User group already exists"})
+			return
+		}
+		group.Name = *req.Name
+	}
+	if req.Description != nil {
+		group.Description = *req.Description
+	}
+	if req.DailySpendLimit != nil {
+		group.DailySpendLimit = limitOrNil(*req.DailySpendLimit)
+	}
+	if req.WeeklySpendLimit != nil {
+		group.WeeklySpendLimit = limitOrNil(*req.WeeklySpendLimit)
+	}
+	if req.HourlyScanLimit != nil {
+		group.HourlyScanLimit = countOrNil(*req.HourlyScanLimit)
+	}
+
+	if err := database.DB.Save(&group).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user group"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.UserGroupUpdate,
+		Resource:   audit.ResourceUserGroup,
+		ResourceID: group.ID.String(),
+		Before:     before,
+		After:      group,
+	})
+
+	c.JSON(http.StatusOK, group)
+}
+
+// DeleteUserGroup deletes a user group that has no users left
+func DeleteUserGroup(c *gin.Context) {
+	group, ok := findUserGroup(c)
+	if !ok {
+		return
+	}
+
+	var members int64
+	database.DB.Unscoped().Model(&models.User{}).Where("group_id = ?", group.ID).Count(&members)
+	if members > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "User group still has users"})
+		return
+	}
+
+	if err := database.DB.Delete(&group).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user group"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.UserGroupDelete,
+		Resource:   audit.ResourceUserGroup,
+		ResourceID: group.ID.String(),
+		Before:     group,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "User group deleted successfully"})
+}
+
+func findUserGroup(c *gin.Context) (models.UserGroup, bool) {
+	var group models.UserGroup
+
+	groupID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user group ID"})
+		return group, false
+	}
+
+	if err := database.DB.First(&group, groupID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User group not found"})
+		return group, false
+	}
+	return group, true
+}
+
+// countOrNil treats a zero count limit as "no limit"
+func countOrNil(limit int) *int {
+	if limit == 0 {
+		return nil
+	}
+	return &limit
+}
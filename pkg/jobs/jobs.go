@@ -0,0 +1,46 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Scheduler runs background jobs at fixed intervals until its context ends
+type Scheduler struct {
+	ctx context.Context
+	wg  sync.WaitGroup
+}
+
+// New creates a scheduler whose jobs stop when ctx is cancelled
+func New(ctx context.Context) *Scheduler {
+	return &Scheduler{ctx: ctx}
+}
+
+// Every runs fn every interval. Errors are logged and the job keeps running.
+func (s *Scheduler) Every(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := fn(s.ctx); err != nil {
+					log.Printf("job %s: %v", name, err)
+				}
+			}
+		}
+	}()
+}
+
+// Wait blocks until every job has returned after the context was cancelled
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
@@ -0,0 +1,122 @@
+package ledger
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/reports"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInsufficientBalance is returned when a debit would take the balance
+	// below zero, or below -CreditLimit for users with a credit limit
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	// ErrDayClosed is returned when booking into a business day that has been closed out
+	ErrDayClosed = errors.New("business day is closed")
+)
+
+// Apply books a transaction against its user's balance inside tx.
+// The user row is re-read (and locked where supported) so concurrent
+// transactions cannot work from a stale balance. BalanceBefore,
+// BalanceAfter and Status are filled in; the transaction row is created
+// or, for a previously pending one, updated and counted in the rollups.
+// On ErrInsufficientBalance BalanceBefore still holds the balance that was
+// checked. Nothing can be booked into a closed business day; the day is
+// the one of the transaction's BookedAt, which rollups and closeouts use too.
+func Apply(tx *gorm.DB, t *models.Transaction) error {
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now().UTC()
+	}
+
+	closed, err := reports.DayClosed(tx, t.BookedAt())
+	if err != nil {
+		return err
+	}
+	if closed {
+		return ErrDayClosed
+	}
+
+	user, err := LockUser(tx, t.UserID)
+	if err != nil {
+		return err
+	}
+
+	balanceBefore := user.Balance
+	var balanceAfter float64
+	t.BalanceBefore = balanceBefore
+
+	if t.IsIncoming() {
+		balanceAfter = balanceBefore + t.Amount
+	} else {
+		if user.Available() < t.Amount {
+			return ErrInsufficientBalance
+		}
+		balanceAfter = balanceBefore - t.Amount
+	}
+
+	t.BalanceAfter = balanceAfter
+	t.Status = models.StatusCompleted
+
+	if err := save(tx, t); err != nil {
+		return err
+	}
+	if err := reports.AddRollups(tx, t); err != nil {
+		return err
+	}
+
+	return tx.Model(&user).Update("balance", balanceAfter).Error
+}
+
+// Hold records a transaction as pending without touching the balance.
+// The balance fields show the projected outcome at the time of the request.
+func Hold(tx *gorm.DB, t *models.Transaction) error {
+	var user models.User
+	if err := tx.First(&user, t.UserID).Error; err != nil {
+		return err
+	}
+
+	t.BalanceBefore = user.Balance
+	if t.IsIncoming() {
+		t.BalanceAfter = user.Balance + t.Amount
+	} else {
+		t.BalanceAfter = user.Balance - t.Amount
+	}
+	t.Status = models.StatusPending
+
+	return save(tx, t)
+}
+
+// Reject marks a pending transaction as rejected; the balance is not touched
+func Reject(tx *gorm.DB, t *models.Transaction) error {
+	t.Status = models.StatusRejected
+	return save(tx, t)
+}
+
+// LockUser re-reads the user inside tx, locking the row where supported, so
+// checks made before booking cannot race with a concurrent transaction
+func LockUser(tx *gorm.DB, userID uuid.UUID) (models.User, error) {
+	var user models.User
+
+	query := tx
+	// SQLite serialises writers itself and does not understand FOR UPDATE
+	if tx.Dialector.Name() == "postgres" {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+
+	err := query.First(&user, userID).Error
+	return user, err
+}
+
+// save creates the transaction row when it has no ID yet, otherwise updates
+// it. The chain columns are left alone on update; only the chain sealer
+// writes them.
+func save(tx *gorm.DB, t *models.Transaction) error {
+	if t.ID == uuid.Nil {
+		return tx.Omit(clause.Associations).Create(t).Error
+	}
+	return tx.Omit(clause.Associations, "Seq", "PrevHash", "Hash").Save(t).Error
+}
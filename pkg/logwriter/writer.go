@@ -0,0 +1,194 @@
+package logwriter
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Drop policies for a full queue
+const (
+	// PolicyDrop discards the entry and counts it
+	PolicyDrop = "drop"
+	// PolicyBlock makes the caller wait until there is room
+	PolicyBlock = "block"
+)
+
+// Options configures a Writer
+type Options struct {
+	BufferSize    int
+	BatchSize     int
+	FlushInterval time.Duration
+	DropPolicy    string
+}
+
+// Stats is a snapshot of the writer's counters
+type Stats struct {
+	QueueDepth    int    `json:"queue_depth"`
+	QueueCapacity int    `json:"queue_capacity"`
+	DropPolicy    string `json:"drop_policy"`
+	Enqueued      uint64 `json:"enqueued"`
+	Written       uint64 `json:"written"`
+	Dropped       uint64 `json:"dropped"`
+	Failed        uint64 `json:"failed"`
+	Batches       uint64 `json:"batches"`
+}
+
+// Default is the writer used by the request logger, set up by Start
+var Default *Writer
+
+// Start creates the default writer
+func Start(db *gorm.DB, opts Options) *Writer {
+	Default = New(db, opts)
+	return Default
+}
+
+// Writer queues system logs in a bounded buffer and inserts them in batches
+// from a single goroutine
+type Writer struct {
+	db   *gorm.DB
+	opts Options
+
+	queue chan models.SystemLog
+	done  chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+
+	enqueued atomic.Uint64
+	written  atomic.Uint64
+	dropped  atomic.Uint64
+	failed   atomic.Uint64
+	batches  atomic.Uint64
+}
+
+// New creates a writer and starts its flush loop
+func New(db *gorm.DB, opts Options) *Writer {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 10000
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.DropPolicy != PolicyBlock {
+		opts.DropPolicy = PolicyDrop
+	}
+
+	w := &Writer{
+		db:    db,
+		opts:  opts,
+		queue: make(chan models.SystemLog, opts.BufferSize),
+		done:  make(chan struct{}),
+	}
+
+	go w.run()
+	return w
+}
+
+// Write queues an entry. It returns false when the entry was dropped because
+// the queue is full (drop policy) or the writer is closed.
+func (w *Writer) Write(entry models.SystemLog) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		w.dropped.Add(1)
+		return false
+	}
+
+	if w.opts.DropPolicy == PolicyBlock {
+		w.queue <- entry
+		w.enqueued.Add(1)
+		return true
+	}
+
+	select {
+	case w.queue <- entry:
+		w.enqueued.Add(1)
+		return true
+	default:
+		w.dropped.Add(1)
+		return false
+	}
+}
+
+// Close stops accepting entries and waits until the queue is flushed or ctx expires
+func (w *Writer) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the current queue depth and counters
+func (w *Writer) Stats() Stats {
+	return Stats{
+		QueueDepth:    len(w.queue),
+		QueueCapacity: cap(w.queue),
+		DropPolicy:    w.opts.DropPolicy,
+		Enqueued:      w.enqueued.Load(),
+		Written:       w.written.Load(),
+		Dropped:       w.dropped.Load(),
+		Failed:        w.failed.Load(),
+		Batches:       w.batches.Load(),
+	}
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.SystemLog, 0, w.opts.BatchSize)
+
+	for {
+		select {
+		case entry, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= w.opts.BatchSize {
+				batch = w.flush(batch)
+			}
+		case <-ticker.C:
+			batch = w.flush(batch)
+		}
+	}
+}
+
+// flush inserts the batch and returns it emptied for reuse
+func (w *Writer) flush(batch []models.SystemLog) []models.SystemLog {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	w.batches.Add(1)
+	if err := w.db.Create(&batch).Error; err != nil {
+		w.failed.Add(uint64(len(batch)))
+		log.Printf("logwriter: failed to write %d logs: %v", len(batch), err)
+	} else {
+		w.written.Add(uint64(len(batch)))
+	}
+
+	return batch[:0]
+}
@@ -0,0 +1,184 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotConfigured is returned when sending mail without SMTP settings
+var ErrNotConfigured = errors.New("mail is not configured")
+
+// Options are the SMTP server settings
+type Options struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	// Dir receives messages as .eml files instead of an SMTP server; a
+	// local stand-in for development
+	Dir string
+}
+
+// Attachment is a file sent with a message
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Message is a plain text e-mail with optional attachments
+type Message struct {
+	To          string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// Mailer sends mail through an SMTP server, or writes it to Options.Dir
+// when there is no server. STARTTLS is used when the server offers it.
+type Mailer struct {
+	opts Options
+}
+
+// Default is the mailer configured from SMTP_* and MAIL_DIR, nil when both
+// SMTP_HOST and MAIL_DIR are empty
+var Default *Mailer
+
+// Setup creates the default mailer
+func Setup(opts Options) *Mailer {
+	Default = nil
+	if opts.Host != "" || opts.Dir != "" {
+		Default = New(opts)
+	}
+	return Default
+}
+
+// New creates a mailer
+func New(opts Options) *Mailer {
+	if opts.Port == "" {
+		opts.Port = "587"
+	}
+	return &Mailer{opts: opts}
+}
+
+// Send delivers a message. A nil mailer returns ErrNotConfigured.
+func (m *Mailer) Send(msg Message) error {
+	if m == nil {
+		return ErrNotConfigured
+	}
+	if msg.To == "" {
+		return errors.New("message has no recipient")
+	}
+	if strings.ContainsAny(msg.To, "\r\n") {
+		return fmt.Errorf("invalid recipient %q", msg.To)
+	}
+
+	data, err := m.encode(msg)
+	if err != nil {
+		return err
+	}
+
+	if m.opts.Host == "" {
+		return m.store(data)
+	}
+
+	var auth smtp.Auth
+	if m.opts.Username != "" {
+		auth = smtp.PlainAuth("", m.opts.Username, m.opts.Password, m.opts.Host)
+	}
+
+	addr := net.JoinHostPort(m.opts.Host, m.opts.Port)
+	if err := smtp.SendMail(addr, auth, m.opts.From, []string{msg.To}, data); err != nil {
+		return fmt.Errorf("sending mail to %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+// store writes an encoded message to the mail directory
+func (m *Mailer) store(data []byte) error {
+	if err := os.MkdirAll(m.opts.Dir, 0o755); err != nil {
+		return fmt.Errorf("creating mail directory: %w", err)
+	}
+	name := time.Now().UTC().Format("20060102T150405") + "-" + uuid.NewString() + ".eml"
+	if err := os.WriteFile(filepath.Join(m.opts.Dir, name), data, 0o600); err != nil {
+		return fmt.Errorf("writing mail: %w", err)
+	}
+	return nil
+}
+
+// encode builds the MIME message
+func (m *Mailer) encode(msg Message) ([]byte, error) {
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+
+	headers := []string{
+		"From: " + m.opts.From,
+		"To: " + msg.To,
+		"Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: <" + uuid.NewString() + "@" + m.hostname() + ">",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=" + w.Boundary(),
+	}
+	// The headers go first; the writer only writes once a part is created
+	b.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	body, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	writeBase64(body, []byte(msg.Body))
+
+	for _, a := range msg.Attachments {
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64(part, a.Data)
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// hostname is the domain of generated Message-IDs
+func (m *Mailer) hostname() string {
+	if m.opts.Host == "" {
+		return "localhost"
+	}
+	return m.opts.Host
+}
+
+// writeBase64 writes data base64 encoded in lines of 76 characters
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
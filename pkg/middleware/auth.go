@@ -3,14 +3,22 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
 	"github.com/lazypwny751/hudautomata/pkg/utils"
 )
 
-// AuthMiddleware validates JWT token
+// AuthMiddleware validates JWT token, or an API key sent as X-API-Key
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			authenticateAPIKey(c, apiKey)
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
@@ -34,47 +42,129 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Look the admin and role up on every request, so deactivating or
+		// deleting an admin and role changes apply to tokens already issued
+		var admin models.Admin
+		if err := database.DB.Where("id = ?", claims.AdminID).First(&admin).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			c.Abort()
+			return
+		}
+		if !admin.IsActive {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is inactive"})
+			c.Abort()
+			return
+		}
+
+		var role models.Role
+		if err := database.DB.Where("name = ?", admin.Role).First(&role).Error; err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Assigned role does not exist"})
+			c.Abort()
+			return
+		}
+
 		// Set admin info in context
-		c.Set("admin_id", claims.AdminID)
-		c.Set("admin_username", claims.Username)
-		c.Set("admin_role", claims.Role)
+		c.Set("admin_id", admin.ID)
+		c.Set("admin_username", admin.Username)
+		c.Set("admin_role", string(admin.Role))
+		c.Set("admin_permissions", []string(role.Permissions))
 
 		c.Next()
 	}
 }
 
-// AdminOnly middleware ensures only admins can access
-func AdminOnly() gin.HandlerFunc {
+// PortalAuthMiddleware validates a user portal token. Tokens restricted to
+// changing a temporary PIN are refused.
+func PortalAuthMiddleware() gin.HandlerFunc {
+	return portalAuth(false)
+}
+
+// PortalPINChangeMiddleware validates a user portal token, including one
+// restricted to changing a temporary PIN
+func PortalPINChangeMiddleware() gin.HandlerFunc {
+	return portalAuth(true)
+}
+
+func portalAuth(allowPINChangeOnly bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role, exists := c.Get("admin_role")
-		if !exists {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		parts := strings.Split(c.GetHeader("Authorization"), " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+			c.Abort()
+			return
+		}
+
+		claims, err := utils.ValidateUserToken(parts[1])
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
-		if role != "admin" && role != "super_admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		if claims.PINChangeOnly && !allowPINChangeOnly {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Change your temporary PIN first", "pin_must_change": true})
 			c.Abort()
 			return
 		}
 
+		c.Set("portal_user_id", claims.UserID)
+		c.Set("portal_login_method", claims.Method)
+
 		c.Next()
 	}
 }
 
-// SuperAdminOnly middleware ensures only super admins can access
-func SuperAdminOnly() gin.HandlerFunc {
+// authenticateAPIKey validates an API key and sets its scopes as the request's permissions
+func authenticateAPIKey(c *gin.Context, plain string) {
+	var key models.APIKey
+	if err := database.DB.Where("key_hash = ?", utils.HashAPIKey(plain)).First(&key).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return
+	}
+
+	now := time.Now()
+	if !key.IsUsable(now) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "API key is revoked or expired"})
+		c.Abort()
+		return
+	}
+
+	ip := c.ClientIP()
+	if !utils.IPAllowed(ip, key.AllowedIPs) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key is not allowed from this address"})
+		c.Abort()
+		return
+	}
+
+	// Track usage without rewriting the row on every request
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > time.Minute || key.LastUsedIP != ip {
+		database.DB.Model(&key).UpdateColumns(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": ip,
+		})
+	}
+
+	c.Set("api_key_id", key.ID)
+	c.Set("api_key_name", key.Name)
+	c.Set("admin_permissions", []string(key.Scopes))
+
+	c.Next()
+}
+
+// RequirePermission middleware ensures the authenticated admin's role grants permission
+func RequirePermission(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role, exists := c.Get("admin_role")
+		perms, exists := c.Get("admin_permissions")
 		if !exists {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 			c.Abort()
 			return
 		}
 
-		if role != "super_admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Super admin access required"})
+		list, ok := perms.([]string)
+		if !ok || !models.HasPermission(list, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Missing permission: " + permission})
 			c.Abort()
 			return
 		}
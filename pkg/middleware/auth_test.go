@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lazypwny751/hudautomata/pkg/config"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/utils"
+	"gorm.io/gorm/logger"
+)
+
+func TestAuthMiddlewareUsesCurrentAdmin(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	database.LogLevel = logger.Silent
+	if err := database.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.SeedData(); err != nil {
+		t.Fatal(err)
+	}
+	config.AppConfig = &config.Config{JWTSecret: "test-secret"}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/admins", AuthMiddleware(), RequirePermission(models.PermAdminsManage), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	admin := models.Admin{Username: "jane", Email: "jane@example.com", Role: models.RoleSuperAdmin, IsActive: true}
+	if err := database.DB.Create(&admin).Error; err != nil {
+		t.Fatal(err)
+	}
+	// Issued while jane was a super admin
+	token, _, err := utils.GenerateToken(admin.ID, admin.Username, string(admin.Role), []string{models.PermAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/admins", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if got := request(); got != http.StatusOK {
+		t.Fatalf("active super admin: got %d", got)
+	}
+
+	database.DB.Model(&admin).Update("role", models.RoleCashier)
+	if got := request(); got != http.StatusForbidden {
+		t.Fatalf("after demotion: got %d, want 403", got)
+	}
+
+	database.DB.Model(&admin).Updates(map[string]interface{}{"role": models.RoleSuperAdmin, "is_active": false})
+	if got := request(); got != http.StatusUnauthorized {
+		t.Fatalf("after deactivation: got %d, want 401", got)
+	}
+
+	database.DB.Model(&admin).Update("is_active", true)
+	database.DB.Delete(&admin)
+	if got := request(); got != http.StatusUnauthorized {
+		t.Fatalf("after deletion: got %d, want 401", got)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/logwriter"
 	"github.com/lazypwny751/hudautomata/pkg/models"
 )
 
@@ -26,6 +27,8 @@ func Logger() gin.HandlerFunc {
 		// Create log entry
 		log := models.SystemLog{
 			Action:    c.Request.Method + " " + c.Request.URL.Path,
+			Category:  models.LogCategoryHTTP,
+			RequestID: c.GetString("request_id"),
 			IPAddress: c.ClientIP(),
 			UserAgent: c.Request.UserAgent(),
 			CreatedAt: time.Now(),
@@ -37,14 +40,21 @@ func Logger() gin.HandlerFunc {
 				log.AdminID = &id
 			}
 		}
+		if apiKeyID, exists := c.Get("api_key_id"); exists {
+			if id, ok := apiKeyID.(uuid.UUID); ok {
+				log.APIKeyID = &id
+			}
+		}
 
 		// Add response details
 		duration := time.Since(start)
 		log.Details = fmt.Sprintf(`{"status":%d,"duration":"%s"}`, c.Writer.Status(), duration.String())
 
-		// Save log to database (non-blocking)
-		go func() {
+		// Queue the log for the batched writer
+		if logwriter.Default != nil {
+			logwriter.Default.Write(log)
+		} else {
 			database.DB.Create(&log)
-		}()
+		}
 	}
 }
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit answers 429 once a client address has made limit requests within
+// window. Counts are kept in memory, per process. A limit of 0 or less lets
+// every request through.
+func RateLimit(limit int, window time.Duration) gin.HandlerFunc {
+	l := newRateLimiter(limit, window)
+
+	return func(c *gin.Context) {
+		if limit > 0 && !l.allow(c.ClientIP(), time.Now()) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, try again later"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// rateLimiter remembers the times of recent requests per key
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu        sync.Mutex
+	hits      map[string][]time.Time
+	lastSweep time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		hits:   make(map[string][]time.Time),
+	}
+}
+
+// allow records a request for key at now unless key is over the limit
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget addresses that have gone quiet, once per window
+	if now.Sub(l.lastSweep) > l.window {
+		for k, times := range l.hits {
+			if len(recent(times, now, l.window)) == 0 {
+				delete(l.hits, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	times := recent(l.hits[key], now, l.window)
+	if len(times) >= l.limit {
+		l.hits[key] = times
+		return false
+	}
+	l.hits[key] = append(times, now)
+	return true
+}
+
+// recent drops the times that are window or more before now
+func recent(times []time.Time, now time.Time, window time.Duration) []time.Time {
+	i := 0
+	for i < len(times) && now.Sub(times[i]) >= window {
+		i++
+	}
+	return times[i:]
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Hour)
+	start := time.Date(2024, 5, 8, 12, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		key  string
+		at   time.Duration
+		want bool
+	}{
+		{"10.0.0.1", 0, true},
+		{"10.0.0.1", time.Minute, true},
+		{"10.0.0.1", 2 * time.Minute, false},
+		{"10.0.0.2", 2 * time.Minute, true},
+		{"10.0.0.1", 59 * time.Minute, false},
+		{"10.0.0.1", time.Hour, true},
+		{"10.0.0.1", time.Hour + time.Second, false},
+		{"10.0.0.1", time.Hour + time.Minute, true},
+	}
+
+	for _, s := range steps {
+		if got := l.allow(s.key, start.Add(s.at)); got != s.want {
+			t.Errorf("%s at +%s: allowed = %v, want %v", s.key, s.at, got, s.want)
+		}
+	}
+
+	l.allow("10.0.0.3", start.Add(3*time.Hour))
+	if _, ok := l.hits["10.0.0.2"]; ok {
+		t.Error("a quiet address was not forgotten")
+	}
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID keeps client supplied IDs short and safe to log
+var validRequestID = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,128}$`)
+
+// RequestID accepts the caller's X-Request-ID or generates one, stores it as
+// request_id in the context and echoes it in the response
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = uuid.New().String()
+		}
+
+		c.Set("request_id", requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		c.Next()
+	}
+}
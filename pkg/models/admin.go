@@ -10,21 +10,34 @@ import (
 type AdminRole string
 
 const (
-	RoleSuperAdmin AdminRole = "super_admin"
-	RoleAdmin      AdminRole = "admin"
+	AuthProviderLocal = "local"
+	AuthProviderOIDC  = "oidc"
+)
+
+const (
+	RoleSuperAdmin    AdminRole = "super_admin"
+	RoleAdmin         AdminRole = "admin"
+	RoleCashier       AdminRole = "cashier"
+	RoleAuditor       AdminRole = "auditor"
+	RoleDeviceManager AdminRole = "device_manager"
 )
 
 type Admin struct {
-	ID           uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
-	Username     string         `json:"username" gorm:"uniqueIndex;not null"`
-	Email        string         `json:"email" gorm:"uniqueIndex;not null"`
-	PasswordHash string         `json:"-" gorm:"not null"`
-	Role         AdminRole      `json:"role" gorm:"default:'admin'"`
-	IsActive     bool           `json:"is_active" gorm:"default:true"`
-	LastLogin    *time.Time     `json:"last_login"`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
+	ID                    uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
+	Username              string         `json:"username" gorm:"uniqueIndex;not null"`
+	Email                 string         `json:"email" gorm:"uniqueIndex;not null"`
+	PasswordHash          string         `json:"-" gorm:"not null"`
+	Role                  AdminRole      `json:"role" gorm:"default:'admin'"`
+	IsActive              bool           `json:"is_active" gorm:"default:true"`
+	AuthProvider          string         `json:"auth_provider" gorm:"default:'local'"`
+	ExternalSubject       *string        `json:"-" gorm:"uniqueIndex"`
+	MaxTransactionAmount  *float64       `json:"max_transaction_amount" gorm:"type:decimal(10,2)"`
+	DailyTransactionLimit *float64       `json:"daily_transaction_limit" gorm:"type:decimal(10,2)"`
+	ApprovalThreshold     *float64       `json:"approval_threshold" gorm:"type:decimal(10,2)"`
+	LastLogin             *time.Time     `json:"last_login"`
+	CreatedAt             time.Time      `json:"created_at"`
+	UpdatedAt             time.Time      `json:"updated_at"`
+	DeletedAt             gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 // BeforeCreate hook to generate UUID
@@ -48,9 +61,10 @@ type LoginRequest struct {
 
 // LoginResponse represents the login response
 type LoginResponse struct {
-	Token     string    `json:"token"`
-	Admin     Admin     `json:"admin"`
-	ExpiresAt time.Time `json:"expires_at"`
+	Token       string    `json:"token"`
+	Admin       Admin     `json:"admin"`
+	Permissions []string  `json:"permissions"`
+	ExpiresAt   time.Time `json:"expires_at"`
 }
 
 // CreateAdminRequest represents the request body for creating an admin
@@ -58,5 +72,16 @@ type CreateAdminRequest struct {
 	Username string    `json:"username" binding:"required,min=3"`
 	Email    string    `json:"email" binding:"required,email"`
 	Password string    `json:"password" binding:"required,min=6"`
-	Role     AdminRole `json:"role" binding:"required,oneof=admin super_admin"`
+	Role     AdminRole `json:"role" binding:"required"`
+}
+
+// UpdateAdminRequest represents the request body for updating an admin.
+// A limit or threshold of 0 removes the override.
+type UpdateAdminRequest struct {
+	Email                 string    `json:"email" binding:"omitempty,email"`
+	Role                  AdminRole `json:"role"`
+	IsActive              *bool     `json:"is_active"`
+	MaxTransactionAmount  *float64  `json:"max_transaction_amount" binding:"omitempty,gte=0"`
+	DailyTransactionLimit *float64  `json:"daily_transaction_limit" binding:"omitempty,gte=0"`
+	ApprovalThreshold     *float64  `json:"approval_threshold" binding:"omitempty,gte=0"`
 }
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"database/sql/driver"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// StringList is a list of strings stored as a comma separated column
+type StringList []string
+
+// Value implements driver.Valuer
+func (s StringList) Value() (driver.Value, error) {
+	return PermissionList(s).Value()
+}
+
+// Scan implements sql.Scanner
+func (s *StringList) Scan(value interface{}) error {
+	return (*PermissionList)(s).Scan(value)
+}
+
+type APIKey struct {
+	ID                    uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
+	Name                  string         `json:"name" gorm:"not null"`
+	Prefix                string         `json:"prefix" gorm:"index;not null"`
+	KeyHash               string         `json:"-" gorm:"uniqueIndex;not null"`
+	Scopes                PermissionList `json:"scopes" gorm:"type:text"`
+	AllowedIPs            StringList     `json:"allowed_ips" gorm:"type:text"`
+	MaxTransactionAmount  *float64       `json:"max_transaction_amount" gorm:"type:decimal(10,2)"`
+	DailyTransactionLimit *float64       `json:"daily_transaction_limit" gorm:"type:decimal(10,2)"`
+	ExpiresAt             *time.Time     `json:"expires_at"`
+	LastUsedAt            *time.Time     `json:"last_used_at"`
+	LastUsedIP            string         `json:"last_used_ip"`
+	CreatedByID           *uuid.UUID     `json:"created_by_id" gorm:"type:uuid"`
+	CreatedBy             *Admin         `json:"created_by,omitempty" gorm:"foreignKey:CreatedByID"`
+	RevokedAt             *time.Time     `json:"revoked_at"`
+	CreatedAt             time.Time      `json:"created_at"`
+	UpdatedAt             time.Time      `json:"updated_at"`
+}
+
+// BeforeCreate hook to generate UUID
+func (k *APIKey) BeforeCreate(tx *gorm.DB) error {
+	if k.ID == uuid.Nil {
+		k.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// IsUsable reports whether the key is neither revoked nor expired
+func (k APIKey) IsUsable(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// CreateAPIKeyRequest represents the request body for creating an API key
+type CreateAPIKeyRequest struct {
+	Name                  string     `json:"name" binding:"required"`
+	Scopes                []string   `json:"scopes" binding:"required,min=1"`
+	AllowedIPs            []string   `json:"allowed_ips"`
+	MaxTransactionAmount  *float64   `json:"max_transaction_amount" binding:"omitempty,gt=0"`
+	DailyTransactionLimit *float64   `json:"daily_transaction_limit" binding:"omitempty,gt=0"`
+	ExpiresAt             *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse returns the plain key, which is only shown once
+type CreateAPIKeyResponse struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"api_key"`
+}
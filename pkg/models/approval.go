@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ApprovalAction string
+
+const (
+	ApprovalRequested ApprovalAction = "requested"
+	ApprovalApproved  ApprovalAction = "approved"
+	ApprovalRejected  ApprovalAction = "rejected"
+)
+
+type TransactionApproval struct {
+	ID            uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
+	TransactionID uuid.UUID      `json:"transaction_id" gorm:"type:uuid;not null;index"`
+	AdminID       *uuid.UUID     `json:"admin_id" gorm:"type:uuid;index"`
+	Admin         *Admin         `json:"admin,omitempty" gorm:"foreignKey:AdminID"`
+	APIKeyID      *uuid.UUID     `json:"api_key_id" gorm:"type:uuid"`
+	Action        ApprovalAction `json:"action" gorm:"not null"`
+	Comment       string         `json:"comment"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+// BeforeCreate hook to generate UUID
+func (a *TransactionApproval) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (TransactionApproval) TableName() string {
+	return "transaction_approvals"
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type CashSessionStatus string
+
+const (
+	CashSessionOpen   CashSessionStatus = "open"
+	CashSessionClosed CashSessionStatus = "closed"
+)
+
+// CashSession is one admin's shift at the cash drawer. Every credit the
+// admin issues while the session is open is recorded against it, so the
+// cash handed in at close can be matched to the credits issued.
+type CashSession struct {
+	ID           uuid.UUID         `json:"id" gorm:"type:uuid;primary_key"`
+	AdminID      uuid.UUID         `json:"admin_id" gorm:"type:uuid;not null;index"`
+	Admin        *Admin            `json:"admin,omitempty" gorm:"foreignKey:AdminID"`
+	Status       CashSessionStatus `json:"status" gorm:"not null;index"`
+	OpeningFloat float64           `json:"opening_float" gorm:"type:decimal(10,2);not null"`
+	OpenNote     string            `json:"open_note,omitempty"`
+	OpenedAt     time.Time         `json:"opened_at" gorm:"not null;index"`
+	// Set when the session is closed
+	CreditCount  int64      `json:"credit_count"`
+	CreditTotal  float64    `json:"credit_total" gorm:"type:decimal(12,2)"`
+	ExpectedCash *float64   `json:"expected_cash" gorm:"type:decimal(12,2)"`
+	CountedCash  *float64   `json:"counted_cash" gorm:"type:decimal(12,2)"`
+	Variance     *float64   `json:"variance" gorm:"type:decimal(12,2)"`
+	CloseNote    string     `json:"close_note,omitempty"`
+	ClosedByID   *uuid.UUID `json:"closed_by_id" gorm:"type:uuid"`
+	ClosedBy     *Admin     `json:"closed_by,omitempty" gorm:"foreignKey:ClosedByID"`
+	ClosedAt     *time.Time `json:"closed_at"`
+}
+
+// BeforeCreate hook to generate UUID
+func (s *CashSession) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (CashSession) TableName() string {
+	return "cash_sessions"
+}
+
+// OpenCashSessionRequest represents the request body for opening a cash session
+type OpenCashSessionRequest struct {
+	OpeningFloat float64 `json:"opening_float" binding:"gte=0"`
+	Note         string  `json:"note"`
+}
+
+// CloseCashSessionRequest represents the request body for closing a cash session
+type CloseCashSessionRequest struct {
+	CountedCash *float64 `json:"counted_cash" binding:"required,gte=0"`
+	Note        string   `json:"note"`
+}
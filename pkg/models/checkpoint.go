@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ChainCheckpoint is a signed snapshot of the head of a hash chain. A later
+// verification fails if the recorded entry was changed or the chain was
+// truncated below it.
+type ChainCheckpoint struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	Chain     string    `json:"chain" gorm:"not null;index"`
+	Seq       int64     `json:"seq" gorm:"not null"`
+	Hash      string    `json:"hash" gorm:"not null"`
+	PublicKey string    `json:"public_key" gorm:"not null"`
+	Signature string    `json:"signature" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// BeforeCreate hook to generate UUID
+func (cp *ChainCheckpoint) BeforeCreate(tx *gorm.DB) error {
+	if cp.ID == uuid.Nil {
+		cp.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (ChainCheckpoint) TableName() string {
+	return "chain_checkpoints"
+}
+
+// ChainPrune records entries removed from a chain by retention. Verification
+// accepts gaps only where signed prune records account for them.
+type ChainPrune struct {
+	ID            uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	Chain         string    `json:"chain" gorm:"not null;index"`
+	Category      string    `json:"category"`
+	Cutoff        time.Time `json:"cutoff"`
+	FirstSeq      int64     `json:"first_seq" gorm:"not null"`
+	LastSeq       int64     `json:"last_seq" gorm:"not null"`
+	LastHash      string    `json:"last_hash" gorm:"not null"`
+	Rows          int64     `json:"rows" gorm:"not null"`
+	Archive       string    `json:"archive"`
+	ArchiveSHA256 string    `json:"archive_sha256"`
+	PublicKey     string    `json:"public_key" gorm:"not null"`
+	Signature     string    `json:"signature" gorm:"not null"`
+	CreatedAt     time.Time `json:"created_at" gorm:"index"`
+}
+
+// BeforeCreate hook to generate UUID
+func (p *ChainPrune) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (ChainPrune) TableName() string {
+	return "chain_prunes"
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DailyCloseout is the signed end-of-day statement (Z-report) of one
+// business day. Once it exists the day is closed: no transaction can be
+// booked into it.
+type DailyCloseout struct {
+	ID                 uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	BusinessDate       string     `json:"business_date" gorm:"uniqueIndex;not null"`
+	Timezone           string     `json:"timezone" gorm:"not null"`
+	PeriodStart        time.Time  `json:"period_start" gorm:"not null;index"`
+	PeriodEnd          time.Time  `json:"period_end" gorm:"not null;index"`
+	OpeningBalance     float64    `json:"opening_balance" gorm:"type:decimal(12,2)"`
+	ClosingBalance     float64    `json:"closing_balance" gorm:"type:decimal(12,2)"`
+	TotalCredits       float64    `json:"total_credits" gorm:"type:decimal(12,2)"`
+	TotalDebits        float64    `json:"total_debits" gorm:"type:decimal(12,2)"`
+	TotalRefunds       float64    `json:"total_refunds" gorm:"type:decimal(12,2)"`
+	TransactionCount   int64      `json:"transaction_count"`
+	TransactionsDigest string     `json:"transactions_digest"`
+	Report             string     `json:"-" gorm:"type:text"`
+	ReportSHA256       string     `json:"report_sha256"`
+	PDF                []byte     `json:"-"`
+	PDFSHA256          string     `json:"pdf_sha256"`
+	ClosedByID         *uuid.UUID `json:"closed_by_id" gorm:"type:uuid"`
+	ClosedBy           *Admin     `json:"closed_by,omitempty" gorm:"foreignKey:ClosedByID"`
+	PublicKey          string     `json:"public_key"`
+	Signature          string     `json:"signature"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+// BeforeCreate hook to generate UUID
+func (d *DailyCloseout) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (DailyCloseout) TableName() string {
+	return "daily_closeouts"
+}
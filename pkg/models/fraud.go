@@ -0,0 +1,117 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Device is a registered automation device. The coordinates let the fraud
+// detector tell how far apart two scans happened.
+type Device struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	DeviceID    string    `json:"device_id" gorm:"uniqueIndex;not null"`
+	Name        string    `json:"name"`
+	Location    string    `json:"location"`
+	Latitude    *float64  `json:"latitude"`
+	Longitude   *float64  `json:"longitude"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// BeforeCreate hook to generate UUID
+func (d *Device) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (Device) TableName() string {
+	return "devices"
+}
+
+// HasPosition reports whether the device's coordinates are known
+func (d Device) HasPosition() bool {
+	return d.Latitude != nil && d.Longitude != nil
+}
+
+// CreateDeviceRequest represents the request body for registering a device
+type CreateDeviceRequest struct {
+	DeviceID    string   `json:"device_id" binding:"required"`
+	Name        string   `json:"name"`
+	Location    string   `json:"location"`
+	Latitude    *float64 `json:"latitude" binding:"omitempty,gte=-90,lte=90"`
+	Longitude   *float64 `json:"longitude" binding:"omitempty,gte=-180,lte=180"`
+	Description string   `json:"description"`
+}
+
+// UpdateDeviceRequest represents the request body for updating a device
+type UpdateDeviceRequest struct {
+	Name        *string  `json:"name"`
+	Location    *string  `json:"location"`
+	Latitude    *float64 `json:"latitude" binding:"omitempty,gte=-90,lte=90"`
+	Longitude   *float64 `json:"longitude" binding:"omitempty,gte=-180,lte=180"`
+	Description *string  `json:"description"`
+}
+
+type FraudRule string
+type AlertStatus string
+
+const (
+	// RuleImpossibleTravel is the same card on two distant devices within minutes
+	RuleImpossibleTravel FraudRule = "impossible_travel"
+	// RuleBurst is a burst of rapid scans of one card
+	RuleBurst FraudRule = "burst"
+	// RuleOffHours is a scan outside opening hours
+	RuleOffHours FraudRule = "off_hours"
+	// RuleLargeSpend is a charge far above the card's usual spending
+	RuleLargeSpend FraudRule = "large_spend"
+
+	AlertOpen      AlertStatus = "open"
+	AlertConfirmed AlertStatus = "confirmed"
+	AlertDismissed AlertStatus = "dismissed"
+)
+
+// FraudAlert is raised when a scan matches a fraud rule
+type FraudAlert struct {
+	ID           uuid.UUID   `json:"id" gorm:"type:uuid;primary_key"`
+	Rule         FraudRule   `json:"rule" gorm:"not null;index"`
+	Status       AlertStatus `json:"status" gorm:"not null;index"`
+	UserID       uuid.UUID   `json:"user_id" gorm:"type:uuid;not null;index"`
+	User         *User       `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	RFIDCardID   string      `json:"rfid_card_id" gorm:"column:rfid_card_id"`
+	DeviceID     string      `json:"device_id"`
+	ScanEventID  *uuid.UUID  `json:"scan_event_id" gorm:"type:uuid"`
+	Message      string      `json:"message"`
+	Details      string      `json:"details" gorm:"type:text"`
+	CardBlocked  bool        `json:"card_blocked"`
+	ReviewedByID *uuid.UUID  `json:"reviewed_by_id" gorm:"type:uuid"`
+	ReviewedBy   *Admin      `json:"reviewed_by,omitempty" gorm:"foreignKey:ReviewedByID"`
+	ReviewedAt   *time.Time  `json:"reviewed_at"`
+	ReviewNote   string      `json:"review_note,omitempty"`
+	CreatedAt    time.Time   `json:"created_at" gorm:"index"`
+}
+
+// BeforeCreate hook to generate UUID
+func (a *FraudAlert) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (FraudAlert) TableName() string {
+	return "fraud_alerts"
+}
+
+// ReviewAlertRequest represents the request body for reviewing an alert.
+// Confirming blocks the card; dismissing unblocks it if the alert blocked it.
+type ReviewAlertRequest struct {
+	Status AlertStatus `json:"status" binding:"required,oneof=confirmed dismissed"`
+	Note   string      `json:"note"`
+}
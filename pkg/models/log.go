@@ -7,17 +7,28 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	LogCategoryHTTP  = "http"
+	LogCategoryAudit = "audit"
+)
+
 type SystemLog struct {
-	ID         uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
 	AdminID    *uuid.UUID `json:"admin_id" gorm:"type:uuid;index"`
-	Admin      *Admin    `json:"admin,omitempty" gorm:"foreignKey:AdminID"`
-	Action     string    `json:"action" gorm:"not null;index"`
-	Resource   string    `json:"resource"`
-	ResourceID string    `json:"resource_id"`
-	Details    string    `json:"details" gorm:"type:text"`
-	IPAddress  string    `json:"ip_address"`
-	UserAgent  string    `json:"user_agent"`
-	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+	Admin      *Admin     `json:"admin,omitempty" gorm:"foreignKey:AdminID"`
+	APIKeyID   *uuid.UUID `json:"api_key_id" gorm:"type:uuid;index"`
+	Category   string     `json:"category" gorm:"index;default:'http'"`
+	RequestID  string     `json:"request_id,omitempty" gorm:"index"`
+	Action     string     `json:"action" gorm:"not null;index"`
+	Resource   string     `json:"resource" gorm:"index"`
+	ResourceID string     `json:"resource_id" gorm:"index"`
+	Details    string     `json:"details" gorm:"type:text"`
+	IPAddress  string     `json:"ip_address"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"index"`
+	Seq        int64      `json:"seq" gorm:"index"`
+	PrevHash   string     `json:"prev_hash,omitempty"`
+	Hash       string     `json:"hash,omitempty" gorm:"index"`
 }
 
 // BeforeCreate hook to generate UUID
@@ -32,3 +43,14 @@ func (l *SystemLog) BeforeCreate(tx *gorm.DB) error {
 func (SystemLog) TableName() string {
 	return "system_logs"
 }
+
+// RestoredLog is a system log restored from an archive for an investigation.
+// It lives in its own table and is not part of the hash chain.
+type RestoredLog struct {
+	SystemLog
+}
+
+// TableName specifies the table name
+func (RestoredLog) TableName() string {
+	return "restored_logs"
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PortalLoginToken is a single use e-mail login link for the user portal.
+// Only the hash of the token is stored.
+type PortalLoginToken struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	IPAddress string     `json:"ip_address"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// BeforeCreate hook to generate UUID
+func (t *PortalLoginToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (PortalLoginToken) TableName() string {
+	return "portal_login_tokens"
+}
+
+// PortalLoginRequest represents the request body for logging in to the
+// portal with a card and PIN
+type PortalLoginRequest struct {
+	RFIDCardID string `json:"rfid_card_id" binding:"required"`
+	PIN        string `json:"pin" binding:"required"`
+}
+
+// MagicLinkRequest represents the request body for e-mailing a login link
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// MagicLinkLoginRequest represents the request body for logging in with
+// the token from a login link
+type MagicLinkLoginRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// PortalLoginResponse represents the response of a successful portal login
+type PortalLoginResponse struct {
+	Token     string    `json:"token"`
+	User      User      `json:"user"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ChangePINRequest represents the request body for a user changing their
+// own PIN; the current PIN is needed once one is set
+type ChangePINRequest struct {
+	CurrentPIN string `json:"current_pin"`
+	NewPIN     string `json:"new_pin" binding:"required"`
+}
+
+// BlockCardRequest represents the request body for blocking a card
+type BlockCardRequest struct {
+	Reason string `json:"reason"`
+}
+
+// PortalCard is a card as shown to its holder
+type PortalCard struct {
+	RFIDCardID  string     `json:"rfid_card_id"`
+	Blocked     bool       `json:"blocked"`
+	BlockedAt   *time.Time `json:"blocked_at,omitempty"`
+	BlockReason string     `json:"block_reason,omitempty"`
+}
@@ -0,0 +1,218 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	PermAll = "*"
+
+	PermUsersRead           = "users:read"
+	PermUsersWrite          = "users:write"
+	PermUsersDelete         = "users:delete"
+	PermTransactionsRead    = "transactions:read"
+	PermTransactionsCreate  = "transactions:create"
+	PermTransactionsDebit   = "transactions:debit"
+	PermTransactionsRefund  = "transactions:refund"
+	PermTransactionsApprove = "transactions:approve"
+	PermDashboardRead       = "dashboard:read"
+	PermReportsManage       = "reports:manage"
+	PermCashManage          = "cash:manage"
+	PermLogsRead            = "logs:read"
+	PermAutomationRead      = "automation:read"
+	PermDevicesManage       = "devices:manage"
+	PermAlertsManage        = "alerts:manage"
+	PermAdminsRead          = "admins:read"
+	PermAdminsManage        = "admins:manage"
+	PermRolesRead           = "roles:read"
+	PermRolesManage         = "roles:manage"
+	PermAPIKeysManage       = "api_keys:manage"
+)
+
+// AllPermissions lists every permission that can be assigned to a role
+var AllPermissions = []string{
+	PermUsersRead,
+	PermUsersWrite,
+	PermUsersDelete,
+	PermTransactionsRead,
+	PermTransactionsCreate,
+	PermTransactionsDebit,
+	PermTransactionsRefund,
+	PermTransactionsApprove,
+	PermDashboardRead,
+	PermReportsManage,
+	PermCashManage,
+	PermLogsRead,
+	PermAutomationRead,
+	PermDevicesManage,
+	PermAlertsManage,
+	PermAdminsRead,
+	PermAdminsManage,
+	PermRolesRead,
+	PermRolesManage,
+	PermAPIKeysManage,
+}
+
+// ForbiddenAPIKeyScopes are permissions that only a human admin may hold
+var ForbiddenAPIKeyScopes = []string{
+	PermAll,
+	PermTransactionsApprove,
+	PermAdminsManage,
+	PermRolesManage,
+	PermAPIKeysManage,
+}
+
+// PermissionList is a set of permissions stored as a comma separated column
+type PermissionList []string
+
+// Value implements driver.Valuer
+func (p PermissionList) Value() (driver.Value, error) {
+	return strings.Join(p, ","), nil
+}
+
+// Scan implements sql.Scanner
+func (p *PermissionList) Scan(value interface{}) error {
+	var raw string
+	switch v := value.(type) {
+	case nil:
+		*p = PermissionList{}
+		return nil
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into PermissionList", value)
+	}
+
+	*p = PermissionList{}
+	for _, perm := range strings.Split(raw, ",") {
+		if perm = strings.TrimSpace(perm); perm != "" {
+			*p = append(*p, perm)
+		}
+	}
+	return nil
+}
+
+// HasPermission reports whether perms grants the given permission
+func HasPermission(perms []string, permission string) bool {
+	for _, p := range perms {
+		if p == PermAll || p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidPermission reports whether permission is known
+func IsValidPermission(permission string) bool {
+	return permission == PermAll || HasPermission(AllPermissions, permission)
+}
+
+type Role struct {
+	ID                    uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
+	Name                  AdminRole      `json:"name" gorm:"uniqueIndex;not null"`
+	Description           string         `json:"description"`
+	Permissions           PermissionList `json:"permissions" gorm:"type:text"`
+	MaxTransactionAmount  *float64       `json:"max_transaction_amount" gorm:"type:decimal(10,2)"`
+	DailyTransactionLimit *float64       `json:"daily_transaction_limit" gorm:"type:decimal(10,2)"`
+	ApprovalThreshold     *float64       `json:"approval_threshold" gorm:"type:decimal(10,2)"`
+	BuiltIn               bool           `json:"built_in" gorm:"default:false"`
+	CreatedAt             time.Time      `json:"created_at"`
+	UpdatedAt             time.Time      `json:"updated_at"`
+}
+
+// BeforeCreate hook to generate UUID
+func (r *Role) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (Role) TableName() string {
+	return "roles"
+}
+
+// Can reports whether the role grants the given permission
+func (r Role) Can(permission string) bool {
+	return HasPermission(r.Permissions, permission)
+}
+
+// DefaultRoles returns the built-in roles seeded on first start
+func DefaultRoles() []Role {
+	cashierLimit := 1000.0
+
+	return []Role{
+		{
+			Name:        RoleSuperAdmin,
+			Description: "Full access including admin and role management",
+			Permissions: PermissionList{PermAll},
+			BuiltIn:     true,
+		},
+		{
+			Name:        RoleAdmin,
+			Description: "Manages users, balances and automation",
+			Permissions: PermissionList{
+				PermUsersRead, PermUsersWrite, PermUsersDelete,
+				PermTransactionsRead, PermTransactionsCreate, PermTransactionsDebit, PermTransactionsRefund,
+				PermTransactionsApprove, PermDashboardRead, PermReportsManage, PermCashManage, PermLogsRead, PermAutomationRead,
+				PermDevicesManage, PermAlertsManage,
+			},
+			BuiltIn: true,
+		},
+		{
+			Name:        RoleCashier,
+			Description: "Loads credit onto cards up to a per-transaction limit",
+			Permissions: PermissionList{
+				PermUsersRead, PermTransactionsRead, PermTransactionsCreate,
+			},
+			MaxTransactionAmount: &cashierLimit,
+			BuiltIn:              true,
+		},
+		{
+			Name:        RoleAuditor,
+			Description: "Read-only access including system logs",
+			Permissions: PermissionList{
+				PermUsersRead, PermTransactionsRead, PermDashboardRead, PermLogsRead,
+				PermAutomationRead, PermAdminsRead, PermRolesRead,
+			},
+			BuiltIn: true,
+		},
+		{
+			Name:        RoleDeviceManager,
+			Description: "Monitors automation devices and card lookups",
+			Permissions: PermissionList{
+				PermUsersRead, PermDashboardRead, PermAutomationRead, PermDevicesManage,
+			},
+			BuiltIn: true,
+		},
+	}
+}
+
+// CreateRoleRequest represents the request body for creating a role
+type CreateRoleRequest struct {
+	Name                  AdminRole `json:"name" binding:"required,min=3"`
+	Description           string    `json:"description"`
+	Permissions           []string  `json:"permissions" binding:"required"`
+	MaxTransactionAmount  *float64  `json:"max_transaction_amount" binding:"omitempty,gt=0"`
+	DailyTransactionLimit *float64  `json:"daily_transaction_limit" binding:"omitempty,gt=0"`
+	ApprovalThreshold     *float64  `json:"approval_threshold" binding:"omitempty,gt=0"`
+}
+
+// UpdateRoleRequest represents the request body for updating a role.
+// A limit or threshold of 0 removes it.
+type UpdateRoleRequest struct {
+	Description           *string  `json:"description"`
+	Permissions           []string `json:"permissions"`
+	MaxTransactionAmount  *float64 `json:"max_transaction_amount" binding:"omitempty,gte=0"`
+	DailyTransactionLimit *float64 `json:"daily_transaction_limit" binding:"omitempty,gte=0"`
+	ApprovalThreshold     *float64 `json:"approval_threshold" binding:"omitempty,gte=0"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type RollupGranularity string
+
+const (
+	RollupHour RollupGranularity = "hour"
+	RollupDay  RollupGranularity = "day"
+)
+
+// TransactionRollup is the count and sum of the completed transactions of
+// one bucket sharing user, device, service, type and source. Hourly rollups
+// are UTC hours; daily rollups are days of the time zone in Timezone.
+type TransactionRollup struct {
+	ID          uuid.UUID         `json:"id" gorm:"type:uuid;primary_key"`
+	Granularity RollupGranularity `json:"granularity" gorm:"not null;uniqueIndex:idx_rollup_key"`
+	Timezone    string            `json:"timezone" gorm:"not null;uniqueIndex:idx_rollup_key"`
+	BucketStart time.Time         `json:"bucket_start" gorm:"not null;uniqueIndex:idx_rollup_key"`
+	UserID      uuid.UUID         `json:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_rollup_key;index"`
+	DeviceID    string            `json:"device_id" gorm:"not null;uniqueIndex:idx_rollup_key"`
+	Service     string            `json:"service" gorm:"not null;uniqueIndex:idx_rollup_key"`
+	Type        TransactionType   `json:"type" gorm:"not null;uniqueIndex:idx_rollup_key"`
+	Source      TransactionSource `json:"source" gorm:"not null;uniqueIndex:idx_rollup_key"`
+	Count       int64             `json:"count" gorm:"not null"`
+	Amount      float64           `json:"amount" gorm:"type:decimal(12,2);not null"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// BeforeCreate hook to generate UUID
+func (r *TransactionRollup) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (TransactionRollup) TableName() string {
+	return "transaction_rollups"
+}
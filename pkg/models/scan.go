@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ScanOutcome string
+
+const (
+	ScanApproved            ScanOutcome = "approved"
+	ScanUnknownCard         ScanOutcome = "unknown_card"
+	ScanInactiveUser        ScanOutcome = "inactive_user"
+	ScanCardBlocked         ScanOutcome = "card_blocked"
+	ScanCardLocked          ScanOutcome = "card_locked"
+	ScanPINRequired         ScanOutcome = "pin_required"
+	ScanPINInvalid          ScanOutcome = "pin_invalid"
+	ScanDailyLimit          ScanOutcome = "daily_limit_exceeded"
+	ScanWeeklyLimit         ScanOutcome = "weekly_limit_exceeded"
+	ScanRateLimit           ScanOutcome = "scan_rate_exceeded"
+	ScanInsufficientBalance ScanOutcome = "insufficient_balance"
+	ScanInvalidRequest      ScanOutcome = "invalid_request"
+	ScanError               ScanOutcome = "error"
+)
+
+// ScanEvent records every card scan from an automation device, whether or
+// not it was approved
+type ScanEvent struct {
+	ID            uuid.UUID   `json:"id" gorm:"type:uuid;primary_key"`
+	RFIDCardID    string      `json:"rfid_card_id" gorm:"index"`
+	UserID        *uuid.UUID  `json:"user_id" gorm:"type:uuid;index"`
+	User          *User       `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	DeviceID      string      `json:"device_id" gorm:"index"`
+	Service       string      `json:"service"`
+	Outcome       ScanOutcome `json:"outcome" gorm:"not null;index"`
+	ServiceCost   float64     `json:"service_cost" gorm:"type:decimal(10,2)"`
+	Balance       *float64    `json:"balance" gorm:"type:decimal(10,2)"`
+	TransactionID *uuid.UUID  `json:"transaction_id" gorm:"type:uuid"`
+	LatencyMs     int64       `json:"latency_ms"`
+	RequestID     string      `json:"request_id,omitempty" gorm:"index"`
+	IPAddress     string      `json:"ip_address"`
+	CreatedAt     time.Time   `json:"created_at" gorm:"index"`
+}
+
+// BeforeCreate hook to generate UUID
+func (e *ScanEvent) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (ScanEvent) TableName() string {
+	return "scan_events"
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SettlementMethod string
+
+const (
+	SettleCash     SettlementMethod = "cash"
+	SettleTransfer SettlementMethod = "transfer"
+	SettlePayroll  SettlementMethod = "payroll"
+)
+
+type SettlementStatus string
+
+const (
+	SettlementPending   SettlementStatus = "pending"
+	SettlementCompleted SettlementStatus = "completed"
+	SettlementVoid      SettlementStatus = "void"
+)
+
+// Settlement records a repayment of a user's debt. The repayment is booked
+// as a credit transaction; while that credit awaits approval the settlement
+// is pending, and it is voided if the credit is rejected.
+type Settlement struct {
+	ID            uuid.UUID        `json:"id" gorm:"type:uuid;primary_key"`
+	UserID        uuid.UUID        `json:"user_id" gorm:"type:uuid;not null;index"`
+	User          *User            `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	TransactionID uuid.UUID        `json:"transaction_id" gorm:"type:uuid;not null"`
+	Transaction   *Transaction     `json:"transaction,omitempty" gorm:"foreignKey:TransactionID"`
+	Amount        float64          `json:"amount" gorm:"type:decimal(10,2);not null"`
+	DebtBefore    float64          `json:"debt_before" gorm:"type:decimal(10,2);not null"`
+	DebtAfter     float64          `json:"debt_after" gorm:"type:decimal(10,2);not null"`
+	Method        SettlementMethod `json:"method" gorm:"not null"`
+	Status        SettlementStatus `json:"status" gorm:"default:'completed';index"`
+	Reference     string           `json:"reference,omitempty"`
+	Note          string           `json:"note,omitempty"`
+	AdminID       *uuid.UUID       `json:"admin_id" gorm:"type:uuid"`
+	Admin         *Admin           `json:"admin,omitempty" gorm:"foreignKey:AdminID"`
+	CreatedAt     time.Time        `json:"created_at" gorm:"index"`
+}
+
+// BeforeCreate hook to generate UUID
+func (s *Settlement) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (Settlement) TableName() string {
+	return "settlements"
+}
+
+// SettleDebtRequest represents the request body for settling a user's debt.
+// Amount defaults to the whole debt.
+type SettleDebtRequest struct {
+	Amount    *float64         `json:"amount" binding:"omitempty,gt=0"`
+	Method    SettlementMethod `json:"method" binding:"required,oneof=cash transfer payroll"`
+	Reference string           `json:"reference"`
+	Note      string           `json:"note"`
+}
+
+// DebtorInfo is a user in debt as listed in the debt report
+type DebtorInfo struct {
+	UserID      uuid.UUID `json:"user_id"`
+	RFIDCardID  string    `json:"rfid_card_id"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email"`
+	Balance     float64   `json:"balance"`
+	Debt        float64   `json:"debt"`
+	CreditLimit *float64  `json:"credit_limit"`
+	Available   float64   `json:"available"`
+	// OverLimit is set when the debt exceeds the credit limit, e.g. after
+	// the limit was lowered
+	OverLimit     bool       `json:"over_limit"`
+	LastSettledAt *time.Time `json:"last_settled_at"`
+}
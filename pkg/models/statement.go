@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Statement is a user's stored monthly account statement with its PDF and
+// CSV documents
+type Statement struct {
+	ID               uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	UserID           uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_statement_user_period"`
+	User             *User      `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Period           string     `json:"period" gorm:"not null;uniqueIndex:idx_statement_user_period;index"`
+	Timezone         string     `json:"timezone" gorm:"not null"`
+	PeriodStart      time.Time  `json:"period_start" gorm:"not null"`
+	PeriodEnd        time.Time  `json:"period_end" gorm:"not null"`
+	OpeningBalance   float64    `json:"opening_balance" gorm:"type:decimal(12,2)"`
+	ClosingBalance   float64    `json:"closing_balance" gorm:"type:decimal(12,2)"`
+	TotalCredits     float64    `json:"total_credits" gorm:"type:decimal(12,2)"`
+	TotalDebits      float64    `json:"total_debits" gorm:"type:decimal(12,2)"`
+	TotalRefunds     float64    `json:"total_refunds" gorm:"type:decimal(12,2)"`
+	TransactionCount int64      `json:"transaction_count"`
+	PDF              []byte     `json:"-"`
+	CSV              []byte     `json:"-"`
+	EmailedTo        string     `json:"emailed_to,omitempty"`
+	EmailedAt        *time.Time `json:"emailed_at"`
+	EmailError       string     `json:"email_error,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// BeforeCreate hook to generate UUID
+func (s *Statement) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (Statement) TableName() string {
+	return "statements"
+}
+
+// RunStatementsRequest represents the request body for generating the
+// statements of all active users
+type RunStatementsRequest struct {
+	Period string `json:"period"`
+	Email  bool   `json:"email"`
+}
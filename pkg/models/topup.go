@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type TopUpStatus string
+type TopUpOrigin string
+
+const (
+	TopUpPending  TopUpStatus = "pending"
+	TopUpApproved TopUpStatus = "approved"
+	TopUpRejected TopUpStatus = "rejected"
+
+	// TopUpFromScan is filed by a device when a scan lacked balance
+	TopUpFromScan TopUpOrigin = "scan"
+	// TopUpFromKiosk is filed by the card holder at a kiosk
+	TopUpFromKiosk TopUpOrigin = "kiosk"
+)
+
+// TopUpRequest is a card holder asking the admins for balance. A user has
+// at most one pending request; approving it books a credit. A request whose
+// credit is held for approval stays pending with its TransactionID set.
+type TopUpRequest struct {
+	ID            uuid.UUID    `json:"id" gorm:"type:uuid;primary_key"`
+	UserID        uuid.UUID    `json:"user_id" gorm:"type:uuid;not null;index"`
+	User          *User        `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Amount        float64      `json:"amount" gorm:"type:decimal(10,2);not null"`
+	Status        TopUpStatus  `json:"status" gorm:"not null;index"`
+	Origin        TopUpOrigin  `json:"origin" gorm:"not null"`
+	DeviceID      string       `json:"device_id,omitempty"`
+	Service       string       `json:"service,omitempty"`
+	Note          string       `json:"note,omitempty"`
+	TransactionID *uuid.UUID   `json:"transaction_id" gorm:"type:uuid"`
+	Transaction   *Transaction `json:"transaction,omitempty" gorm:"foreignKey:TransactionID"`
+	ResolvedByID  *uuid.UUID   `json:"resolved_by_id" gorm:"type:uuid"`
+	ResolvedBy    *Admin       `json:"resolved_by,omitempty" gorm:"foreignKey:ResolvedByID"`
+	ResolvedAt    *time.Time   `json:"resolved_at"`
+	Reason        string       `json:"reason,omitempty"`
+	CreatedAt     time.Time    `json:"created_at" gorm:"index"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+}
+
+// BeforeCreate hook to generate UUID
+func (t *TopUpRequest) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (TopUpRequest) TableName() string {
+	return "topup_requests"
+}
+
+// TopUpStatusInfo is what a device shows about a card's latest top-up request
+type TopUpStatusInfo struct {
+	ID     uuid.UUID   `json:"id"`
+	Status TopUpStatus `json:"status"`
+	Amount float64     `json:"amount"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// FileTopUpRequest represents the request body for filing a top-up request at a kiosk
+type FileTopUpRequest struct {
+	RFIDCardID string  `json:"rfid_card_id" binding:"required"`
+	Amount     float64 `json:"amount" binding:"required,gt=0"`
+	Note       string  `json:"note"`
+	DeviceID   string  `json:"device_id"`
+}
+
+// ApproveTopUpRequest represents the request body for approving a top-up
+// request; the amount defaults to the requested one
+type ApproveTopUpRequest struct {
+	Amount  *float64 `json:"amount" binding:"omitempty,gt=0"`
+	Comment string   `json:"comment"`
+}
+
+// RejectTopUpRequest represents the request body for rejecting a top-up request
+type RejectTopUpRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
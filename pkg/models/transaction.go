@@ -9,6 +9,7 @@ import (
 
 type TransactionType string
 type TransactionSource string
+type TransactionStatus string
 
 const (
 	TypeCredit TransactionType = "credit"
@@ -18,21 +19,40 @@ const (
 	SourceAdmin      TransactionSource = "admin"
 	SourceAutomation TransactionSource = "automation"
 	SourceSystem     TransactionSource = "system"
+	SourceAPI        TransactionSource = "api"
+
+	StatusCompleted TransactionStatus = "completed"
+	StatusPending   TransactionStatus = "pending"
+	StatusRejected  TransactionStatus = "rejected"
 )
 
 type Transaction struct {
-	ID            uuid.UUID         `json:"id" gorm:"type:uuid;primary_key"`
-	UserID        uuid.UUID         `json:"user_id" gorm:"type:uuid;not null;index"`
-	User          User              `json:"user,omitempty" gorm:"foreignKey:UserID"`
-	AdminID       *uuid.UUID        `json:"admin_id" gorm:"type:uuid;index"`
-	Admin         *Admin            `json:"admin,omitempty" gorm:"foreignKey:AdminID"`
-	Type          TransactionType   `json:"type" gorm:"not null"`
-	Amount        float64           `json:"amount" gorm:"type:decimal(10,2);not null"`
-	BalanceBefore float64           `json:"balance_before" gorm:"type:decimal(10,2);not null"`
-	BalanceAfter  float64           `json:"balance_after" gorm:"type:decimal(10,2);not null"`
-	Description   string            `json:"description"`
-	Source        TransactionSource `json:"source" gorm:"default:'admin'"`
-	CreatedAt     time.Time         `json:"created_at" gorm:"index"`
+	ID            uuid.UUID             `json:"id" gorm:"type:uuid;primary_key"`
+	UserID        uuid.UUID             `json:"user_id" gorm:"type:uuid;not null;index"`
+	User          User                  `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	AdminID       *uuid.UUID            `json:"admin_id" gorm:"type:uuid;index"`
+	Admin         *Admin                `json:"admin,omitempty" gorm:"foreignKey:AdminID"`
+	APIKeyID      *uuid.UUID            `json:"api_key_id" gorm:"type:uuid;index"`
+	APIKey        *APIKey               `json:"api_key,omitempty" gorm:"foreignKey:APIKeyID"`
+	Type          TransactionType       `json:"type" gorm:"not null"`
+	Amount        float64               `json:"amount" gorm:"type:decimal(10,2);not null"`
+	BalanceBefore float64               `json:"balance_before" gorm:"type:decimal(10,2);not null"`
+	BalanceAfter  float64               `json:"balance_after" gorm:"type:decimal(10,2);not null"`
+	Description   string                `json:"description"`
+	Source        TransactionSource     `json:"source" gorm:"default:'admin'"`
+	DeviceID      string                `json:"device_id,omitempty" gorm:"index"`
+	Service       string                `json:"service,omitempty"`
+	CashSessionID *uuid.UUID            `json:"cash_session_id,omitempty" gorm:"type:uuid;index"`
+	Status        TransactionStatus     `json:"status" gorm:"default:'completed';index"`
+	RequestID     string                `json:"request_id,omitempty" gorm:"index"`
+	ResolvedByID  *uuid.UUID            `json:"resolved_by_id" gorm:"type:uuid"`
+	ResolvedBy    *Admin                `json:"resolved_by,omitempty" gorm:"foreignKey:ResolvedByID"`
+	ResolvedAt    *time.Time            `json:"resolved_at"`
+	Approvals     []TransactionApproval `json:"approvals,omitempty" gorm:"foreignKey:TransactionID"`
+	CreatedAt     time.Time             `json:"created_at" gorm:"index"`
+	Seq           int64                 `json:"seq" gorm:"index"`
+	PrevHash      string                `json:"prev_hash,omitempty"`
+	Hash          string                `json:"hash,omitempty" gorm:"index"`
 }
 
 // BeforeCreate hook to generate UUID
@@ -48,12 +68,34 @@ func (Transaction) TableName() string {
 	return "transactions"
 }
 
+// IsIncoming reports whether the transaction adds to the user's balance
+func (t Transaction) IsIncoming() bool {
+	return t.Type == TypeCredit || t.Type == TypeRefund
+}
+
+// BookedAt returns when the transaction was booked: when it was resolved if
+// it needed approval, otherwise when it was created
+func (t Transaction) BookedAt() time.Time {
+	if t.ResolvedAt != nil {
+		return *t.ResolvedAt
+	}
+	return t.CreatedAt
+}
+
 // CreateTransactionRequest represents the request body for creating a transaction
 type CreateTransactionRequest struct {
 	UserID      uuid.UUID       `json:"user_id" binding:"required"`
 	Type        TransactionType `json:"type" binding:"required,oneof=credit debit refund"`
 	Amount      float64         `json:"amount" binding:"required,gt=0"`
 	Description string          `json:"description"`
+	// NonCash keeps a credit out of the admin's cash session. Set by
+	// handlers for money that does not pass the till, never bound.
+	NonCash bool `json:"-"`
+}
+
+// ResolveTransactionRequest represents the request body for approving or rejecting a pending transaction
+type ResolveTransactionRequest struct {
+	Comment string `json:"comment"`
 }
 
 // AutomationScanRequest represents RFID scan request from automation device
@@ -61,18 +103,33 @@ type AutomationScanRequest struct {
 	RFIDCardID  string  `json:"rfid_card_id" binding:"required"`
 	ServiceCost float64 `json:"service_cost" binding:"required,gt=0"`
 	Description string  `json:"description"`
+	DeviceID    string  `json:"device_id"`
+	Service     string  `json:"service"`
+	// RequestTopUp files a top-up request when the balance is insufficient,
+	// for TopUpAmount or else the deficit
+	RequestTopUp bool    `json:"request_topup"`
+	TopUpAmount  float64 `json:"topup_amount" binding:"omitempty,gt=0"`
+	// PIN is needed for configured services and amounts when the user has one
+	PIN string `json:"pin"`
 }
 
 // AutomationScanResponse represents the response for automation scan
 type AutomationScanResponse struct {
-	Success       bool      `json:"success"`
-	UserID        uuid.UUID `json:"user_id,omitempty"`
-	UserName      string    `json:"user_name,omitempty"`
-	BalanceBefore float64   `json:"balance_before,omitempty"`
-	BalanceAfter  float64   `json:"balance_after,omitempty"`
-	TransactionID uuid.UUID `json:"transaction_id,omitempty"`
-	CurrentBalance float64  `json:"current_balance,omitempty"`
-	RequiredAmount float64  `json:"required_amount,omitempty"`
-	Deficit       float64   `json:"deficit,omitempty"`
-	Message       string    `json:"message"`
+	Success        bool             `json:"success"`
+	Code           ScanOutcome      `json:"code"`
+	UserID         uuid.UUID        `json:"user_id,omitempty"`
+	UserName       string           `json:"user_name,omitempty"`
+	BalanceBefore  float64          `json:"balance_before,omitempty"`
+	BalanceAfter   float64          `json:"balance_after,omitempty"`
+	TransactionID  uuid.UUID        `json:"transaction_id,omitempty"`
+	CurrentBalance float64          `json:"current_balance,omitempty"`
+	RequiredAmount float64          `json:"required_amount,omitempty"`
+	Deficit        float64          `json:"deficit,omitempty"`
+	TopUpRequest   *TopUpStatusInfo `json:"topup_request,omitempty"`
+	LimitRemaining *float64         `json:"limit_remaining,omitempty"`
+	AttemptsLeft   *int             `json:"attempts_left,omitempty"`
+	LockedUntil    *time.Time       `json:"locked_until,omitempty"`
+	CreditLimit    *float64         `json:"credit_limit,omitempty"`
+	Available      *float64         `json:"available,omitempty"`
+	Message        string           `json:"message"`
 }
@@ -8,16 +8,35 @@ import (
 )
 
 type User struct {
-	ID         uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
-	RFIDCardID string         `json:"rfid_card_id" gorm:"column:rfid_card_id;uniqueIndex;not null"`
-	Name       string         `json:"name" gorm:"not null"`
-	Email      string         `json:"email" gorm:"index"`
-	Phone      string         `json:"phone"`
-	Balance    float64        `json:"balance" gorm:"type:decimal(10,2);default:0"`
-	IsActive   bool           `json:"is_active" gorm:"default:true"`
-	CreatedAt  time.Time      `json:"created_at"`
-	UpdatedAt  time.Time      `json:"updated_at"`
-	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`
+	ID         uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	RFIDCardID string    `json:"rfid_card_id" gorm:"column:rfid_card_id;uniqueIndex;not null"`
+	Name       string    `json:"name" gorm:"not null"`
+	Email      string    `json:"email" gorm:"index"`
+	Phone      string    `json:"phone"`
+	Balance    float64   `json:"balance" gorm:"type:decimal(10,2);default:0"`
+	IsActive   bool      `json:"is_active" gorm:"default:true"`
+	// PIN for the self-service portal and configured scans, bcrypt hashed
+	PINHash           string     `json:"-"`
+	PINSetAt          *time.Time `json:"pin_set_at"`
+	PINFailedAttempts int        `json:"pin_failed_attempts" gorm:"default:0"`
+	PINLockedUntil    *time.Time `json:"pin_locked_until"`
+	// PINMustChange is set for a temporary PIN from an admin reset
+	PINMustChange bool `json:"pin_must_change" gorm:"default:false"`
+	// A blocked card is refused by devices until an admin unblocks it
+	CardBlocked     bool       `json:"card_blocked" gorm:"default:false"`
+	CardBlockedAt   *time.Time `json:"card_blocked_at"`
+	CardBlockReason string     `json:"card_block_reason,omitempty"`
+	// Spending limits; unset ones fall back to the group's. CreditLimit lets
+	// the balance go down to -CreditLimit.
+	GroupID          *uuid.UUID     `json:"group_id" gorm:"type:uuid;index"`
+	Group            *UserGroup     `json:"group,omitempty" gorm:"foreignKey:GroupID"`
+	DailySpendLimit  *float64       `json:"daily_spend_limit" gorm:"type:decimal(10,2)"`
+	WeeklySpendLimit *float64       `json:"weekly_spend_limit" gorm:"type:decimal(10,2)"`
+	HourlyScanLimit  *int           `json:"hourly_scan_limit"`
+	CreditLimit      *float64       `json:"credit_limit" gorm:"type:decimal(10,2)"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 // BeforeCreate hook to generate UUID
@@ -33,19 +52,62 @@ func (User) TableName() string {
 	return "users"
 }
 
+// Overdraft returns how far below zero the balance may go
+func (u User) Overdraft() float64 {
+	if u.CreditLimit == nil {
+		return 0
+	}
+	return *u.CreditLimit
+}
+
+// Available returns what the user can spend, the balance plus the credit limit
+func (u User) Available() float64 {
+	return u.Balance + u.Overdraft()
+}
+
+// Debt returns how far the balance is below zero
+func (u User) Debt() float64 {
+	if u.Balance >= 0 {
+		return 0
+	}
+	return -u.Balance
+}
+
 // CreateUserRequest represents the request body for creating a user
 type CreateUserRequest struct {
-	RFIDCardID string  `json:"rfid_card_id" binding:"required"`
-	Name       string  `json:"name" binding:"required"`
-	Email      string  `json:"email" binding:"omitempty,email"`
-	Phone      string  `json:"phone"`
-	Balance    float64 `json:"balance"`
+	RFIDCardID string     `json:"rfid_card_id" binding:"required"`
+	Name       string     `json:"name" binding:"required"`
+	Email      string     `json:"email" binding:"omitempty,email"`
+	Phone      string     `json:"phone"`
+	Balance    float64    `json:"balance"`
+	GroupID    *uuid.UUID `json:"group_id"`
+	// Spending limits
+	DailySpendLimit  *float64 `json:"daily_spend_limit" binding:"omitempty,gt=0"`
+	WeeklySpendLimit *float64 `json:"weekly_spend_limit" binding:"omitempty,gt=0"`
+	HourlyScanLimit  *int     `json:"hourly_scan_limit" binding:"omitempty,gt=0"`
+	// CreditLimit lets the balance go negative down to -CreditLimit
+	CreditLimit *float64 `json:"credit_limit" binding:"omitempty,gt=0"`
 }
 
 // UpdateUserRequest represents the request body for updating a user
 type UpdateUserRequest struct {
-	Name     string  `json:"name"`
-	Email    string  `json:"email" binding:"omitempty,email"`
-	Phone    string  `json:"phone"`
-	IsActive *bool   `json:"is_active"`
+	Name     string `json:"name"`
+	Email    string `json:"email" binding:"omitempty,email"`
+	Phone    string `json:"phone"`
+	IsActive *bool  `json:"is_active"`
+	// CardBlocked blocks or unblocks the card
+	CardBlocked *bool `json:"card_blocked"`
+	// GroupID moves the user to a group; an empty string removes it
+	GroupID *string `json:"group_id"`
+	// Spending limits; 0 removes the user's own limit
+	DailySpendLimit  *float64 `json:"daily_spend_limit" binding:"omitempty,gte=0"`
+	WeeklySpendLimit *float64 `json:"weekly_spend_limit" binding:"omitempty,gte=0"`
+	HourlyScanLimit  *int     `json:"hourly_scan_limit" binding:"omitempty,gte=0"`
+	// CreditLimit lets the balance go negative; 0 removes it
+	CreditLimit *float64 `json:"credit_limit" binding:"omitempty,gte=0"`
+}
+
+// SetPINRequest represents the request body for setting a user's PIN
+type SetPINRequest struct {
+	PIN string `json:"pin" binding:"required"`
 }
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// UserGroup bundles card holders that share spending limits, e.g. a class
+// or a department. Limits set on a user win over the group's.
+type UserGroup struct {
+	ID               uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	Name             string    `json:"name" gorm:"uniqueIndex;not null"`
+	Description      string    `json:"description"`
+	DailySpendLimit  *float64  `json:"daily_spend_limit" gorm:"type:decimal(10,2)"`
+	WeeklySpendLimit *float64  `json:"weekly_spend_limit" gorm:"type:decimal(10,2)"`
+	HourlyScanLimit  *int      `json:"hourly_scan_limit"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// BeforeCreate hook to generate UUID
+func (g *UserGroup) BeforeCreate(tx *gorm.DB) error {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name
+func (UserGroup) TableName() string {
+	return "user_groups"
+}
+
+// CreateUserGroupRequest represents the request body for creating a user group
+type CreateUserGroupRequest struct {
+	Name             string   `json:"name" binding:"required"`
+	Description      string   `json:"description"`
+	DailySpendLimit  *float64 `json:"daily_spend_limit" binding:"omitempty,gt=0"`
+	WeeklySpendLimit *float64 `json:"weekly_spend_limit" binding:"omitempty,gt=0"`
+	HourlyScanLimit  *int     `json:"hourly_scan_limit" binding:"omitempty,gt=0"`
+}
+
+// UpdateUserGroupRequest represents the request body for updating a user
+// group. A limit of 0 removes it.
+type UpdateUserGroupRequest struct {
+	Name             *string  `json:"name" binding:"omitempty,min=1"`
+	Description      *string  `json:"description"`
+	DailySpendLimit  *float64 `json:"daily_spend_limit" binding:"omitempty,gte=0"`
+	WeeklySpendLimit *float64 `json:"weekly_spend_limit" binding:"omitempty,gte=0"`
+	HourlyScanLimit  *int     `json:"hourly_scan_limit" binding:"omitempty,gte=0"`
+}
@@ -0,0 +1,59 @@
+package oidc
+
+import (
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// GroupRole assigns a role to members of an identity provider group
+type GroupRole struct {
+	Group string
+	Role  string
+}
+
+// RoleMapping maps groups to roles; earlier entries take priority
+type RoleMapping []GroupRole
+
+// ParseRoleMapping parses "group=role,group=role" pairs
+func ParseRoleMapping(value string) RoleMapping {
+	var mapping RoleMapping
+	for _, pair := range strings.Split(value, ",") {
+		group, role, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || group == "" || role == "" {
+			continue
+		}
+		mapping = append(mapping, GroupRole{Group: strings.TrimSpace(group), Role: strings.TrimSpace(role)})
+	}
+	return mapping
+}
+
+// Resolve returns the role of the first mapping entry matching one of groups
+func (m RoleMapping) Resolve(groups []string) (string, bool) {
+	for _, entry := range m {
+		for _, group := range groups {
+			if group == entry.Group {
+				return entry.Role, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Groups reads a groups claim that may be a list or a single string
+func Groups(claims jwt.MapClaims, claim string) []string {
+	switch v := claims[claim].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		groups := make([]string, 0, len(v))
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+		return groups
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,90 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// AuthRequest is what is remembered between redirecting to the provider and the callback
+type AuthRequest struct {
+	Nonce     string
+	Verifier  string
+	ExpiresAt time.Time
+}
+
+// StateStore keeps pending authorization requests keyed by state, in memory
+type StateStore struct {
+	mu       sync.Mutex
+	requests map[string]AuthRequest
+	ttl      time.Duration
+}
+
+// NewStateStore creates a store whose entries expire after ttl
+func NewStateStore(ttl time.Duration) *StateStore {
+	return &StateStore{
+		requests: map[string]AuthRequest{},
+		ttl:      ttl,
+	}
+}
+
+// Begin creates a new state with its nonce and PKCE verifier
+func (s *StateStore) Begin() (state string, req AuthRequest, err error) {
+	if state, err = RandomString(); err != nil {
+		return "", AuthRequest{}, err
+	}
+	if req.Nonce, err = RandomString(); err != nil {
+		return "", AuthRequest{}, err
+	}
+	if req.Verifier, err = RandomString(); err != nil {
+		return "", AuthRequest{}, err
+	}
+	req.ExpiresAt = time.Now().Add(s.ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, pending := range s.requests {
+		if now.After(pending.ExpiresAt) {
+			delete(s.requests, key)
+		}
+	}
+	s.requests[state] = req
+
+	return state, req, nil
+}
+
+// Take returns and removes the request for state; each state can be used once
+func (s *StateStore) Take(state string) (AuthRequest, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, ok := s.requests[state]
+	if !ok {
+		return AuthRequest{}, false
+	}
+	delete(s.requests, state)
+
+	if time.Now().After(req.ExpiresAt) {
+		return AuthRequest{}, false
+	}
+	return req, true
+}
+
+// CodeChallenge derives the S256 PKCE challenge for a verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// RandomString returns 32 random bytes encoded as URL-safe base64
+func RandomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
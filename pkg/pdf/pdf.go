@@ -0,0 +1,235 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// PageSize is a page size in points (1/72 inch)
+type PageSize struct {
+	Width  float64
+	Height float64
+}
+
+var (
+	// A4 is the ISO A4 paper size
+	A4 = PageSize{Width: 595, Height: 842}
+	// Receipt is an 80 mm thermal paper roll cut to 200 mm
+	Receipt = PageSize{Width: 227, Height: 567}
+)
+
+// Options controls the page layout
+type Options struct {
+	Size     PageSize
+	Margin   float64
+	FontSize float64
+}
+
+type line struct {
+	text string
+	bold bool
+}
+
+// Document is a text-only PDF document: lines of Courier on fixed size
+// pages, enough for reports, statements and receipts. Columns can be
+// aligned with padding.
+type Document struct {
+	opts  Options
+	title string
+	pages [][]line
+}
+
+// New starts an empty document
+func New(title string, opts Options) *Document {
+	if opts.Size.Width == 0 {
+		opts.Size = A4
+	}
+	if opts.Margin == 0 {
+		opts.Margin = 50
+	}
+	if opts.FontSize == 0 {
+		opts.FontSize = 10
+	}
+	return &Document{opts: opts, title: title, pages: [][]line{nil}}
+}
+
+// Columns returns how many characters fit on a line
+func (d *Document) Columns() int {
+	// Courier glyphs are 0.6 em wide
+	return int((d.opts.Size.Width - 2*d.opts.Margin) / (d.opts.FontSize * 0.6))
+}
+
+func (d *Document) leading() float64 {
+	return d.opts.FontSize * 1.25
+}
+
+func (d *Document) linesPerPage() int {
+	return int((d.opts.Size.Height - 2*d.opts.Margin) / d.leading())
+}
+
+// Line adds a line of text, starting a new page when the current one is full
+func (d *Document) Line(format string, args ...interface{}) {
+	d.add(line{text: fmt.Sprintf(format, args...)})
+}
+
+// Heading adds a bold line
+func (d *Document) Heading(format string, args ...interface{}) {
+	d.add(line{text: fmt.Sprintf(format, args...), bold: true})
+}
+
+// Blank adds an empty line
+func (d *Document) Blank() {
+	d.add(line{})
+}
+
+// Rule adds a line of dashes across the page
+func (d *Document) Rule() {
+	d.add(line{text: strings.Repeat("-", d.Columns())})
+}
+
+// PageBreak starts a new page
+func (d *Document) PageBreak() {
+	if len(d.pages[len(d.pages)-1]) > 0 {
+		d.pages = append(d.pages, nil)
+	}
+}
+
+func (d *Document) add(l line) {
+	last := len(d.pages) - 1
+	if len(d.pages[last]) == d.linesPerPage() {
+		d.pages = append(d.pages, nil)
+		last++
+	}
+	d.pages[last] = append(d.pages[last], l)
+}
+
+// Bytes renders the document. The output only depends on the content, so
+// the same document always has the same hash.
+func (d *Document) Bytes() []byte {
+	var w writer
+
+	// Objects 1-4 are fixed; each page adds a page and a content object
+	pageIDs := make([]string, len(d.pages))
+	for i := range d.pages {
+		pageIDs[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+
+	w.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	w.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(pageIDs, " "), len(d.pages)))
+	w.object(3, "<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	w.object(4, "<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range d.pages {
+		content := d.content(page)
+		w.object(5+2*i, fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			number(d.opts.Size.Width), number(d.opts.Size.Height), 6+2*i))
+		w.object(6+2*i, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+
+	info := 5 + 2*len(d.pages)
+	w.object(info, fmt.Sprintf("<< /Title (%s) /Producer (hudautomata) >>", escape(d.title)))
+
+	return w.finish(info)
+}
+
+func (d *Document) content(page []line) string {
+	var b strings.Builder
+	b.WriteString("BT\n")
+	fmt.Fprintf(&b, "%s TL\n", number(d.leading()))
+	fmt.Fprintf(&b, "1 0 0 1 %s %s Tm\n", number(d.opts.Margin), number(d.opts.Size.Height-d.opts.Margin-d.opts.FontSize))
+
+	bold := false
+	fmt.Fprintf(&b, "/F1 %s Tf\n", number(d.opts.FontSize))
+	for i, l := range page {
+		if l.bold != bold {
+			font := "/F1"
+			if l.bold {
+				font = "/F2"
+			}
+			fmt.Fprintf(&b, "%s %s Tf\n", font, number(d.opts.FontSize))
+			bold = l.bold
+		}
+		if i > 0 {
+			b.WriteString("T*\n")
+		}
+		fmt.Fprintf(&b, "(%s) Tj\n", escape(l.text))
+	}
+	b.WriteString("ET")
+	return b.String()
+}
+
+// writer tracks object offsets for the cross-reference table
+type writer struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+func (w *writer) object(id int, body string) {
+	if w.buf.Len() == 0 {
+		w.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	}
+	for len(w.offsets) < id {
+		w.offsets = append(w.offsets, 0)
+	}
+	w.offsets[id-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+func (w *writer) finish(info int) []byte {
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, info, xref)
+	return w.buf.Bytes()
+}
+
+func number(v float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", v), "0"), ".")
+}
+
+// transliterations covers letters outside WinAnsiEncoding that our users'
+// names contain
+var transliterations = map[rune]string{
+	'ş': "s", 'Ş': "S", 'ğ': "g", 'Ğ': "G", 'ı': "i", 'İ': "I",
+	'€': "EUR", '₺': "TL", '–': "-", '—': "-", '‘': "'", '’': "'", '“': "\"", '”': "\"",
+}
+
+// Latin1 encodes text in ISO 8859-1, the printable part of WinAnsiEncoding.
+// Letters outside it are transliterated or replaced by '?'.
+func Latin1(text string) []byte {
+	b := make([]byte, 0, len(text))
+	for _, r := range text {
+		switch {
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			b = append(b, byte(r))
+		default:
+			if t, ok := transliterations[r]; ok {
+				b = append(b, t...)
+			} else {
+				b = append(b, '?')
+			}
+		}
+	}
+	return b
+}
+
+// escape encodes text as a PDF literal string in WinAnsiEncoding
+func escape(text string) string {
+	var b strings.Builder
+	for _, c := range Latin1(text) {
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c >= 0xa0:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,244 @@
+package pin
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/lazypwny751/hudautomata/pkg/config"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidFormat is returned for PINs that are not 4 to 8 digits
+	ErrInvalidFormat = errors.New("PIN must be 4 to 8 digits")
+	// ErrNotSet is returned when the user has no PIN
+	ErrNotSet = errors.New("no PIN is set")
+	// ErrWrong is returned for a PIN that does not match
+	ErrWrong = errors.New("wrong PIN")
+	// ErrLocked is returned while the PIN is locked after too many failures
+	ErrLocked = errors.New("PIN is locked after too many failed attempts")
+)
+
+// Valid reports whether value is 4 to 8 digits
+func Valid(value string) bool {
+	if len(value) < 4 || len(value) > 8 {
+		return false
+	}
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Set stores a new PIN for the user and clears any lockout
+func Set(db *gorm.DB, user *models.User, value string) error {
+	return set(db, user, value, false)
+}
+
+// Reset replaces the user's PIN with a random temporary one that the user
+// is asked to change, clears any lockout and returns the temporary PIN
+func Reset(db *gorm.DB, user *models.User) (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+
+	temporary := fmt.Sprintf("%06d", n.Int64())
+	if err := set(db, user, temporary, true); err != nil {
+		return "", err
+	}
+	return temporary, nil
+}
+
+// Clear removes the user's PIN; scans no longer ask for one
+func Clear(db *gorm.DB, user *models.User) error {
+	err := db.Model(user).UpdateColumns(map[string]interface{}{
+		"pin_hash":            "",
+		"pin_set_at":          nil,
+		"pin_failed_attempts": 0,
+		"pin_locked_until":    nil,
+		"pin_must_change":     false,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	user.PINHash = ""
+	user.PINSetAt = nil
+	user.PINFailedAttempts = 0
+	user.PINLockedUntil = nil
+	user.PINMustChange = false
+	return nil
+}
+
+// Unlock lifts a PIN lockout and forgets earlier failed attempts
+func Unlock(db *gorm.DB, user *models.User) error {
+	err := db.Model(user).UpdateColumns(map[string]interface{}{
+		"pin_failed_attempts": 0,
+		"pin_locked_until":    nil,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	user.PINFailedAttempts = 0
+	user.PINLockedUntil = nil
+	return nil
+}
+
+func set(db *gorm.DB, user *models.User, value string, mustChange bool) error {
+	if !Valid(value) {
+		return ErrInvalidFormat
+	}
+
+	hash, err := utils.HashPassword(value)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = db.Model(user).UpdateColumns(map[string]interface{}{
+		"pin_hash":            hash,
+		"pin_set_at":          now,
+		"pin_failed_attempts": 0,
+		"pin_locked_until":    nil,
+		"pin_must_change":     mustChange,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	user.PINHash = hash
+	user.PINSetAt = &now
+	user.PINFailedAttempts = 0
+	user.PINLockedUntil = nil
+	user.PINMustChange = mustChange
+	return nil
+}
+
+// Verify checks a PIN against the user's. Every failure counts; after
+// PIN_MAX_ATTEMPTS in a row the PIN is locked for PIN_LOCKOUT and Verify
+// returns ErrLocked, even for the right PIN, until then.
+func Verify(db *gorm.DB, user *models.User, value string) error {
+	if user.PINHash == "" {
+		return ErrNotSet
+	}
+
+	now := time.Now()
+	if Locked(*user, now) {
+		return ErrLocked
+	}
+
+	// Claim the attempt before comparing, so concurrent guesses cannot get
+	// more than PIN_MAX_ATTEMPTS compares between them
+	maxAttempts := config.AppConfig.PINMaxAttempts
+	res := db.Model(&models.User{}).
+		Where("id = ? AND (pin_locked_until IS NULL OR pin_locked_until <= ?) AND pin_failed_attempts < ?", user.ID, now.UTC(), maxAttempts).
+		UpdateColumn("pin_failed_attempts", gorm.Expr("pin_failed_attempts + 1"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		if err := reloadLockout(db, user); err != nil {
+			return err
+		}
+		// The attempts ran out without a lockout being stored, e.g. when
+		// the maximum was lowered
+		if !Locked(*user, now) {
+			if err := lock(db, user, now); err != nil {
+				return err
+			}
+		}
+		return ErrLocked
+	}
+
+	if utils.CheckPasswordHash(value, user.PINHash) {
+		user.PINFailedAttempts = 0
+		user.PINLockedUntil = nil
+		return db.Model(user).UpdateColumns(map[string]interface{}{
+			"pin_failed_attempts": 0,
+			"pin_locked_until":    nil,
+		}).Error
+	}
+
+	if err := reloadLockout(db, user); err != nil {
+		return err
+	}
+	if user.PINFailedAttempts < maxAttempts {
+		return ErrWrong
+	}
+
+	if err := lock(db, user, now); err != nil {
+		return err
+	}
+	return ErrLocked
+}
+
+// lock starts a lockout from now unless another attempt already did
+func lock(db *gorm.DB, user *models.User, now time.Time) error {
+	until := now.Add(config.AppConfig.PINLockout).UTC()
+	res := db.Model(&models.User{}).
+		Where("id = ? AND pin_failed_attempts >= ?", user.ID, config.AppConfig.PINMaxAttempts).
+		UpdateColumns(map[string]interface{}{
+			"pin_failed_attempts": 0,
+			"pin_locked_until":    until,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return reloadLockout(db, user)
+	}
+
+	user.PINFailedAttempts = 0
+	user.PINLockedUntil = &until
+	return nil
+}
+
+// reloadLockout refreshes the user's failed attempts and lockout from the
+// database
+func reloadLockout(db *gorm.DB, user *models.User) error {
+	var current models.User
+	err := db.Select("pin_failed_attempts", "pin_locked_until").Where("id = ?", user.ID).Take(&current).Error
+	if err != nil {
+		return err
+	}
+
+	user.PINFailedAttempts = current.PINFailedAttempts
+	user.PINLockedUntil = current.PINLockedUntil
+	return nil
+}
+
+// Required reports whether a scan of cost for service needs the user's PIN:
+// the user has a PIN, and the cost reaches SCAN_PIN_THRESHOLD or the
+// service is listed in SCAN_PIN_SERVICES
+func Required(user models.User, service string, cost float64) bool {
+	if user.PINHash == "" {
+		return false
+	}
+
+	threshold := config.AppConfig.ScanPINThreshold
+	if threshold > 0 && cost >= threshold {
+		return true
+	}
+
+	for _, s := range strings.Split(config.AppConfig.ScanPINServices, ",") {
+		if s = strings.TrimSpace(s); s != "" && strings.EqualFold(s, service) {
+			return true
+		}
+	}
+	return false
+}
+
+// Locked reports whether the user's PIN is locked at now
+func Locked(user models.User, now time.Time) bool {
+	return user.PINLockedUntil != nil && now.Before(*user.PINLockedUntil)
+}
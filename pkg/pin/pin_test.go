@@ -0,0 +1,114 @@
+package pin
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lazypwny751/hudautomata/pkg/config"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setup stores a user with PIN 1234 that locks after three wrong PINs
+func setup(t *testing.T) (*gorm.DB, models.User) {
+	t.Helper()
+
+	config.AppConfig = &config.Config{PINMaxAttempts: 3, PINLockout: time.Minute}
+
+	dsn := filepath.Join(t.TempDir(), "pin.db") + "?_busy_timeout=5000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}); err != nil {
+		t.Fatal(err)
+	}
+
+	user := models.User{RFIDCardID: "CARD1", Name: "Test", Email: "test@example.com", IsActive: true}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := Set(db, &user, "1234"); err != nil {
+		t.Fatal(err)
+	}
+	return db, user
+}
+
+func TestVerifyLocksStaleCopies(t *testing.T) {
+	db, user := setup(t)
+
+	// Requests that loaded the user before the lockout must not get a compare
+	stale := user
+	for i := 0; i < 3; i++ {
+		attempt := user
+		Verify(db, &attempt, "0000")
+	}
+
+	if err := Verify(db, &stale, "1234"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("right PIN on a stale user after lockout: got %v, want ErrLocked", err)
+	}
+	if !Locked(stale, time.Now()) {
+		t.Fatal("stale user was not refreshed with the lockout")
+	}
+}
+
+func TestVerifyConcurrentGuesses(t *testing.T) {
+	db, user := setup(t)
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		wrong  int
+		locked int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempt := user
+			err := Verify(db, &attempt, "0000")
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case errors.Is(err, ErrWrong):
+				wrong++
+			case errors.Is(err, ErrLocked):
+				locked++
+			default:
+				t.Errorf("unexpected result %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Only claimed attempts are compared, and only those answer ErrWrong
+	if wrong > 3 {
+		t.Fatalf("%d guesses were compared before the lockout, want at most 3", wrong)
+	}
+	if err := Verify(db, &user, "1234"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("right PIN after the guesses: got %v, want ErrLocked", err)
+	}
+}
+
+func TestVerifyResetsAttempts(t *testing.T) {
+	db, user := setup(t)
+
+	if err := Verify(db, &user, "0000"); !errors.Is(err, ErrWrong) {
+		t.Fatalf("wrong PIN: got %v", err)
+	}
+	if err := Verify(db, &user, "1234"); err != nil {
+		t.Fatalf("right PIN: got %v", err)
+	}
+
+	var stored models.User
+	db.First(&stored, "id = ?", user.ID)
+	if stored.PINFailedAttempts != 0 {
+		t.Fatalf("failed attempts = %d after the right PIN, want 0", stored.PINFailedAttempts)
+	}
+}
@@ -0,0 +1,63 @@
+package receipt
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/lazypwny751/hudautomata/pkg/pdf"
+)
+
+// ESC/POS commands understood by Epson compatible thermal printers
+var (
+	escInit        = []byte{0x1b, 0x40}             // ESC @
+	escCodePage    = []byte{0x1b, 0x74, 0x10}       // ESC t 16: WPC1252
+	escAlignLeft   = []byte{0x1b, 0x61, 0x00}       // ESC a 0
+	escAlignCenter = []byte{0x1b, 0x61, 0x01}       // ESC a 1
+	escBoldOn      = []byte{0x1b, 0x45, 0x01}       // ESC E 1
+	escBoldOff     = []byte{0x1b, 0x45, 0x00}       // ESC E 0
+	escDoubleOn    = []byte{0x1d, 0x21, 0x11}       // GS ! 0x11: double width and height
+	escDoubleOff   = []byte{0x1d, 0x21, 0x00}       // GS ! 0
+	escFeedCut     = []byte{0x1d, 0x56, 0x42, 0x03} // GS V 66 3: feed 3 lines and cut
+)
+
+const (
+	// DefaultColumns fits 80 mm paper in font A on most printers
+	DefaultColumns = 42
+	// MinColumns and MaxColumns bound the supported paper widths
+	MinColumns = 24
+	MaxColumns = 64
+)
+
+// ESCPOS renders the receipt as a raw byte stream for a thermal printer
+// with the given number of characters per line. Text is sent in code page
+// WPC1252.
+func ESCPOS(r Receipt, columns int) []byte {
+	var b bytes.Buffer
+	b.Write(escInit)
+	b.Write(escCodePage)
+
+	for _, l := range r.lines(columns) {
+		switch {
+		case l.rule:
+			b.WriteString(strings.Repeat("-", columns))
+		case l.style == title:
+			b.Write(escAlignCenter)
+			b.Write(escDoubleOn)
+			b.Write(pdf.Latin1(l.text))
+			b.Write(escDoubleOff)
+			b.Write(escAlignLeft)
+		case l.style == bold:
+			b.Write(escAlignCenter)
+			b.Write(escBoldOn)
+			b.Write(pdf.Latin1(l.text))
+			b.Write(escBoldOff)
+			b.Write(escAlignLeft)
+		default:
+			b.Write(pdf.Latin1(l.text))
+		}
+		b.WriteByte('\n')
+	}
+
+	b.Write(escFeedCut)
+	return b.Bytes()
+}
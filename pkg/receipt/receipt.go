@@ -0,0 +1,192 @@
+package receipt
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/pdf"
+	"gorm.io/gorm"
+)
+
+// Receipt is the proof of one transaction handed to the card holder
+type Receipt struct {
+	Header        string
+	TransactionID string
+	Type          models.TransactionType
+	Status        models.TransactionStatus
+	Time          time.Time
+	UserName      string
+	Card          string
+	Amount        float64
+	BalanceBefore float64
+	BalanceAfter  float64
+	IssuedBy      string
+	DeviceID      string
+	Service       string
+	Description   string
+}
+
+// titles are the receipt headings per transaction type
+var titles = map[models.TransactionType]string{
+	models.TypeCredit: "TOP-UP",
+	models.TypeDebit:  "CHARGE",
+	models.TypeRefund: "REFUND",
+}
+
+// Load reads a transaction with everything a receipt shows
+func Load(db *gorm.DB, id interface{}) (models.Transaction, error) {
+	var t models.Transaction
+	err := db.Preload("User").Preload("Admin").Preload("APIKey").First(&t, id).Error
+	return t, err
+}
+
+// New builds the receipt of a transaction loaded with Load, with times in loc
+func New(t models.Transaction, header string, loc *time.Location) Receipt {
+	r := Receipt{
+		Header:        header,
+		TransactionID: t.ID.String(),
+		Type:          t.Type,
+		Status:        t.Status,
+		Time:          t.CreatedAt.In(loc),
+		UserName:      t.User.Name,
+		Card:          MaskCard(t.User.RFIDCardID),
+		Amount:        t.Amount,
+		BalanceBefore: t.BalanceBefore,
+		BalanceAfter:  t.BalanceAfter,
+		DeviceID:      t.DeviceID,
+		Service:       t.Service,
+		Description:   t.Description,
+	}
+	if t.ResolvedAt != nil {
+		r.Time = t.ResolvedAt.In(loc)
+	}
+
+	switch {
+	case t.Admin != nil:
+		r.IssuedBy = t.Admin.Username
+	case t.APIKey != nil:
+		r.IssuedBy = "API key " + t.APIKey.Name
+	case t.DeviceID != "":
+		r.IssuedBy = "Device " + t.DeviceID
+	default:
+		r.IssuedBy = string(t.Source)
+	}
+	return r
+}
+
+// MaskCard hides all but the last four characters of a card ID
+func MaskCard(card string) string {
+	if len(card) <= 4 {
+		return card
+	}
+	return strings.Repeat("*", len(card)-4) + card[len(card)-4:]
+}
+
+type style int
+
+const (
+	plain style = iota
+	title
+	bold
+)
+
+// line is one receipt line; rule lines are drawn across the width
+type line struct {
+	text  string
+	style style
+	rule  bool
+}
+
+// lines lays the receipt out for a given width in characters
+func (r Receipt) lines(width int) []line {
+	var out []line
+	add := func(s style, text string) {
+		out = append(out, line{text: text, style: s})
+	}
+	pair := func(label, value string) {
+		if runes(label)+runes(value)+1 > width {
+			add(plain, label)
+			add(plain, fmt.Sprintf("%*s", width, value))
+			return
+		}
+		add(plain, fmt.Sprintf("%-*s%s", width-runes(value), label, value))
+	}
+	rule := func() {
+		out = append(out, line{rule: true})
+	}
+
+	if r.Header != "" {
+		add(title, r.Header)
+	}
+	heading := titles[r.Type]
+	if heading == "" {
+		heading = strings.ToUpper(string(r.Type))
+	}
+	add(bold, heading)
+	if r.Status != models.StatusCompleted {
+		add(bold, strings.ToUpper(string(r.Status))+" - NOT BOOKED")
+	}
+	rule()
+
+	pair("Date", r.Time.Format("2006-01-02 15:04:05"))
+	pair("Name", r.UserName)
+	pair("Card", r.Card)
+	if r.Service != "" {
+		pair("Service", r.Service)
+	}
+	pair("By", r.IssuedBy)
+	if r.Description != "" {
+		pair("Note", r.Description)
+	}
+	rule()
+
+	pair("Balance before", money(r.BalanceBefore))
+	sign := "-"
+	if r.Type == models.TypeCredit || r.Type == models.TypeRefund {
+		sign = "+"
+	}
+	pair("Amount", sign+money(r.Amount))
+	pair("Balance after", money(r.BalanceAfter))
+	rule()
+
+	add(plain, "Transaction")
+	add(plain, r.TransactionID)
+	return out
+}
+
+func runes(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+func money(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
+
+// center pads text to sit in the middle of width
+func center(text string, width int) string {
+	if pad := (width - runes(text)) / 2; pad > 0 {
+		return strings.Repeat(" ", pad) + text
+	}
+	return text
+}
+
+// PDF renders the receipt on a thermal roll sized page
+func PDF(r Receipt) []byte {
+	doc := pdf.New("Receipt "+r.TransactionID, pdf.Options{Size: pdf.Receipt, Margin: 12, FontSize: 8})
+	width := doc.Columns()
+
+	for _, l := range r.lines(width) {
+		switch {
+		case l.rule:
+			doc.Rule()
+		case l.style == plain:
+			doc.Line("%s", l.text)
+		default:
+			doc.Heading("%s", center(l.text, width))
+		}
+	}
+	return doc.Bytes()
+}
@@ -0,0 +1,245 @@
+package reports
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+)
+
+// MaxBuckets limits how many buckets one chart may have
+const MaxBuckets = 1000
+
+// Series breakdowns
+const (
+	SeriesNone   = "none"
+	SeriesType   = "type"
+	SeriesSource = "source"
+)
+
+// breakdowns maps a breakdown to the transaction column it groups by and
+// the keys that always get a series, so charts being compared line up
+var breakdowns = map[string]struct {
+	column string
+	keys   []string
+}{
+	SeriesNone: {"'all'", []string{"all"}},
+	SeriesType: {"type", []string{
+		string(models.TypeCredit), string(models.TypeDebit), string(models.TypeRefund),
+	}},
+	SeriesSource: {"source", []string{
+		string(models.SourceAdmin), string(models.SourceAutomation), string(models.SourceSystem), string(models.SourceAPI),
+	}},
+}
+
+// ChartQuery selects the transactions and buckets of a chart. The range
+// is half open: From is included, To is not. Buckets follow the wall clock
+// of Location, or of the business time zone when it is nil.
+type ChartQuery struct {
+	From     time.Time
+	To       time.Time
+	Bucket   database.BucketUnit
+	SeriesBy string
+	Location *time.Location
+}
+
+// Point is one bucket of a series
+type Point struct {
+	Date   string  `json:"date"`
+	Amount float64 `json:"amount"`
+	Count  int64   `json:"count"`
+}
+
+// Series is the bucketed amounts and counts of one transaction type or
+// source, or of all transactions when the chart has no breakdown
+type Series struct {
+	Key    string  `json:"key"`
+	Amount float64 `json:"amount"`
+	Count  int64   `json:"count"`
+	Points []Point `json:"points"`
+}
+
+// Chart is the result of a chart query
+type Chart struct {
+	From     time.Time           `json:"from"`
+	To       time.Time           `json:"to"`
+	Bucket   database.BucketUnit `json:"bucket"`
+	Timezone string              `json:"timezone"`
+	SeriesBy string              `json:"series_by"`
+	Series   []Series            `json:"series"`
+	Previous *Chart              `json:"previous,omitempty"`
+}
+
+// Validate checks the range, bucket and breakdown
+func (q ChartQuery) Validate() error {
+	if !q.To.After(q.From) {
+		return errors.New("to must be after from")
+	}
+	if _, ok := breakdowns[q.SeriesBy]; !ok {
+		return fmt.Errorf("unsupported series %q", q.SeriesBy)
+	}
+	if _, err := BucketStarts(q.From, q.To, q.Bucket, q.location()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (q ChartQuery) location() *time.Location {
+	if q.Location != nil {
+		return q.Location
+	}
+	return Location
+}
+
+// Previous returns the same query for the period of equal length right before it
+func (q ChartQuery) Previous() ChartQuery {
+	previous := q
+	previous.To = q.From
+	previous.From = q.From.Add(-q.To.Sub(q.From))
+	return previous
+}
+
+// BuildChart runs the query over whole buckets: the range is widened to
+// the start of the first and the end of the last bucket. Every known type
+// or source has a series and every series has a point for every bucket;
+// buckets without transactions are zero.
+func BuildChart(db *gorm.DB, q ChartQuery) (*Chart, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	loc := q.location()
+	starts, _ := BucketStarts(q.From, q.To, q.Bucket, loc)
+	from, to := starts[0], database.NextBucket(starts[len(starts)-1], q.Bucket)
+
+	breakdown := breakdowns[q.SeriesBy]
+	rows, err := sumRows(db, from, to, q.Bucket, loc, breakdown.column)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[int64]int, len(starts))
+	for i, start := range starts {
+		index[start.Unix()] = i
+	}
+
+	series := map[string]*Series{}
+	for _, k := range breakdown.keys {
+		series[k] = &Series{Key: k, Points: emptyPoints(starts, q.Bucket)}
+	}
+	for _, row := range rows {
+		i, ok := index[row.BucketStart.Unix()]
+		if !ok {
+			continue
+		}
+
+		s, ok := series[row.Key]
+		if !ok {
+			s = &Series{Key: row.Key, Points: emptyPoints(starts, q.Bucket)}
+			series[row.Key] = s
+		}
+		s.Points[i].Amount += row.Amount
+		s.Points[i].Count += row.Count
+		s.Amount += row.Amount
+		s.Count += row.Count
+	}
+
+	chart := &Chart{
+		From:     from,
+		To:       to,
+		Bucket:   q.Bucket,
+		Timezone: loc.String(),
+		SeriesBy: q.SeriesBy,
+		Series:   []Series{},
+	}
+	for _, s := range series {
+		chart.Series = append(chart.Series, *s)
+	}
+	sort.Slice(chart.Series, func(i, j int) bool { return chart.Series[i].Key < chart.Series[j].Key })
+
+	return chart, nil
+}
+
+// sumRows sums the completed transactions in [from, to) matching where by
+// bucket start and key, reading the rollups when they line up with the
+// buckets. where may only use columns rollups share with transactions.
+func sumRows(db *gorm.DB, from, to time.Time, unit database.BucketUnit, loc *time.Location, key string, where ...interface{}) ([]rollupRow, error) {
+	if granularity, zone, ok := rollupGranularity(from, to, unit, loc); ok {
+		rows, err := sumRollups(db, granularity, zone, from, to, key, where...)
+		if err != nil {
+			return nil, err
+		}
+		// Several hourly or daily rollups make up one bucket
+		for i := range rows {
+			rows[i].BucketStart, _ = database.BucketStart(rows[i].BucketStart, unit, loc)
+		}
+		return rows, nil
+	}
+
+	bucket, err := database.DialectOf(db).DateBucket(bookedAt, unit, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	query := bookedIn(db.Model(&models.Transaction{}), from.UTC(), to.UTC()).
+		Select(bucket + " as bucket, " + key + " as key, COALESCE(SUM(amount), 0) as amount, COUNT(*) as count")
+	if len(where) > 0 {
+		query = query.Where(where[0], where[1:]...)
+	}
+
+	var raw []struct {
+		Bucket string
+		Key    string
+		Amount float64
+		Count  int64
+	}
+	err = query.Group(bucket + ", " + key).Scan(&raw).Error
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]rollupRow, len(raw))
+	for i, r := range raw {
+		start, err := database.ParseBucket(r.Bucket, loc)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected bucket %q: %w", r.Bucket, err)
+		}
+		rows[i] = rollupRow{BucketStart: start, Key: r.Key, Amount: r.Amount, Count: r.Count}
+	}
+	return rows, nil
+}
+
+// BucketStarts lists the start of every bucket overlapping [from, to)
+func BucketStarts(from, to time.Time, unit database.BucketUnit, loc *time.Location) ([]time.Time, error) {
+	first, err := database.BucketStart(from, unit, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	var starts []time.Time
+	for start := first; start.Before(to); start = database.NextBucket(start, unit) {
+		if len(starts) == MaxBuckets {
+			return nil, fmt.Errorf("range has more than %d %s buckets", MaxBuckets, unit)
+		}
+		starts = append(starts, start)
+	}
+	return starts, nil
+}
+
+// emptyPoints returns a zero point for every bucket, labelled for the unit
+func emptyPoints(starts []time.Time, unit database.BucketUnit) []Point {
+	layout := "2006-01-02"
+	if unit == database.BucketHour {
+		layout = "2006-01-02 15:00"
+	}
+
+	points := make([]Point, len(starts))
+	for i, start := range starts {
+		points[i] = Point{Date: start.Format(layout)}
+	}
+	return points
+}
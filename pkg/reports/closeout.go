@@ -0,0 +1,472 @@
+package reports
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/chain"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/pdf"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrDayNotOver is returned when closing a business day that has not ended
+	ErrDayNotOver = errors.New("business day has not ended yet")
+	// ErrAlreadyClosed is returned when closing a day that overlaps a closed one
+	ErrAlreadyClosed = errors.New("business day is already closed")
+	// ErrInvalidDate is returned for a business date not in YYYY-MM-DD form
+	ErrInvalidDate = errors.New("business date must be YYYY-MM-DD")
+)
+
+// maxCloseoutsPerRun limits how many missed days one CloseDueDays call closes
+const maxCloseoutsPerRun = 31
+
+// Closeout is the content of a daily closing statement (Z-report).
+// Transactions count on the day they were booked: when they were created,
+// or when they were approved if they needed approval.
+type Closeout struct {
+	BusinessDate       string          `json:"business_date"`
+	Timezone           string          `json:"timezone"`
+	From               time.Time       `json:"from"`
+	To                 time.Time       `json:"to"`
+	OpeningBalance     float64         `json:"opening_balance"`
+	Credits            CloseoutSection `json:"credits"`
+	Debits             CloseoutSection `json:"debits"`
+	Refunds            CloseoutSection `json:"refunds"`
+	Net                float64         `json:"net"`
+	OtherChanges       float64         `json:"other_changes"`
+	ClosingBalance     float64         `json:"closing_balance"`
+	TransactionCount   int64           `json:"transaction_count"`
+	Pending            int64           `json:"pending"`
+	Rejected           int64           `json:"rejected"`
+	TransactionsDigest string          `json:"transactions_digest"`
+	ClosedBy           string          `json:"closed_by,omitempty"`
+	ClosedAt           *time.Time      `json:"closed_at,omitempty"`
+}
+
+// CloseoutSection totals one transaction type. Credits and refunds are
+// broken down by who issued them, debits by device and service.
+type CloseoutSection struct {
+	Count  int64          `json:"count"`
+	Amount float64        `json:"amount"`
+	Lines  []CloseoutLine `json:"lines"`
+}
+
+// CloseoutLine is one row of a section breakdown
+type CloseoutLine struct {
+	Label    string                   `json:"label"`
+	AdminID  *uuid.UUID               `json:"admin_id,omitempty"`
+	APIKeyID *uuid.UUID               `json:"api_key_id,omitempty"`
+	DeviceID string                   `json:"device_id,omitempty"`
+	Service  string                   `json:"service,omitempty"`
+	Source   models.TransactionSource `json:"source"`
+	Count    int64                    `json:"count"`
+	Amount   float64                  `json:"amount"`
+}
+
+// BusinessDay returns the UTC range of a YYYY-MM-DD business day in loc
+func BusinessDay(date string, loc *time.Location) (time.Time, time.Time, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, ErrInvalidDate
+	}
+	return day.UTC(), day.AddDate(0, 0, 1).UTC(), nil
+}
+
+// bookedIn selects completed transactions whose bookedAt falls in [from, to).
+// Spelled out so the created_at index can serve the common case.
+func bookedIn(db *gorm.DB, from, to time.Time) *gorm.DB {
+	return db.Where("status = ?", models.StatusCompleted).
+		Where("(resolved_at IS NULL AND created_at >= ? AND created_at < ?) OR (resolved_at >= ? AND resolved_at < ?)", from, to, from, to)
+}
+
+// BuildCloseout computes the closing statement of a business day in the
+// business time zone without storing it
+func BuildCloseout(db *gorm.DB, date string) (*Closeout, error) {
+	from, to, err := BusinessDay(date, Location)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Closeout{
+		BusinessDate: date,
+		Timezone:     Location.String(),
+		From:         from.In(Location),
+		To:           to.In(Location),
+	}
+
+	var transactions []models.Transaction
+	err = bookedIn(db.Preload("Admin").Preload("APIKey"), from, to).
+		Order("created_at, id").
+		Find(&transactions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	credits := map[string]*CloseoutLine{}
+	debits := map[string]*CloseoutLine{}
+	refunds := map[string]*CloseoutLine{}
+
+	for _, t := range transactions {
+		switch t.Type {
+		case models.TypeCredit:
+			addLine(&report.Credits, credits, issuerLine(t), t.Amount)
+		case models.TypeRefund:
+			addLine(&report.Refunds, refunds, issuerLine(t), t.Amount)
+		case models.TypeDebit:
+			addLine(&report.Debits, debits, deviceLine(t), t.Amount)
+		}
+	}
+	report.Credits.Lines = sortedLines(credits)
+	report.Debits.Lines = sortedLines(debits)
+	report.Refunds.Lines = sortedLines(refunds)
+
+	report.TransactionCount = int64(len(transactions))
+	report.TransactionsDigest = TransactionsDigest(transactions)
+	report.Net = round(report.Credits.Amount + report.Refunds.Amount - report.Debits.Amount)
+
+	// Both balances come from one statement so they share a snapshot: the
+	// current total minus everything booked since the start or end of the day
+	var balances struct {
+		Total      float64
+		SinceStart float64
+		SinceEnd   float64
+	}
+	signed := "CASE WHEN type IN ('credit', 'refund') THEN amount ELSE -amount END"
+	err = db.Raw(`SELECT
+		(SELECT COALESCE(SUM(balance), 0) FROM users) AS total,
+		(SELECT COALESCE(SUM(`+signed+`), 0) FROM transactions WHERE status = ? AND `+bookedAt+` >= ?) AS since_start,
+		(SELECT COALESCE(SUM(`+signed+`), 0) FROM transactions WHERE status = ? AND `+bookedAt+` >= ?) AS since_end`,
+		models.StatusCompleted, from, models.StatusCompleted, to).
+		Scan(&balances).Error
+	if err != nil {
+		return nil, err
+	}
+	report.OpeningBalance = round(balances.Total - balances.SinceStart)
+	report.ClosingBalance = round(balances.Total - balances.SinceEnd)
+	// Balances set outside transactions, e.g. a new user's starting balance
+	report.OtherChanges = round(report.ClosingBalance - report.OpeningBalance - report.Net)
+
+	for status, count := range map[models.TransactionStatus]*int64{
+		models.StatusPending:  &report.Pending,
+		models.StatusRejected: &report.Rejected,
+	} {
+		err := db.Model(&models.Transaction{}).
+			Where("status = ? AND created_at >= ? AND created_at < ?", status, from, to).
+			Count(count).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+func addLine(section *CloseoutSection, lines map[string]*CloseoutLine, line CloseoutLine, amount float64) {
+	key := line.Label + "\x00" + string(line.Source) + "\x00" + line.Service
+	existing, ok := lines[key]
+	if !ok {
+		existing = &line
+		lines[key] = existing
+	}
+	existing.Count++
+	existing.Amount = round(existing.Amount + amount)
+
+	section.Count++
+	section.Amount = round(section.Amount + amount)
+}
+
+func issuerLine(t models.Transaction) CloseoutLine {
+	line := CloseoutLine{Label: string(t.Source), Source: t.Source}
+	switch {
+	case t.Admin != nil:
+		line.Label = t.Admin.Username
+		line.AdminID = t.AdminID
+	case t.APIKey != nil:
+		line.Label = "API key " + t.APIKey.Name
+		line.APIKeyID = t.APIKeyID
+	}
+	return line
+}
+
+func deviceLine(t models.Transaction) CloseoutLine {
+	line := CloseoutLine{Label: string(t.Source), Source: t.Source, DeviceID: t.DeviceID, Service: t.Service}
+	if t.DeviceID != "" {
+		line.Label = t.DeviceID
+	}
+	return line
+}
+
+func sortedLines(lines map[string]*CloseoutLine) []CloseoutLine {
+	sorted := make([]CloseoutLine, 0, len(lines))
+	for _, line := range lines {
+		sorted = append(sorted, *line)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Label != sorted[j].Label {
+			return sorted[i].Label < sorted[j].Label
+		}
+		return sorted[i].Service < sorted[j].Service
+	})
+	return sorted
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// TransactionsDigest is a SHA-256 over the ID, type, amount and booking
+// time of the given transactions, to detect later changes to a closed day
+func TransactionsDigest(transactions []models.Transaction) string {
+	lines := make([]string, len(transactions))
+	for i, t := range transactions {
+		lines[i] = strings.Join([]string{
+			t.ID.String(),
+			string(t.Type),
+			strconv.FormatFloat(t.Amount, 'f', 2, 64),
+			strconv.FormatInt(t.BookedAt().UTC().UnixMilli(), 10),
+		}, "|")
+	}
+	sort.Strings(lines)
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// CloseoutMessage is the exact text that is signed for a closeout
+func CloseoutMessage(c models.DailyCloseout) []byte {
+	return []byte(fmt.Sprintf("closeout\n%s\n%s\n%s\n%s\n%s\n%d",
+		c.BusinessDate, c.Timezone, c.ReportSHA256, c.PDFSHA256, c.TransactionsDigest, c.CreatedAt.UTC().UnixMilli()))
+}
+
+// CloseDay closes a business day that has ended: it builds the statement,
+// renders it as JSON and PDF, signs both and stores them. From then on no
+// transaction can be booked into the day.
+func CloseDay(db *gorm.DB, date string, closedByID *uuid.UUID, closedBy string) (*models.DailyCloseout, error) {
+	from, to, err := BusinessDay(date, Location)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	if to.After(now) {
+		return nil, ErrDayNotOver
+	}
+
+	var closeout *models.DailyCloseout
+	err = db.Transaction(func(tx *gorm.DB) error {
+		closed, err := overlapsCloseout(tx, from, to)
+		if err != nil {
+			return err
+		}
+		if closed {
+			return ErrAlreadyClosed
+		}
+
+		report, err := BuildCloseout(tx, date)
+		if err != nil {
+			return err
+		}
+		closedAt := now.In(Location)
+		report.ClosedBy = closedBy
+		report.ClosedAt = &closedAt
+
+		reportJSON, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		reportPDF := CloseoutPDF(report)
+
+		closeout = &models.DailyCloseout{
+			BusinessDate:       date,
+			Timezone:           report.Timezone,
+			PeriodStart:        from,
+			PeriodEnd:          to,
+			OpeningBalance:     report.OpeningBalance,
+			ClosingBalance:     report.ClosingBalance,
+			TotalCredits:       report.Credits.Amount,
+			TotalDebits:        report.Debits.Amount,
+			TotalRefunds:       report.Refunds.Amount,
+			TransactionCount:   report.TransactionCount,
+			TransactionsDigest: report.TransactionsDigest,
+			Report:             string(reportJSON),
+			ReportSHA256:       sha256Hex(reportJSON),
+			PDF:                reportPDF,
+			PDFSHA256:          sha256Hex(reportPDF),
+			ClosedByID:         closedByID,
+			CreatedAt:          now,
+		}
+		closeout.PublicKey, closeout.Signature, err = chain.Sign(CloseoutMessage(*closeout))
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(closeout).Error
+	})
+	return closeout, err
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func overlapsCloseout(tx *gorm.DB, from, to time.Time) (bool, error) {
+	var count int64
+	err := tx.Model(&models.DailyCloseout{}).
+		Where("period_start < ? AND period_end > ?", to, from).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// DayClosed reports whether at falls in a closed business day
+func DayClosed(tx *gorm.DB, at time.Time) (bool, error) {
+	at = at.UTC()
+	var count int64
+	err := tx.Model(&models.DailyCloseout{}).
+		Where("period_start <= ? AND period_end > ?", at, at).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// CloseDueDays closes every business day that has ended since the last
+// closeout, or since the first transaction when nothing was closed yet
+func CloseDueDays(db *gorm.DB) ([]models.DailyCloseout, error) {
+	var next time.Time
+
+	var last models.DailyCloseout
+	if err := db.Order("period_end DESC").Limit(1).Find(&last).Error; err != nil {
+		return nil, err
+	}
+	if last.ID != uuid.Nil {
+		next = last.PeriodEnd
+	} else {
+		var first models.Transaction
+		if err := db.Order("created_at").Limit(1).Find(&first).Error; err != nil {
+			return nil, err
+		}
+		if first.ID == uuid.Nil {
+			return nil, nil
+		}
+		next = first.CreatedAt
+	}
+
+	var closed []models.DailyCloseout
+	for len(closed) < maxCloseoutsPerRun {
+		date := next.In(Location).Format("2006-01-02")
+		_, end, _ := BusinessDay(date, Location)
+
+		closeout, err := CloseDay(db, date, nil, "system")
+		switch {
+		case errors.Is(err, ErrDayNotOver):
+			return closed, nil
+		case errors.Is(err, ErrAlreadyClosed):
+			// E.g. after BUSINESS_TIMEZONE changed the old day still covers part of it
+		case err != nil:
+			return closed, err
+		default:
+			closed = append(closed, *closeout)
+		}
+		next = end
+	}
+	return closed, nil
+}
+
+// CloseoutCheck is the result of verifying a stored closeout
+type CloseoutCheck struct {
+	BusinessDate          string `json:"business_date"`
+	OK                    bool   `json:"ok"`
+	SignatureValid        bool   `json:"signature_valid"`
+	TrustedKey            bool   `json:"trusted_key"`
+	ReportIntact          bool   `json:"report_intact"`
+	PDFIntact             bool   `json:"pdf_intact"`
+	TransactionsUnchanged bool   `json:"transactions_unchanged"`
+}
+
+// VerifyCloseout checks a closeout's signature and stored documents, and
+// that the transactions booked in its day are still the ones it was built from
+func VerifyCloseout(db *gorm.DB, c models.DailyCloseout) (CloseoutCheck, error) {
+	check := CloseoutCheck{
+		BusinessDate:   c.BusinessDate,
+		SignatureValid: chain.VerifySignature(c.PublicKey, c.Signature, CloseoutMessage(c)),
+		TrustedKey:     c.PublicKey == chain.PublicKey(),
+		ReportIntact:   sha256Hex([]byte(c.Report)) == c.ReportSHA256,
+		PDFIntact:      sha256Hex(c.PDF) == c.PDFSHA256,
+	}
+
+	var transactions []models.Transaction
+	if err := bookedIn(db, c.PeriodStart, c.PeriodEnd).Find(&transactions).Error; err != nil {
+		return check, err
+	}
+	check.TransactionsUnchanged = TransactionsDigest(transactions) == c.TransactionsDigest
+
+	check.OK = check.SignatureValid && check.TrustedKey && check.ReportIntact && check.PDFIntact && check.TransactionsUnchanged
+	return check, nil
+}
+
+// CloseoutPDF renders the printable statement
+func CloseoutPDF(r *Closeout) []byte {
+	doc := pdf.New("Daily closeout "+r.BusinessDate, pdf.Options{})
+	width := doc.Columns()
+	row := func(label string, amount float64) {
+		value := strconv.FormatFloat(amount, 'f', 2, 64)
+		doc.Line("%-*s%s", width-len(value), label, value)
+	}
+
+	doc.Heading("DAILY CLOSEOUT (Z-REPORT)")
+	doc.Line("Business day: %s (%s)", r.BusinessDate, r.Timezone)
+	doc.Line("Period:       %s - %s", r.From.Format("2006-01-02 15:04"), r.To.Format("2006-01-02 15:04"))
+	if r.ClosedAt != nil {
+		doc.Line("Closed:       %s by %s", r.ClosedAt.Format("2006-01-02 15:04:05"), r.ClosedBy)
+	} else {
+		doc.Line("PREVIEW - the day is not closed")
+	}
+	doc.Rule()
+	row("Opening balance", r.OpeningBalance)
+	doc.Blank()
+
+	for _, s := range []struct {
+		title   string
+		section CloseoutSection
+		sign    float64
+	}{
+		{"Credits", r.Credits, 1},
+		{"Refunds", r.Refunds, 1},
+		{"Debits", r.Debits, -1},
+	} {
+		doc.Heading("%s (%d)", s.title, s.section.Count)
+		for _, line := range s.section.Lines {
+			label := "  " + line.Label
+			if line.Service != "" {
+				label += " / " + line.Service
+			}
+			row(fmt.Sprintf("%s (%d)", label, line.Count), line.Amount)
+		}
+		row("  Total", s.sign*s.section.Amount)
+		doc.Blank()
+	}
+
+	row("Net", r.Net)
+	row("Other balance changes", r.OtherChanges)
+	doc.Rule()
+	doc.Heading("Closing balance%*s", width-len("Closing balance"), strconv.FormatFloat(r.ClosingBalance, 'f', 2, 64))
+	doc.Blank()
+	doc.Line("Transactions booked: %d", r.TransactionCount)
+	doc.Line("Pending at close:    %d", r.Pending)
+	doc.Line("Rejected:            %d", r.Rejected)
+	doc.Blank()
+	doc.Line("Transactions digest:")
+	doc.Line("%s", r.TransactionsDigest)
+
+	return doc.Bytes()
+}
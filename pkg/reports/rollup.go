@@ -0,0 +1,228 @@
+package reports
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// rollupKey are the columns identifying one rollup row
+var rollupKey = []clause.Column{
+	{Name: "granularity"}, {Name: "timezone"}, {Name: "bucket_start"},
+	{Name: "user_id"}, {Name: "device_id"}, {Name: "service"}, {Name: "type"}, {Name: "source"},
+}
+
+// bookedAt is the SQL counterpart of Transaction.BookedAt. Rollups, charts
+// and closeouts all count a transaction at this time.
+const bookedAt = "COALESCE(resolved_at, created_at)"
+
+// AddRollups counts a completed transaction in the hourly and daily rollups
+// of the time it was booked. It runs inside the transaction that books it,
+// so rollups never drift from the ledger.
+func AddRollups(tx *gorm.DB, t *models.Transaction) error {
+	hour, _ := database.BucketStart(t.BookedAt(), database.BucketHour, time.UTC)
+	day, _ := database.BucketStart(t.BookedAt(), database.BucketDay, Location)
+
+	rollups := []models.TransactionRollup{
+		rollupOf(t, models.RollupHour, time.UTC, hour),
+		rollupOf(t, models.RollupDay, Location, day),
+	}
+
+	for _, r := range rollups {
+		err := tx.Clauses(clause.OnConflict{
+			Columns: rollupKey,
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"count":      gorm.Expr("transaction_rollups.count + ?", r.Count),
+				"amount":     gorm.Expr("transaction_rollups.amount + ?", r.Amount),
+				"updated_at": time.Now().UTC(),
+			}),
+		}).Create(&r).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rollupOf(t *models.Transaction, granularity models.RollupGranularity, loc *time.Location, start time.Time) models.TransactionRollup {
+	return models.TransactionRollup{
+		Granularity: granularity,
+		Timezone:    loc.String(),
+		BucketStart: start.UTC(),
+		UserID:      t.UserID,
+		DeviceID:    t.DeviceID,
+		Service:     t.Service,
+		Type:        t.Type,
+		Source:      t.Source,
+		Count:       1,
+		Amount:      t.Amount,
+	}
+}
+
+// RebuildRollups recomputes every rollup from the completed transactions,
+// e.g. after BUSINESS_TIMEZONE changed, and returns the number of rows
+func RebuildRollups(db *gorm.DB) (int, error) {
+	rows := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Keep transactions from being rolled up while the table is rebuilt
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Exec("LOCK TABLE transaction_rollups IN EXCLUSIVE MODE").Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("1 = 1").Delete(&models.TransactionRollup{}).Error; err != nil {
+			return err
+		}
+
+		for _, g := range []struct {
+			granularity models.RollupGranularity
+			unit        database.BucketUnit
+			loc         *time.Location
+		}{
+			{models.RollupHour, database.BucketHour, time.UTC},
+			{models.RollupDay, database.BucketDay, Location},
+		} {
+			n, err := rebuildGranularity(tx, g.granularity, g.unit, g.loc)
+			rows += n
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return rows, err
+}
+
+func rebuildGranularity(tx *gorm.DB, granularity models.RollupGranularity, unit database.BucketUnit, loc *time.Location) (int, error) {
+	bucket, err := database.DialectOf(tx).DateBucket(bookedAt, unit, loc)
+	if err != nil {
+		return 0, err
+	}
+
+	var groups []struct {
+		Bucket   string
+		UserID   string
+		DeviceID string
+		Service  string
+		Type     models.TransactionType
+		Source   models.TransactionSource
+		Count    int64
+		Amount   float64
+	}
+	err = tx.Model(&models.Transaction{}).
+		Select(bucket+" as bucket, user_id, COALESCE(device_id, '') as device_id, COALESCE(service, '') as service, type, source, COUNT(*) as count, COALESCE(SUM(amount), 0) as amount").
+		Where("status = ?", models.StatusCompleted).
+		Group(bucket + ", user_id, COALESCE(device_id, ''), COALESCE(service, ''), type, source").
+		Scan(&groups).Error
+	if err != nil {
+		return 0, err
+	}
+
+	rollups := make([]models.TransactionRollup, 0, len(groups))
+	for _, g := range groups {
+		start, err := database.ParseBucket(g.Bucket, loc)
+		if err != nil {
+			return 0, fmt.Errorf("unexpected bucket %q: %w", g.Bucket, err)
+		}
+		r := models.TransactionRollup{
+			Granularity: granularity,
+			Timezone:    loc.String(),
+			BucketStart: start.UTC(),
+			DeviceID:    g.DeviceID,
+			Service:     g.Service,
+			Type:        g.Type,
+			Source:      g.Source,
+			Count:       g.Count,
+			Amount:      g.Amount,
+		}
+		if err := r.UserID.UnmarshalText([]byte(g.UserID)); err != nil {
+			return 0, err
+		}
+		rollups = append(rollups, r)
+	}
+
+	if len(rollups) == 0 {
+		return 0, nil
+	}
+	return len(rollups), tx.CreateInBatches(&rollups, 500).Error
+}
+
+// EnsureRollups rebuilds the rollups when there are completed transactions
+// but no daily rollups for the business time zone, i.e. on first start and
+// after the zone changed
+func EnsureRollups(db *gorm.DB) error {
+	var rollups int64
+	err := db.Model(&models.TransactionRollup{}).
+		Where("granularity = ? AND timezone = ?", models.RollupDay, Location.String()).
+		Limit(1).Count(&rollups).Error
+	if err != nil || rollups > 0 {
+		return err
+	}
+
+	var transactions int64
+	err = db.Model(&models.Transaction{}).Where("status = ?", models.StatusCompleted).Limit(1).Count(&transactions).Error
+	if err != nil || transactions == 0 {
+		return err
+	}
+
+	rows, err := RebuildRollups(db)
+	if err == nil {
+		log.Printf("Rebuilt %d transaction rollups for %s", rows, Location)
+	}
+	return err
+}
+
+// rollupGranularity picks the rollups that can answer a query over
+// [from, to) bucketed by unit in loc: daily ones when the range is whole
+// days of the zone they were built for, otherwise hourly ones when every UTC
+// hour falls in a single bucket of loc. It returns false when neither can.
+func rollupGranularity(from, to time.Time, unit database.BucketUnit, loc *time.Location) (models.RollupGranularity, string, bool) {
+	if unit != database.BucketHour && loc.String() == Location.String() && isMidnight(from, loc) && isMidnight(to, loc) {
+		return models.RollupDay, loc.String(), true
+	}
+
+	if from.Equal(from.Truncate(time.Hour)) && to.Equal(to.Truncate(time.Hour)) && wholeHourOffset(from, loc) && wholeHourOffset(to, loc) {
+		return models.RollupHour, time.UTC.String(), true
+	}
+
+	return "", "", false
+}
+
+func isMidnight(t time.Time, loc *time.Location) bool {
+	day, _ := database.BucketStart(t, database.BucketDay, loc)
+	return day.Equal(t)
+}
+
+func wholeHourOffset(t time.Time, loc *time.Location) bool {
+	_, offset := t.In(loc).Zone()
+	return offset%3600 == 0
+}
+
+// rollupRow is a rollup sum by bucket start and key
+type rollupRow struct {
+	BucketStart time.Time
+	Key         string
+	Amount      float64
+	Count       int64
+}
+
+// sumRollups sums the rollups of [from, to) by bucket start and key. The
+// caller must have checked the range with rollupGranularity.
+func sumRollups(db *gorm.DB, granularity models.RollupGranularity, zone string, from, to time.Time, key string, where ...interface{}) ([]rollupRow, error) {
+	query := db.Model(&models.TransactionRollup{}).
+		Select("bucket_start, "+key+" as key, COALESCE(SUM(amount), 0) as amount, COALESCE(SUM(count), 0) as count").
+		Where("granularity = ? AND timezone = ? AND bucket_start >= ? AND bucket_start < ?", granularity, zone, from.UTC(), to.UTC())
+	if len(where) > 0 {
+		query = query.Where(where[0], where[1:]...)
+	}
+
+	var rows []rollupRow
+	err := query.Group("bucket_start, " + key).Scan(&rows).Error
+	return rows, err
+}
@@ -0,0 +1,123 @@
+package reports
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+)
+
+// summaryColumns maps a summary grouping to the column it groups by
+var summaryColumns = map[string]string{
+	"user":    "user_id",
+	"device":  "device_id",
+	"service": "service",
+	"type":    "type",
+	"source":  "source",
+}
+
+// SummaryQuery totals completed transactions in [From, To) per GroupBy
+// (user, device, service, type or source)
+type SummaryQuery struct {
+	From     time.Time
+	To       time.Time
+	GroupBy  string
+	Location *time.Location
+}
+
+// SummaryRow is the total of one group
+type SummaryRow struct {
+	Key    string  `json:"key"`
+	Name   string  `json:"name,omitempty"`
+	Count  int64   `json:"count"`
+	Amount float64 `json:"amount"`
+}
+
+// Validate checks the range and grouping
+func (q SummaryQuery) Validate() error {
+	if !q.To.After(q.From) {
+		return errors.New("to must be after from")
+	}
+	if _, ok := summaryColumns[q.GroupBy]; !ok {
+		return fmt.Errorf("unsupported group %q", q.GroupBy)
+	}
+	return nil
+}
+
+// Summarize returns the totals per group, largest amount first
+func Summarize(db *gorm.DB, q SummaryQuery) ([]SummaryRow, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	loc := q.Location
+	if loc == nil {
+		loc = Location
+	}
+
+	rows, err := sumRows(db, q.From, q.To, database.BucketDay, loc, summaryColumns[q.GroupBy])
+	if err != nil {
+		return nil, err
+	}
+
+	totals := map[string]*SummaryRow{}
+	for _, row := range rows {
+		total, ok := totals[row.Key]
+		if !ok {
+			total = &SummaryRow{Key: row.Key}
+			totals[row.Key] = total
+		}
+		total.Count += row.Count
+		total.Amount += row.Amount
+	}
+
+	// Name users, who are grouped by ID
+	if q.GroupBy == "user" && len(totals) > 0 {
+		ids := make([]string, 0, len(totals))
+		for id := range totals {
+			ids = append(ids, id)
+		}
+		var users []models.User
+		if err := db.Select("id, name").Where("id IN ?", ids).Find(&users).Error; err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			if total, ok := totals[user.ID.String()]; ok {
+				total.Name = user.Name
+			}
+		}
+	}
+
+	summary := make([]SummaryRow, 0, len(totals))
+	for _, total := range totals {
+		summary = append(summary, *total)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].Amount != summary[j].Amount {
+			return summary[i].Amount > summary[j].Amount
+		}
+		return summary[i].Key < summary[j].Key
+	})
+	return summary, nil
+}
+
+// Totals counts and sums the completed transactions in [from, to) matching
+// where, e.g. "type = ?", models.TypeDebit
+func Totals(db *gorm.DB, from, to time.Time, loc *time.Location, where ...interface{}) (int64, float64, error) {
+	rows, err := sumRows(db, from, to, database.BucketDay, loc, "'all'", where...)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var count int64
+	var amount float64
+	for _, row := range rows {
+		count += row.Count
+		amount += row.Amount
+	}
+	return count, amount, nil
+}
@@ -0,0 +1,31 @@
+package reports
+
+import (
+	"fmt"
+	"time"
+)
+
+// Location is the business time zone. Days, weeks and months in stats,
+// charts and scheduled reports start at midnight on its wall clock.
+var Location = time.UTC
+
+// LoadLocation loads an IANA time zone such as "Europe/Istanbul"
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("time zone %q is not an IANA name", name)
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// Day returns the start and end of the business day t falls in, in UTC
+// like stored timestamps
+func Day(t time.Time, loc *time.Location) (time.Time, time.Time) {
+	t = t.In(loc)
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	return start.UTC(), start.AddDate(0, 0, 1).UTC()
+}
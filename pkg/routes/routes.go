@@ -1,23 +1,40 @@
 package routes
 
 import (
+	"time"
+
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/lazypwny751/hudautomata/pkg/config"
 	"github.com/lazypwny751/hudautomata/pkg/handlers"
 	"github.com/lazypwny751/hudautomata/pkg/middleware"
+	"github.com/lazypwny751/hudautomata/pkg/models"
 )
 
+// NewRouter creates the engine with all routes. The client address is taken
+// from X-Forwarded-For or X-Real-IP only for requests from trustedProxies.
+func NewRouter(trustedProxies []string) (*gin.Engine, error) {
+	r := gin.Default()
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
+
+	SetupRoutes(r)
+	return r, nil
+}
+
 func SetupRoutes(r *gin.Engine) {
 	// CORS middleware
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-API-Key", "X-Request-ID"},
+		ExposeHeaders:    []string{"Content-Length", "X-Request-ID"},
 		AllowCredentials: true,
 	}))
 
-	// Logger middleware
+	// Request ID and logger middleware
+	r.Use(middleware.RequestID())
 	r.Use(middleware.Logger())
 
 	// Health check
@@ -39,16 +56,38 @@ func SetupRoutes(r *gin.Engine) {
 			auth := v1.Group("/auth")
 			{
 				auth.POST("/login", handlers.Login)
+				auth.GET("/providers", handlers.GetAuthProviders)
+				auth.GET("/oidc/login", handlers.OIDCLogin)
+				auth.GET("/oidc/callback", handlers.OIDCCallback)
 				auth.POST("/logout", middleware.AuthMiddleware(), handlers.Logout)
 				auth.GET("/me", middleware.AuthMiddleware(), handlers.GetMe)
 			}
 
+			// Self-service portal for card holders, with its own tokens
+			if config.AppConfig.PortalEnabled {
+				portal := v1.Group("/portal")
+				{
+					portal.POST("/auth/login", handlers.PortalLogin)
+					portal.POST("/auth/link", middleware.RateLimit(config.AppConfig.PortalLinkIPLimit, time.Hour), handlers.PortalRequestLink)
+					portal.POST("/auth/link/login", handlers.PortalLinkLogin)
+					portal.GET("/me", middleware.PortalAuthMiddleware(), handlers.PortalMe)
+					portal.GET("/transactions", middleware.PortalAuthMiddleware(), handlers.PortalTransactions)
+					portal.GET("/cards", middleware.PortalAuthMiddleware(), handlers.PortalCards)
+					portal.POST("/cards/block", middleware.PortalAuthMiddleware(), handlers.PortalBlockCard)
+					portal.PUT("/pin", middleware.PortalPINChangeMiddleware(), handlers.PortalChangePIN)
+				}
+			}
+
 			// Automation routes (no auth required for IoT devices)
 			automation := v1.Group("/automation")
 			{
 				automation.POST("/scan", handlers.AutomationScan)
 				automation.POST("/check-balance", handlers.CheckBalance)
-				automation.GET("/history", middleware.AuthMiddleware(), handlers.GetAutomationHistory)
+				automation.GET("/receipts/:id", handlers.GetDeviceReceipt)
+				automation.POST("/topup-requests", handlers.FileTopUpRequest)
+				automation.GET("/history", middleware.AuthMiddleware(), middleware.RequirePermission(models.PermAutomationRead), handlers.GetAutomationHistory)
+				automation.GET("/scans", middleware.AuthMiddleware(), middleware.RequirePermission(models.PermAutomationRead), handlers.ListScanEvents)
+				automation.GET("/scans/stats", middleware.AuthMiddleware(), middleware.RequirePermission(models.PermAutomationRead), handlers.GetScanStats)
 			}
 
 			// Protected routes (require authentication)
@@ -58,43 +97,158 @@ func SetupRoutes(r *gin.Engine) {
 				// Users
 				users := protected.Group("/users")
 				{
-					users.GET("", handlers.ListUsers)
-					users.POST("", handlers.CreateUser)
-					users.GET("/:id", handlers.GetUser)
-					users.PUT("/:id", handlers.UpdateUser)
-					users.DELETE("/:id", handlers.DeleteUser)
-					users.GET("/rfid/:cardId", handlers.GetUserByRFID)
-					users.GET("/:id/balance", handlers.GetUserBalance)
-					users.GET("/:id/transactions", handlers.GetUserTransactions)
+					users.GET("", middleware.RequirePermission(models.PermUsersRead), handlers.ListUsers)
+					users.POST("", middleware.RequirePermission(models.PermUsersWrite), handlers.CreateUser)
+					users.GET("/in-debt", middleware.RequirePermission(models.PermTransactionsRead), handlers.ListDebtors)
+					users.GET("/:id", middleware.RequirePermission(models.PermUsersRead), handlers.GetUser)
+					users.PUT("/:id", middleware.RequirePermission(models.PermUsersWrite), handlers.UpdateUser)
+					users.PUT("/:id/pin", middleware.RequirePermission(models.PermUsersWrite), handlers.SetUserPIN)
+					users.DELETE("/:id/pin", middleware.RequirePermission(models.PermUsersWrite), handlers.ClearUserPIN)
+					users.POST("/:id/pin/reset", middleware.RequirePermission(models.PermUsersWrite), handlers.ResetUserPIN)
+					users.POST("/:id/pin/unlock", middleware.RequirePermission(models.PermUsersWrite), handlers.UnlockUserPIN)
+					users.DELETE("/:id", middleware.RequirePermission(models.PermUsersDelete), handlers.DeleteUser)
+					users.GET("/rfid/:cardId", middleware.RequirePermission(models.PermUsersRead), handlers.GetUserByRFID)
+					users.GET("/:id/balance", middleware.RequirePermission(models.PermUsersRead), handlers.GetUserBalance)
+					users.GET("/:id/transactions", middleware.RequirePermission(models.PermTransactionsRead), handlers.GetUserTransactions)
+					users.POST("/:id/settle", middleware.RequirePermission(models.PermTransactionsCreate), handlers.SettleDebt)
+					users.GET("/:id/statements", middleware.RequirePermission(models.PermTransactionsRead), handlers.ListUserStatements)
+					users.GET("/:id/statements/:period", middleware.RequirePermission(models.PermTransactionsRead), handlers.GetUserStatement)
+					users.POST("/:id/statements/:period/email", middleware.RequirePermission(models.PermReportsManage), handlers.EmailUserStatement)
 				}
 
 				// Transactions
 				transactions := protected.Group("/transactions")
 				{
-					transactions.GET("", handlers.ListTransactions)
-					transactions.POST("", handlers.CreateTransaction)
-					transactions.GET("/:id", handlers.GetTransaction)
+					transactions.GET("", middleware.RequirePermission(models.PermTransactionsRead), handlers.ListTransactions)
+					transactions.POST("", middleware.RequirePermission(models.PermTransactionsCreate), handlers.CreateTransaction)
+					transactions.GET("/pending", middleware.RequirePermission(models.PermTransactionsApprove), handlers.ListPendingTransactions)
+					transactions.GET("/:id", middleware.RequirePermission(models.PermTransactionsRead), handlers.GetTransaction)
+					transactions.GET("/:id/receipt", middleware.RequirePermission(models.PermTransactionsRead), handlers.GetTransactionReceipt)
+					transactions.POST("/:id/approve", middleware.RequirePermission(models.PermTransactionsApprove), handlers.ApproveTransaction)
+					transactions.POST("/:id/reject", middleware.RequirePermission(models.PermTransactionsApprove), handlers.RejectTransaction)
 				}
 
 				// Dashboard
 				dashboard := protected.Group("/dashboard")
+				dashboard.Use(middleware.RequirePermission(models.PermDashboardRead))
 				{
 					dashboard.GET("/stats", handlers.GetDashboardStats)
 					dashboard.GET("/charts", handlers.GetChartData)
 					dashboard.GET("/recent", handlers.GetRecentActivities)
 				}
 
+				// Reports
+				reports := protected.Group("/reports")
+				{
+					reports.GET("/summary", middleware.RequirePermission(models.PermDashboardRead), handlers.GetReportSummary)
+					reports.POST("/rollups/rebuild", middleware.RequirePermission(models.PermReportsManage), handlers.RebuildRollups)
+					reports.POST("/statements/run", middleware.RequirePermission(models.PermReportsManage), handlers.RunStatements)
+				}
+
+				// Daily closeouts (Z-reports)
+				closeouts := protected.Group("/closeouts")
+				{
+					closeouts.GET("", middleware.RequirePermission(models.PermDashboardRead), handlers.ListCloseouts)
+					closeouts.GET("/preview", middleware.RequirePermission(models.PermDashboardRead), handlers.PreviewCloseout)
+					closeouts.POST("", middleware.RequirePermission(models.PermReportsManage), handlers.CreateCloseout)
+					closeouts.GET("/:date", middleware.RequirePermission(models.PermDashboardRead), handlers.GetCloseout)
+					closeouts.GET("/:date/pdf", middleware.RequirePermission(models.PermDashboardRead), handlers.GetCloseoutPDF)
+					closeouts.GET("/:date/verify", middleware.RequirePermission(models.PermDashboardRead), handlers.VerifyCloseout)
+				}
+
+				// Cash drawer sessions; admins see their own, cash:manage sees all
+				cashSessions := protected.Group("/cash-sessions")
+				{
+					cashSessions.GET("", middleware.RequirePermission(models.PermCashManage), handlers.ListCashSessions)
+					cashSessions.POST("", middleware.RequirePermission(models.PermTransactionsCreate), handlers.OpenCashSession)
+					cashSessions.GET("/current", middleware.RequirePermission(models.PermTransactionsCreate), handlers.GetCurrentCashSession)
+					cashSessions.GET("/:id", middleware.RequirePermission(models.PermTransactionsCreate), handlers.GetCashSession)
+					cashSessions.GET("/:id/pdf", middleware.RequirePermission(models.PermTransactionsCreate), handlers.GetCashSessionPDF)
+					cashSessions.POST("/:id/close", middleware.RequirePermission(models.PermTransactionsCreate), handlers.CloseCashSession)
+				}
+
+				// User groups with shared spending limits
+				userGroups := protected.Group("/user-groups")
+				{
+					userGroups.GET("", middleware.RequirePermission(models.PermUsersRead), handlers.ListUserGroups)
+					userGroups.POST("", middleware.RequirePermission(models.PermUsersWrite), handlers.CreateUserGroup)
+					userGroups.GET("/:id", middleware.RequirePermission(models.PermUsersRead), handlers.GetUserGroup)
+					userGroups.PUT("/:id", middleware.RequirePermission(models.PermUsersWrite), handlers.UpdateUserGroup)
+					userGroups.DELETE("/:id", middleware.RequirePermission(models.PermUsersWrite), handlers.DeleteUserGroup)
+				}
+
+				// Top-up requests filed by card holders
+				topUps := protected.Group("/topup-requests")
+				{
+					topUps.GET("", middleware.RequirePermission(models.PermTransactionsRead), handlers.ListTopUpRequests)
+					topUps.GET("/:id", middleware.RequirePermission(models.PermTransactionsRead), handlers.GetTopUpRequest)
+					topUps.POST("/:id/approve", middleware.RequirePermission(models.PermTransactionsCreate), handlers.ApproveTopUpRequest)
+					topUps.POST("/:id/reject", middleware.RequirePermission(models.PermTransactionsCreate), handlers.RejectTopUpRequest)
+				}
+
+				// Debt repayments
+				protected.GET("/settlements", middleware.RequirePermission(models.PermTransactionsRead), handlers.ListSettlements)
+
+				// Automation devices and where they stand
+				devices := protected.Group("/devices")
+				{
+					devices.GET("", middleware.RequirePermission(models.PermAutomationRead), handlers.ListDevices)
+					devices.POST("", middleware.RequirePermission(models.PermDevicesManage), handlers.CreateDevice)
+					devices.GET("/:id", middleware.RequirePermission(models.PermAutomationRead), handlers.GetDevice)
+					devices.PUT("/:id", middleware.RequirePermission(models.PermDevicesManage), handlers.UpdateDevice)
+					devices.DELETE("/:id", middleware.RequirePermission(models.PermDevicesManage), handlers.DeleteDevice)
+				}
+
+				// Alerts raised by the fraud rules
+				fraudAlerts := protected.Group("/fraud/alerts")
+				{
+					fraudAlerts.GET("", middleware.RequirePermission(models.PermAutomationRead), handlers.ListFraudAlerts)
+					fraudAlerts.GET("/:id", middleware.RequirePermission(models.PermAutomationRead), handlers.GetFraudAlert)
+					fraudAlerts.POST("/:id/review", middleware.RequirePermission(models.PermAlertsManage), handlers.ReviewFraudAlert)
+				}
+
 				// Logs
-				protected.GET("/logs", handlers.ListLogs)
+				protected.GET("/logs", middleware.RequirePermission(models.PermLogsRead), handlers.ListLogs)
+				protected.GET("/logs/queue", middleware.RequirePermission(models.PermLogsRead), handlers.GetLogQueueStats)
 
-				// Admins (super admin only)
+				// Hash chain
+				chain := protected.Group("/chain")
+				chain.Use(middleware.RequirePermission(models.PermLogsRead))
+				{
+					chain.GET("/verify", handlers.VerifyChain)
+					chain.GET("/checkpoints", handlers.ListChainCheckpoints)
+				}
+
+				// Admins
 				admins := protected.Group("/admins")
-				admins.Use(middleware.SuperAdminOnly())
 				{
-					admins.GET("", handlers.ListAdmins)
-					admins.POST("", handlers.CreateAdmin)
-					admins.GET("/:id", handlers.GetAdmin)
-					admins.DELETE("/:id", handlers.DeleteAdmin)
+					admins.GET("", middleware.RequirePermission(models.PermAdminsRead), handlers.ListAdmins)
+					admins.POST("", middleware.RequirePermission(models.PermAdminsManage), handlers.CreateAdmin)
+					admins.GET("/:id", middleware.RequirePermission(models.PermAdminsRead), handlers.GetAdmin)
+					admins.PUT("/:id", middleware.RequirePermission(models.PermAdminsManage), handlers.UpdateAdmin)
+					admins.DELETE("/:id", middleware.RequirePermission(models.PermAdminsManage), handlers.DeleteAdmin)
+					admins.POST("/:id/restore", middleware.RequirePermission(models.PermAdminsManage), handlers.RestoreAdmin)
+				}
+
+				// Roles
+				roles := protected.Group("/roles")
+				{
+					roles.GET("", middleware.RequirePermission(models.PermRolesRead), handlers.ListRoles)
+					roles.GET("/permissions", middleware.RequirePermission(models.PermRolesRead), handlers.ListPermissions)
+					roles.POST("", middleware.RequirePermission(models.PermRolesManage), handlers.CreateRole)
+					roles.GET("/:id", middleware.RequirePermission(models.PermRolesRead), handlers.GetRole)
+					roles.PUT("/:id", middleware.RequirePermission(models.PermRolesManage), handlers.UpdateRole)
+					roles.DELETE("/:id", middleware.RequirePermission(models.PermRolesManage), handlers.DeleteRole)
+				}
+
+				// API keys
+				apiKeys := protected.Group("/api-keys")
+				apiKeys.Use(middleware.RequirePermission(models.PermAPIKeysManage))
+				{
+					apiKeys.GET("", handlers.ListAPIKeys)
+					apiKeys.POST("", handlers.CreateAPIKey)
+					apiKeys.GET("/:id", handlers.GetAPIKey)
+					apiKeys.DELETE("/:id", handlers.RevokeAPIKey)
 				}
 			}
 		}
@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lazypwny751/hudautomata/pkg/config"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/utils"
+	"gorm.io/gorm/logger"
+)
+
+// setupAPIKey stores a key that may list users from 10.0.0.1 only
+func setupAPIKey(t *testing.T) string {
+	t.Helper()
+
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	config.Load()
+	database.LogLevel = logger.Silent
+	if err := database.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, prefix, hash, err := utils.GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := models.APIKey{
+		Name:       "kiosk",
+		Prefix:     prefix,
+		KeyHash:    hash,
+		Scopes:     models.PermissionList{models.PermUsersRead},
+		AllowedIPs: models.StringList{"10.0.0.1"},
+	}
+	if err := database.DB.Create(&key).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	return plain
+}
+
+func TestAPIKeyAllowlist(t *testing.T) {
+	key := setupAPIKey(t)
+
+	tests := []struct {
+		name    string
+		proxies []string
+		remote  string
+		headers map[string]string
+		want    int
+	}{
+		{"allowed address", nil, "10.0.0.1:4000", nil, http.StatusOK},
+		{"other address", nil, "192.0.2.7:4000", nil, http.StatusForbidden},
+		{"spoofed X-Forwarded-For", nil, "192.0.2.7:4000", map[string]string{"X-Forwarded-For": "10.0.0.1"}, http.StatusForbidden},
+		{"spoofed X-Real-IP", nil, "192.0.2.7:4000", map[string]string{"X-Real-IP": "10.0.0.1"}, http.StatusForbidden},
+		{"forwarded by a trusted proxy", []string{"192.0.2.0/24"}, "192.0.2.7:4000", map[string]string{"X-Forwarded-For": "10.0.0.1"}, http.StatusOK},
+		{"spoofed behind a trusted proxy", []string{"192.0.2.0/24"}, "192.0.2.7:4000", map[string]string{"X-Forwarded-For": "192.0.2.9"}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRouter(tt.proxies)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+			req.RemoteAddr = tt.remote
+			req.Header.Set("X-API-Key", key)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package spending
+
+import (
+	"time"
+
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Limits are the spending limits that apply to a user; nil means no limit
+type Limits struct {
+	Daily        *float64
+	Weekly       *float64
+	ScansPerHour *int
+}
+
+// None reports whether no limit applies
+func (l Limits) None() bool {
+	return l.Daily == nil && l.Weekly == nil && l.ScansPerHour == nil
+}
+
+// Resolve returns the user's limits. Limits set on the user win over the
+// ones of the user's group.
+func Resolve(db *gorm.DB, user models.User) (Limits, error) {
+	limits := Limits{
+		Daily:        user.DailySpendLimit,
+		Weekly:       user.WeeklySpendLimit,
+		ScansPerHour: user.HourlyScanLimit,
+	}
+	if user.GroupID == nil {
+		return limits, nil
+	}
+
+	var group models.UserGroup
+	if err := db.First(&group, "id = ?", *user.GroupID).Error; err != nil {
+		return limits, err
+	}
+
+	if limits.Daily == nil {
+		limits.Daily = group.DailySpendLimit
+	}
+	if limits.Weekly == nil {
+		limits.Weekly = group.WeeklySpendLimit
+	}
+	if limits.ScansPerHour == nil {
+		limits.ScansPerHour = group.HourlyScanLimit
+	}
+	return limits, nil
+}
+
+// Usage is what counts against the limits: completed device charges in the
+// business day and week (weeks start on Monday) and in the past hour
+type Usage struct {
+	Day           float64
+	Week          float64
+	ScansLastHour int64
+}
+
+// Measure returns the user's usage at now; days and weeks follow loc
+func Measure(db *gorm.DB, user models.User, now time.Time, loc *time.Location) (Usage, error) {
+	var usage Usage
+
+	dayStart, err := database.BucketStart(now, database.BucketDay, loc)
+	if err != nil {
+		return usage, err
+	}
+	weekStart, err := database.BucketStart(now, database.BucketWeek, loc)
+	if err != nil {
+		return usage, err
+	}
+
+	charges := func() *gorm.DB {
+		return db.Model(&models.Transaction{}).
+			Where("user_id = ? AND type = ? AND source = ? AND status = ?",
+				user.ID, models.TypeDebit, models.SourceAutomation, models.StatusCompleted)
+	}
+
+	// Timestamps go in as UTC; SQLite compares them as text
+	if err := charges().Where("created_at >= ?", dayStart.UTC()).
+		Select("COALESCE(SUM(amount), 0)").Scan(&usage.Day).Error; err != nil {
+		return usage, err
+	}
+	if err := charges().Where("created_at >= ?", weekStart.UTC()).
+		Select("COALESCE(SUM(amount), 0)").Scan(&usage.Week).Error; err != nil {
+		return usage, err
+	}
+	if err := charges().Where("created_at >= ?", now.Add(-time.Hour).UTC()).
+		Count(&usage.ScansLastHour).Error; err != nil {
+		return usage, err
+	}
+
+	return usage, nil
+}
+
+// Check returns the outcome that denies a charge of cost, or "" when the
+// limits allow it. For amount limits it also returns what is left of the
+// limit that denied it.
+func Check(l Limits, u Usage, cost float64) (models.ScanOutcome, *float64) {
+	if l.ScansPerHour != nil && u.ScansLastHour >= int64(*l.ScansPerHour) {
+		return models.ScanRateLimit, nil
+	}
+	if l.Daily != nil && u.Day+cost > *l.Daily {
+		return models.ScanDailyLimit, remaining(*l.Daily, u.Day)
+	}
+	if l.Weekly != nil && u.Week+cost > *l.Weekly {
+		return models.ScanWeeklyLimit, remaining(*l.Weekly, u.Week)
+	}
+	return "", nil
+}
+
+func remaining(limit, used float64) *float64 {
+	left := limit - used
+	if left < 0 {
+		left = 0
+	}
+	return &left
+}
+
+// Allowance is one limit with what has been used of it and what is left
+type Allowance struct {
+	Limit     float64 `json:"limit"`
+	Used      float64 `json:"used"`
+	Remaining float64 `json:"remaining"`
+}
+
+// Status is the allowance left under each limit that applies
+type Status struct {
+	Daily        *Allowance `json:"daily,omitempty"`
+	Weekly       *Allowance `json:"weekly,omitempty"`
+	ScansPerHour *Allowance `json:"scans_per_hour,omitempty"`
+}
+
+// Allowances reports each limit that applies with the usage against it
+func Allowances(l Limits, u Usage) Status {
+	var s Status
+	if l.Daily != nil {
+		s.Daily = allowance(*l.Daily, u.Day)
+	}
+	if l.Weekly != nil {
+		s.Weekly = allowance(*l.Weekly, u.Week)
+	}
+	if l.ScansPerHour != nil {
+		s.ScansPerHour = allowance(float64(*l.ScansPerHour), float64(u.ScansLastHour))
+	}
+	return s
+}
+
+func allowance(limit, used float64) *Allowance {
+	return &Allowance{Limit: limit, Used: used, Remaining: *remaining(limit, used)}
+}
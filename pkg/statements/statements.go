@@ -0,0 +1,445 @@
+package statements
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lazypwny751/hudautomata/pkg/mailer"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/pdf"
+	"github.com/lazypwny751/hudautomata/pkg/receipt"
+	"github.com/lazypwny751/hudautomata/pkg/reports"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidPeriod is returned for a period not in YYYY-MM form
+	ErrInvalidPeriod = errors.New("period must be YYYY-MM")
+	// ErrPeriodNotOver is returned when storing the statement of a month that has not ended
+	ErrPeriodNotOver = errors.New("statement period has not ended yet")
+)
+
+// periodLayout is the form of a statement period
+const periodLayout = "2006-01"
+
+// Statement is the content of a user's account statement for one month of
+// the business time zone. Transactions count on the day they were booked,
+// as in the daily closeouts.
+type Statement struct {
+	UserID         uuid.UUID  `json:"user_id"`
+	UserName       string     `json:"user_name"`
+	Card           string     `json:"card"`
+	Period         string     `json:"period"`
+	Timezone       string     `json:"timezone"`
+	From           time.Time  `json:"from"`
+	To             time.Time  `json:"to"`
+	OpeningBalance float64    `json:"opening_balance"`
+	TotalCredits   float64    `json:"total_credits"`
+	TotalDebits    float64    `json:"total_debits"`
+	TotalRefunds   float64    `json:"total_refunds"`
+	OtherChanges   float64    `json:"other_changes"`
+	ClosingBalance float64    `json:"closing_balance"`
+	Categories     []Category `json:"categories"`
+	Lines          []Line     `json:"lines"`
+}
+
+// Category totals the transactions of one kind: top-ups, refunds, or
+// charges per service
+type Category struct {
+	Name    string                 `json:"name"`
+	Type    models.TransactionType `json:"type"`
+	Service string                 `json:"service,omitempty"`
+	Count   int64                  `json:"count"`
+	Amount  float64                `json:"amount"`
+}
+
+// Line is one transaction on the statement. Amount is signed: negative
+// for charges.
+type Line struct {
+	Time          time.Time                `json:"time"`
+	TransactionID uuid.UUID                `json:"transaction_id"`
+	Type          models.TransactionType   `json:"type"`
+	Source        models.TransactionSource `json:"source"`
+	DeviceID      string                   `json:"device_id,omitempty"`
+	Service       string                   `json:"service,omitempty"`
+	Description   string                   `json:"description"`
+	Amount        float64                  `json:"amount"`
+	Balance       float64                  `json:"balance"`
+}
+
+// Period returns the UTC range of a YYYY-MM month in loc
+func Period(period string, loc *time.Location) (time.Time, time.Time, error) {
+	month, err := time.ParseInLocation(periodLayout, period, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, ErrInvalidPeriod
+	}
+	return month.UTC(), month.AddDate(0, 1, 0).UTC(), nil
+}
+
+// LastPeriod returns the month before the one now falls in
+func LastPeriod(now time.Time, loc *time.Location) string {
+	local := now.In(loc)
+	month := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, loc)
+	return month.AddDate(0, -1, 0).Format(periodLayout)
+}
+
+// Build computes a user's statement without storing it. The current month
+// gives a statement to date.
+func Build(db *gorm.DB, user models.User, period string) (*Statement, error) {
+	loc := reports.Location
+	from, to, err := Period(period, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &Statement{
+		UserID:   user.ID,
+		UserName: user.Name,
+		Card:     receipt.MaskCard(user.RFIDCardID),
+		Period:   period,
+		Timezone: loc.String(),
+		From:     from.In(loc),
+		To:       to.In(loc),
+	}
+
+	booked := "COALESCE(resolved_at, created_at)"
+	var transactions []models.Transaction
+	err = db.Where("user_id = ? AND status = ?", user.ID, models.StatusCompleted).
+		Where(booked+" >= ? AND "+booked+" < ?", from, to).
+		Order(booked + ", id").
+		Find(&transactions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Both balances come from one statement so they share a snapshot: the
+	// current balance minus everything booked since the start or end
+	var balances struct {
+		Balance    float64
+		SinceStart float64
+		SinceEnd   float64
+	}
+	signed := "CASE WHEN type IN ('credit', 'refund') THEN amount ELSE -amount END"
+	err = db.Raw(`SELECT
+		(SELECT balance FROM users WHERE id = ?) AS balance,
+		(SELECT COALESCE(SUM(`+signed+`), 0) FROM transactions WHERE user_id = ? AND status = ? AND `+booked+` >= ?) AS since_start,
+		(SELECT COALESCE(SUM(`+signed+`), 0) FROM transactions WHERE user_id = ? AND status = ? AND `+booked+` >= ?) AS since_end`,
+		user.ID, user.ID, models.StatusCompleted, from, user.ID, models.StatusCompleted, to).
+		Scan(&balances).Error
+	if err != nil {
+		return nil, err
+	}
+	st.OpeningBalance = round(balances.Balance - balances.SinceStart)
+	st.ClosingBalance = round(balances.Balance - balances.SinceEnd)
+
+	categories := map[string]*Category{}
+	balance := st.OpeningBalance
+	for _, t := range transactions {
+		amount := t.Amount
+		switch t.Type {
+		case models.TypeCredit:
+			st.TotalCredits = round(st.TotalCredits + t.Amount)
+		case models.TypeRefund:
+			st.TotalRefunds = round(st.TotalRefunds + t.Amount)
+		case models.TypeDebit:
+			st.TotalDebits = round(st.TotalDebits + t.Amount)
+			amount = -amount
+		}
+		balance = round(balance + amount)
+
+		at := t.CreatedAt
+		if t.ResolvedAt != nil {
+			at = *t.ResolvedAt
+		}
+		st.Lines = append(st.Lines, Line{
+			Time:          at.In(loc),
+			TransactionID: t.ID,
+			Type:          t.Type,
+			Source:        t.Source,
+			DeviceID:      t.DeviceID,
+			Service:       t.Service,
+			Description:   t.Description,
+			Amount:        amount,
+			Balance:       balance,
+		})
+
+		c := categoryOf(t)
+		if existing, ok := categories[c.Name]; ok {
+			c = existing
+		} else {
+			categories[c.Name] = c
+		}
+		c.Count++
+		c.Amount = round(c.Amount + t.Amount)
+	}
+
+	// Balances set outside transactions, e.g. edited by an admin
+	st.OtherChanges = round(st.ClosingBalance - balance)
+
+	st.Categories = make([]Category, 0, len(categories))
+	for _, c := range categories {
+		st.Categories = append(st.Categories, *c)
+	}
+	order := map[models.TransactionType]int{models.TypeCredit: 0, models.TypeRefund: 1, models.TypeDebit: 2}
+	sort.Slice(st.Categories, func(i, j int) bool {
+		a, b := st.Categories[i], st.Categories[j]
+		if a.Type != b.Type {
+			return order[a.Type] < order[b.Type]
+		}
+		return a.Name < b.Name
+	})
+
+	return st, nil
+}
+
+func categoryOf(t models.Transaction) *Category {
+	switch {
+	case t.Type == models.TypeCredit:
+		return &Category{Name: "Top-ups", Type: t.Type}
+	case t.Type == models.TypeRefund:
+		return &Category{Name: "Refunds", Type: t.Type}
+	case t.Service != "":
+		return &Category{Name: "Charges: " + t.Service, Type: t.Type, Service: t.Service}
+	default:
+		return &Category{Name: "Charges", Type: t.Type}
+	}
+}
+
+// PDF renders the statement on A4 pages
+func PDF(st *Statement) []byte {
+	doc := pdf.New("Statement "+st.Period+" "+st.UserName, pdf.Options{})
+	width := doc.Columns()
+	row := func(label string, amount float64) {
+		value := fmt.Sprintf("%.2f", amount)
+		doc.Line("%-*s%s", width-len(value), label, value)
+	}
+
+	doc.Heading("ACCOUNT STATEMENT %s", st.Period)
+	doc.Line("Name:    %s", st.UserName)
+	doc.Line("Card:    %s", st.Card)
+	doc.Line("Period:  %s - %s (%s)", st.From.Format("2006-01-02"), st.To.AddDate(0, 0, -1).Format("2006-01-02"), st.Timezone)
+	doc.Rule()
+	row("Opening balance", st.OpeningBalance)
+	row("Top-ups", st.TotalCredits)
+	row("Refunds", st.TotalRefunds)
+	row("Charges", -st.TotalDebits)
+	if st.OtherChanges != 0 {
+		row("Other changes", st.OtherChanges)
+	}
+	row("Closing balance", st.ClosingBalance)
+	doc.Blank()
+
+	doc.Heading("By category")
+	for _, c := range st.Categories {
+		row(fmt.Sprintf("  %s (%d)", c.Name, c.Count), c.Amount)
+	}
+	doc.Blank()
+
+	// Date, type and source are fixed; the description takes the rest
+	descWidth := width - 16 - 7 - 11 - 10 - 10 - 5
+	doc.Heading("%-16s %-7s %-11s %-*s %10s %10s", "Date", "Type", "Source", descWidth, "Description", "Amount", "Balance")
+	for _, l := range st.Lines {
+		desc := l.Description
+		if l.Service != "" {
+			desc = l.Service + " " + desc
+		}
+		if r := []rune(desc); len(r) > descWidth {
+			desc = string(r[:descWidth])
+		}
+		doc.Line("%-16s %-7s %-11s %-*s %10.2f %10.2f",
+			l.Time.Format("2006-01-02 15:04"), l.Type, l.Source, descWidth, desc, l.Amount, l.Balance)
+	}
+	if len(st.Lines) == 0 {
+		doc.Line("No transactions in this period")
+	}
+
+	return doc.Bytes()
+}
+
+// CSV renders the statement lines, one transaction per row
+func CSV(st *Statement) []byte {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write([]string{"time", "transaction_id", "type", "source", "device_id", "service", "description", "amount", "balance"})
+	for _, l := range st.Lines {
+		w.Write([]string{
+			l.Time.Format(time.RFC3339),
+			l.TransactionID.String(),
+			string(l.Type),
+			string(l.Source),
+			l.DeviceID,
+			l.Service,
+			l.Description,
+			strconv.FormatFloat(l.Amount, 'f', 2, 64),
+			strconv.FormatFloat(l.Balance, 'f', 2, 64),
+		})
+	}
+	w.Flush()
+	return b.Bytes()
+}
+
+// Generate stores the statement of an ended month, returning the stored
+// one if it already exists. created reports whether it was stored now.
+func Generate(db *gorm.DB, user models.User, period string) (stored *models.Statement, created bool, err error) {
+	_, to, err := Period(period, reports.Location)
+	if err != nil {
+		return nil, false, err
+	}
+	if to.After(time.Now()) {
+		return nil, false, ErrPeriodNotOver
+	}
+
+	stored, err = findStatement(db, user, period)
+	if err == nil {
+		return stored, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, err
+	}
+
+	st, err := Build(db, user, period)
+	if err != nil {
+		return nil, false, err
+	}
+
+	stored = &models.Statement{
+		UserID:           user.ID,
+		Period:           period,
+		Timezone:         st.Timezone,
+		PeriodStart:      st.From.UTC(),
+		PeriodEnd:        st.To.UTC(),
+		OpeningBalance:   st.OpeningBalance,
+		ClosingBalance:   st.ClosingBalance,
+		TotalCredits:     st.TotalCredits,
+		TotalDebits:      st.TotalDebits,
+		TotalRefunds:     st.TotalRefunds,
+		TransactionCount: int64(len(st.Lines)),
+		PDF:              PDF(st),
+		CSV:              CSV(st),
+	}
+
+	// A concurrent run may have stored it first; keep that one
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(stored)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return stored, true, nil
+	}
+	stored, err = findStatement(db, user, period)
+	return stored, false, err
+}
+
+func findStatement(db *gorm.DB, user models.User, period string) (*models.Statement, error) {
+	var stored models.Statement
+	err := db.Where("user_id = ? AND period = ?", user.ID, period).First(&stored).Error
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// Email sends a stored statement to the user's e-mail address with its PDF
+// and CSV attached, and records the outcome on the statement
+func Email(db *gorm.DB, m *mailer.Mailer, user models.User, stored *models.Statement) error {
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Account statement " + stored.Period,
+		Body: fmt.Sprintf("Hello %s,\n\nyour account statement for %s is attached.\n\nOpening balance: %.2f\nClosing balance: %.2f\n",
+			user.Name, stored.Period, stored.OpeningBalance, stored.ClosingBalance),
+		Attachments: []mailer.Attachment{
+			{Filename: "statement-" + stored.Period + ".pdf", ContentType: "application/pdf", Data: stored.PDF},
+			{Filename: "statement-" + stored.Period + ".csv", ContentType: "text/csv", Data: stored.CSV},
+		},
+	}
+
+	sendErr := m.Send(msg)
+	if sendErr != nil {
+		stored.EmailError = sendErr.Error()
+	} else {
+		now := time.Now().UTC()
+		stored.EmailedTo = user.Email
+		stored.EmailedAt = &now
+		stored.EmailError = ""
+	}
+
+	err := db.Model(stored).Select("emailed_to", "emailed_at", "email_error").Updates(stored).Error
+	if err != nil {
+		return err
+	}
+	return sendErr
+}
+
+// RunResult counts what a batch run did
+type RunResult struct {
+	Period      string `json:"period"`
+	Users       int    `json:"users"`
+	Stored      int    `json:"stored"`
+	Created     int    `json:"created"`
+	Emailed     int    `json:"emailed"`
+	EmailFailed int    `json:"email_failed"`
+	Failed      int    `json:"failed"`
+}
+
+// Run stores the statement of an ended month for every active user that
+// existed in it and, with email, sends those not sent yet to users with an
+// e-mail address. Failures are logged and counted; the run goes on.
+func Run(db *gorm.DB, period string, email bool, m *mailer.Mailer) (RunResult, error) {
+	result := RunResult{Period: period}
+
+	_, to, err := Period(period, reports.Location)
+	if err != nil {
+		return result, err
+	}
+	if to.After(time.Now()) {
+		return result, ErrPeriodNotOver
+	}
+	if email && m == nil {
+		return result, mailer.ErrNotConfigured
+	}
+
+	var users []models.User
+	err = db.Where("is_active = ? AND created_at < ?", true, to).
+		FindInBatches(&users, 100, func(tx *gorm.DB, batch int) error {
+			for _, user := range users {
+				result.Users++
+
+				stored, created, err := Generate(db, user, period)
+				if err != nil {
+					result.Failed++
+					log.Printf("statements: %s for user %s: %v", period, user.ID, err)
+					continue
+				}
+				result.Stored++
+				if created {
+					result.Created++
+				}
+
+				if !email || user.Email == "" || stored.EmailedAt != nil {
+					continue
+				}
+				if err := Email(db, m, user, stored); err != nil {
+					result.EmailFailed++
+					log.Printf("statements: e-mailing %s to user %s: %v", period, user.ID, err)
+					continue
+				}
+				result.Emailed++
+			}
+			return nil
+		}).Error
+
+	return result, err
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
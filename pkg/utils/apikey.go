@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+)
+
+const apiKeyPrefix = "hud_"
+
+// GenerateAPIKey returns a new random API key, its display prefix and its hash
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	buf := make([]byte, 24)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+
+	key = apiKeyPrefix + hex.EncodeToString(buf)
+	return key, key[:len(apiKeyPrefix)+8], HashAPIKey(key), nil
+}
+
+// HashAPIKey hashes an API key for storage and lookup
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IPAllowed reports whether ip matches one of the allowed IPs or CIDR ranges.
+// An empty allowlist allows every address.
+func IPAllowed(ip string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, entry := range allowed {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(parsed) {
+				return true
+			}
+			continue
+		}
+		if allowedIP := net.ParseIP(entry); allowedIP != nil && allowedIP.Equal(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidIPEntry reports whether entry is an IP address or CIDR range
+func ValidIPEntry(entry string) bool {
+	if strings.Contains(entry, "/") {
+		_, _, err := net.ParseCIDR(entry)
+		return err == nil
+	}
+	return net.ParseIP(entry) != nil
+}
@@ -11,9 +11,10 @@ import (
 
 // Claims represents JWT claims
 type Claims struct {
-	AdminID  uuid.UUID `json:"admin_id"`
-	Username string    `json:"username"`
-	Role     string    `json:"role"`
+	AdminID     uuid.UUID `json:"admin_id"`
+	Username    string    `json:"username"`
+	Role        string    `json:"role"`
+	Permissions []string  `json:"permissions"`
 	jwt.RegisteredClaims
 }
 
@@ -29,14 +30,15 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-// GenerateToken generates a JWT token
-func GenerateToken(adminID uuid.UUID, username, role string) (string, time.Time, error) {
+// GenerateToken generates a JWT token carrying the permissions of the admin's role
+func GenerateToken(adminID uuid.UUID, username, role string, permissions []string) (string, time.Time, error) {
 	expirationTime := time.Now().Add(24 * time.Hour)
 	
 	claims := &Claims{
-		AdminID:  adminID,
-		Username: username,
-		Role:     role,
+		AdminID:     adminID,
+		Username:    username,
+		Role:        role,
+		Permissions: permissions,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -68,3 +70,59 @@ func ValidateToken(tokenString string) (*Claims, error) {
 
 	return claims, nil
 }
+
+// UserClaims represents the JWT claims of a user portal session. They are
+// signed with PORTAL_JWT_SECRET, so a portal token is never accepted as an
+// admin token or the other way round.
+type UserClaims struct {
+	UserID uuid.UUID `json:"user_id"`
+	Method string    `json:"method"`
+	// PINChangeOnly restricts the session to changing the PIN, for a login
+	// with a temporary PIN
+	PINChangeOnly bool `json:"pin_change_only,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// portalIssuer is the issuer of portal tokens
+const portalIssuer = "hudautomata-portal"
+
+// GenerateUserToken generates a portal token for a user; method records how
+// the user logged in (pin or link)
+func GenerateUserToken(userID uuid.UUID, method string, pinChangeOnly bool) (string, time.Time, error) {
+	expirationTime := time.Now().Add(config.AppConfig.PortalTokenExpiration)
+
+	claims := &UserClaims{
+		UserID:        userID,
+		Method:        method,
+		PINChangeOnly: pinChangeOnly,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    portalIssuer,
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte(config.AppConfig.PortalJWTSecret))
+
+	return tokenString, expirationTime, err
+}
+
+// ValidateUserToken validates a portal token and returns its claims
+func ValidateUserToken(tokenString string) (*UserClaims, error) {
+	claims := &UserClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(config.AppConfig.PortalJWTSecret), nil
+	}, jwt.WithIssuer(portalIssuer), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, jwt.ErrSignatureInvalid
+	}
+
+	return claims, nil
+}
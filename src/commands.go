@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lazypwny751/hudautomata/pkg/archive"
+	"github.com/lazypwny751/hudautomata/pkg/chain"
+	"github.com/lazypwny751/hudautomata/pkg/config"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/models"
+	"github.com/lazypwny751/hudautomata/pkg/reports"
+	"github.com/lazypwny751/hudautomata/pkg/statements"
+)
+
+const usage = `commands:
+  migrate                      migrate the database schema
+  seed                         create built-in roles and the default admin
+  verify-chain                 verify the log and transaction hash chains
+  rollups rebuild              recompute the transaction rollups
+  closeout [YYYY-MM-DD]        close a business day, or every ended day when none is given
+  statements [YYYY-MM]         store the monthly statements of all active users, last month by default
+  archive run                  archive logs past their retention now
+  archive search [filters]     print matching archived logs as NDJSON
+  archive restore [filters]    copy matching archived logs into restored_logs
+  archive verify               check archive files against their prune records
+
+filters: -category -action -resource -resource-id -admin-id -contains -from -to (RFC 3339)`
+
+// runCommand runs a maintenance command instead of the server and returns the exit code
+func runCommand(cfg *config.Config, retention archive.Policy, args []string) int {
+	switch args[0] {
+	case "migrate":
+		// Connect already migrated the schema
+		return 0
+	case "seed":
+		if err := database.SeedData(); err != nil {
+			fmt.Fprintf(os.Stderr, "seed: %v\n", err)
+			return 1
+		}
+		return 0
+	case "verify-chain":
+		return verifyChain()
+	case "rollups":
+		if len(args) < 2 || args[1] != "rebuild" {
+			break
+		}
+		rows, err := reports.RebuildRollups(database.DB)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rebuild: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "rebuilt %d rollups for %s\n", rows, reports.Location)
+		return 0
+	case "closeout":
+		if len(args) < 2 {
+			if err := closeDays(); err != nil {
+				fmt.Fprintf(os.Stderr, "closeout: %v\n", err)
+				return 1
+			}
+			return 0
+		}
+		closeout, err := reports.CloseDay(database.DB, args[1], nil, "system")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "closeout: %v\n", err)
+			return 1
+		}
+		printJSON(closeout)
+		return 0
+	case "statements":
+		period := statements.LastPeriod(time.Now(), reports.Location)
+		if len(args) > 1 {
+			period = args[1]
+		}
+		if err := runStatements(period, cfg.StatementsEmail); err != nil {
+			fmt.Fprintf(os.Stderr, "statements: %v\n", err)
+			return 1
+		}
+		return 0
+	case "archive":
+		if len(args) < 2 {
+			break
+		}
+		return archiveCommand(cfg, retention, args[1], args[2:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+	}
+
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+// verifyChain verifies every hash chain and prints the reports as JSON
+func verifyChain() int {
+	ok := true
+	reports := make([]chain.Report, 0, len(chain.All))
+
+	for _, ch := range chain.All {
+		report, err := ch.Verify(database.DB)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "verifying %s: %v\n", ch.Name, err)
+			return 2
+		}
+		ok = ok && report.OK
+		reports = append(reports, report)
+	}
+
+	printJSON(reports)
+
+	if !ok {
+		fmt.Fprintln(os.Stderr, "chain verification FAILED")
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "chain verification OK")
+	return 0
+}
+
+func archiveCommand(cfg *config.Config, retention archive.Policy, sub string, args []string) int {
+	switch sub {
+	case "run":
+		if err := archiveLogs(cfg, retention); err != nil {
+			fmt.Fprintf(os.Stderr, "archive: %v\n", err)
+			return 1
+		}
+		return 0
+
+	case "search", "restore":
+		filter, err := parseFilter(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		if sub == "restore" {
+			restored, err := archive.Restore(database.DB, cfg.LogArchiveDir, filter)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "restore: %v\n", err)
+				return 1
+			}
+			fmt.Fprintf(os.Stderr, "restored %d logs, browse them with /api/v1/logs?archive=true\n", restored)
+			return 0
+		}
+
+		out := json.NewEncoder(os.Stdout)
+		err = archive.Search(cfg.LogArchiveDir, filter, func(l models.SystemLog) error {
+			return out.Encode(l)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "search: %v\n", err)
+			return 1
+		}
+		return 0
+
+	case "verify":
+		checks, err := archive.VerifyFiles(database.DB, cfg.LogArchiveDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "verify: %v\n", err)
+			return 2
+		}
+		printJSON(checks)
+
+		for _, check := range checks {
+			if !check.OK {
+				fmt.Fprintln(os.Stderr, "archive verification FAILED")
+				return 1
+			}
+		}
+		fmt.Fprintln(os.Stderr, "archive verification OK")
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown archive command %q\n", sub)
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+func parseFilter(args []string) (archive.Filter, error) {
+	var filter archive.Filter
+	var from, to string
+
+	flags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	flags.StringVar(&filter.Category, "category", "", "log category")
+	flags.StringVar(&filter.Action, "action", "", "action contains")
+	flags.StringVar(&filter.Resource, "resource", "", "resource type")
+	flags.StringVar(&filter.ResourceID, "resource-id", "", "resource ID")
+	flags.StringVar(&filter.AdminID, "admin-id", "", "admin ID")
+	flags.StringVar(&filter.Contains, "contains", "", "details contain")
+	flags.StringVar(&from, "from", "", "created at or after (RFC 3339)")
+	flags.StringVar(&to, "to", "", "created at or before (RFC 3339)")
+
+	if err := flags.Parse(args); err != nil {
+		return filter, err
+	}
+
+	var err error
+	if from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return filter, fmt.Errorf("invalid -from: %w", err)
+		}
+	}
+	if to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, fmt.Errorf("invalid -to: %w", err)
+		}
+	}
+	return filter, nil
+}
+
+func printJSON(v interface{}) {
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	out.Encode(v)
+}
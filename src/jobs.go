@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lazypwny751/hudautomata/pkg/archive"
+	"github.com/lazypwny751/hudautomata/pkg/chain"
+	"github.com/lazypwny751/hudautomata/pkg/config"
+	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/jobs"
+	"github.com/lazypwny751/hudautomata/pkg/mailer"
+	"github.com/lazypwny751/hudautomata/pkg/reports"
+	"github.com/lazypwny751/hudautomata/pkg/statements"
+)
+
+// startJobs schedules the background jobs; they stop when ctx is cancelled
+func startJobs(ctx context.Context, cfg *config.Config, retention archive.Policy) *jobs.Scheduler {
+	scheduler := jobs.New(ctx)
+
+	scheduler.Every("chain-seal", cfg.ChainSealInterval, func(ctx context.Context) error {
+		return sealChains()
+	})
+
+	scheduler.Every("chain-checkpoint", cfg.ChainCheckpointInterval, func(ctx context.Context) error {
+		if err := sealChains(); err != nil {
+			return err
+		}
+		for _, ch := range chain.All {
+			cp, err := ch.Checkpoint(database.DB)
+			if err != nil {
+				return err
+			}
+			if cp != nil {
+				log.Printf("Checkpoint %s at seq %d", cp.Chain, cp.Seq)
+			}
+		}
+		return nil
+	})
+
+	scheduler.Every("log-retention", cfg.LogRetentionInterval, func(ctx context.Context) error {
+		return archiveLogs(cfg, retention)
+	})
+
+	if cfg.CloseoutEnabled {
+		scheduler.Every("daily-closeout", cfg.CloseoutInterval, func(ctx context.Context) error {
+			return closeDays()
+		})
+	}
+
+	if cfg.StatementsEnabled {
+		scheduler.Every("monthly-statements", cfg.StatementsInterval, func(ctx context.Context) error {
+			return runStatements(statements.LastPeriod(time.Now(), reports.Location), cfg.StatementsEmail)
+		})
+	}
+
+	return scheduler
+}
+
+// runStatements stores, and optionally e-mails, the statements of a month
+func runStatements(period string, email bool) error {
+	result, err := statements.Run(database.DB, period, email, mailer.Default)
+	if result.Created > 0 || result.Emailed > 0 || result.Failed > 0 || result.EmailFailed > 0 {
+		log.Printf("Statements %s: %d users, %d created, %d e-mailed, %d e-mails failed, %d failed",
+			period, result.Users, result.Created, result.Emailed, result.EmailFailed, result.Failed)
+	}
+	return err
+}
+
+// closeDays closes out every business day that has ended
+func closeDays() error {
+	closeouts, err := reports.CloseDueDays(database.DB)
+	for _, co := range closeouts {
+		log.Printf("Closed business day %s: opening %.2f, closing %.2f", co.BusinessDate, co.OpeningBalance, co.ClosingBalance)
+	}
+	return err
+}
+
+// archiveLogs moves logs past their retention to the archive directory
+func archiveLogs(cfg *config.Config, retention archive.Policy) error {
+	// Only sealed logs are archived
+	if err := sealChains(); err != nil {
+		return err
+	}
+
+	prunes, err := archive.Run(database.DB, cfg.LogArchiveDir, retention, time.Now().UTC())
+	for _, p := range prunes {
+		log.Printf("Archived %d %s logs to %s", p.Rows, p.Category, p.Archive)
+	}
+	return err
+}
+
+// sealChains adds all new logs and transactions to their hash chains
+func sealChains() error {
+	for _, ch := range chain.All {
+		if _, err := ch.Seal(database.DB); err != nil {
+			return err
+		}
+	}
+	return nil
+}
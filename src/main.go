@@ -1,14 +1,27 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
+	// Time zones work without zoneinfo in the container image
+	_ "time/tzdata"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lazypwny751/hudautomata/pkg/archive"
+	"github.com/lazypwny751/hudautomata/pkg/chain"
 	"github.com/lazypwny751/hudautomata/pkg/config"
 	"github.com/lazypwny751/hudautomata/pkg/database"
+	"github.com/lazypwny751/hudautomata/pkg/fraud"
+	"github.com/lazypwny751/hudautomata/pkg/logwriter"
+	"github.com/lazypwny751/hudautomata/pkg/mailer"
+	"github.com/lazypwny751/hudautomata/pkg/reports"
 	"github.com/lazypwny751/hudautomata/pkg/routes"
+	"gorm.io/gorm/logger"
 )
 
 func main() {
@@ -18,21 +31,98 @@ func main() {
 	// Set Gin mode
 	gin.SetMode(cfg.Environment)
 
+	// Keep SQL logging out of maintenance command output
+	if len(os.Args) > 1 {
+		database.LogLevel = logger.Silent
+	}
+
 	// Connect to database
 	if err := database.Connect(); err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
+	// Checkpoints are signed with a key kept next to the database
+	if err := chain.LoadSigningKey(cfg.ChainSigningKeyFile); err != nil {
+		log.Fatalf("Failed to load checkpoint signing key: %v", err)
+	}
+
+	retention, err := archive.ParsePolicy(cfg.LogRetention)
+	if err != nil {
+		log.Fatalf("Invalid LOG_RETENTION: %v", err)
+	}
+
+	// Day boundaries in stats, charts and reports follow the business time zone
+	reports.Location, err = reports.LoadLocation(cfg.BusinessTimezone)
+	if err != nil {
+		log.Fatalf("Invalid BUSINESS_TIMEZONE: %v", err)
+	}
+
+	// Statements and portal login links are e-mailed through SMTP_HOST, or
+	// written to MAIL_DIR when only that is set
+	mailer.Setup(mailer.Options{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.SMTPFrom,
+		Dir:      cfg.MailDir,
+	})
+
+	// Scans are checked against the fraud rules unless FRAUD_ENABLED=false
+	if cfg.FraudEnabled {
+		fraud.Default, err = fraud.New(fraud.Options{
+			TravelWindow:         cfg.FraudTravelWindow,
+			TravelDistanceKm:     cfg.FraudTravelDistanceKm,
+			BurstScans:           cfg.FraudBurstScans,
+			BurstWindow:          cfg.FraudBurstWindow,
+			OpeningHours:         cfg.FraudOpeningHours,
+			LargeSpendFactor:     cfg.FraudLargeSpendFactor,
+			LargeSpendMinHistory: cfg.FraudLargeSpendHistory,
+			AutoBlock:            cfg.FraudAutoBlock,
+			Location:             reports.Location,
+		})
+		if err != nil {
+			log.Fatalf("Invalid fraud settings: %v", err)
+		}
+	}
+
+	// Maintenance commands, e.g. `hudautomata verify-chain`
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(cfg, retention, os.Args[1:]))
+	}
+
 	// Seed initial data
 	if err := database.SeedData(); err != nil {
 		log.Printf("Warning: Failed to seed data: %v", err)
 	}
 
-	// Initialize Gin router
-	r := gin.Default()
+	// Build the rollups on first start or after BUSINESS_TIMEZONE changed
+	if err := reports.EnsureRollups(database.DB); err != nil {
+		log.Printf("Warning: Failed to build transaction rollups: %v", err)
+	}
+
+	// Start the batched system log writer
+	logs := logwriter.Start(database.DB, logwriter.Options{
+		BufferSize:    cfg.LogBufferSize,
+		BatchSize:     cfg.LogBatchSize,
+		FlushInterval: cfg.LogFlushInterval,
+		DropPolicy:    cfg.LogDropPolicy,
+	})
 
-	// Setup routes
-	routes.SetupRoutes(r)
+	// Scans are inspected one at a time off the request path
+	if fraud.Default != nil {
+		fraud.Start(database.DB, cfg.FraudQueueSize)
+	}
+
+	// Start background jobs
+	ctx, stopJobs := context.WithCancel(context.Background())
+	scheduler := startJobs(ctx, cfg, retention)
+
+	// Initialize Gin router with routes
+	r, err := routes.NewRouter(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -48,8 +138,43 @@ func main() {
 	log.Printf("📊 Environment: %s", cfg.Environment)
 	log.Printf("💾 Database: %s", cfg.DBDriver)
 	
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Failed to start server: %v", err)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// Wait for interrupt, then drain requests and flush queued logs
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("Shutting down server...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown failed: %v", err)
+	}
+	if err := logs.Close(shutdownCtx); err != nil {
+		log.Printf("Failed to flush logs: %v", err)
+	}
+	if err := fraud.Scans.Close(shutdownCtx); err != nil {
+		log.Printf("Failed to finish fraud checks: %v", err)
 	}
-}
 
+	stopJobs()
+	scheduler.Wait()
+
+	// Seal what was written during shutdown
+	if err := sealChains(); err != nil {
+		log.Printf("Failed to seal chains: %v", err)
+	}
+
+	stats := logs.Stats()
+	log.Printf("Logs written: %d, dropped: %d, failed: %d", stats.Written, stats.Dropped, stats.Failed)
+	if dropped := fraud.Scans.Dropped(); dropped > 0 {
+		log.Printf("Scans not checked for fraud: %d", dropped)
+	}
+}